@@ -0,0 +1,496 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// exportSection is one block of the personal data archive. Every query takes
+// the user's id for each of its placeholders.
+type exportSection struct {
+	Name  string
+	Query string
+}
+
+// exportSections lists everything we hold about a user beyond the profile itself.
+// New tables holding personal data should be added here and to accountErasure.
+var exportSections = []exportSection{
+	{"privacy", `SELECT * FROM user_privacy WHERE user_id = ?`},
+	{"skills", `SELECT skill, proficiency, verified_at, verification_expires_at FROM user_skills WHERE user_id = ?`},
+	{"following", `SELECT u.id, u.name FROM follows f JOIN users u ON u.id = f.following_id WHERE f.follower_id = ?`},
+	{"followers", `SELECT u.id, u.name FROM follows f JOIN users u ON u.id = f.follower_id WHERE f.following_id = ?`},
+	{"blocked", `SELECT blocked_id, created_at FROM user_blocks WHERE blocker_id = ?`},
+	{"muted", `SELECT muted_id, created_at FROM user_mutes WHERE muter_id = ?`},
+	{"registrations", `SELECT e.id, e.name, e.date, e.location_address FROM registrations r JOIN events e ON e.id = r.event_id WHERE r.user_id = ?`},
+	{"eventsOrganized", `SELECT id, name, date, description, location_address, image_url FROM events WHERE created_by_user_id = ?`},
+	{"groups", `SELECT g.id, g.name, m.role FROM group_members m JOIN groups g ON g.id = m.group_id WHERE m.user_id = ?`},
+	{"groupJoinRequests", `SELECT g.id, g.name FROM group_join_requests r JOIN groups g ON g.id = r.group_id WHERE r.user_id = ?`},
+	{"inviteLinksUsed", `SELECT l.kind, l.target_id, x.used_at FROM invite_link_uses x JOIN invite_links l ON l.id = x.link_id WHERE x.user_id = ?`},
+	{"groupJoinAnswers", `SELECT a.group_id, q.prompt, a.answer FROM group_join_answers a JOIN group_questions q ON q.id = a.question_id WHERE a.user_id = ?`},
+	{"groupBans", `SELECT g.id, g.name, b.reason, b.created_at FROM group_bans b JOIN groups g ON g.id = b.group_id WHERE b.user_id = ?`},
+	{"groupPosts", `SELECT group_id, kind, title, body, created_at FROM group_posts WHERE author_id = ?`},
+	{"groupComments", `SELECT c.post_id, p.group_id, c.body, c.created_at FROM group_post_comments c JOIN group_posts p ON p.id = c.post_id WHERE c.author_id = ?`},
+	{"groupReactions", `SELECT post_id, reaction, created_at FROM group_post_reactions WHERE user_id = ?`},
+	{"groupModerationActions", `SELECT group_id, action, target_user_id, details, created_at FROM group_audit_log WHERE actor_id = ?`},
+	{"invitationsSent", `SELECT id, receiver_id, invite_type, reference_id, status, created_at, expires_at FROM invitations WHERE sender_id = ?`},
+	{"notifications", `SELECT type, payload, read_at, created_at FROM notifications WHERE user_id = ?`},
+	{"notificationPreferences", `SELECT type, channel FROM notification_preferences WHERE user_id = ?`},
+	{"notificationSettings", `SELECT digest_frequency, quiet_start, quiet_end, timezone, last_digest_at FROM notification_settings WHERE user_id = ?`},
+	{"messagesSent", `SELECT conversation_id, body, event_id, created_at FROM messages WHERE sender_id = ?`},
+	{"conversations", `SELECT conversation_id, last_read_message_id, joined_at, left_at FROM conversation_participants WHERE user_id = ?`},
+	{"invitationsReceived", `SELECT id, sender_id, invite_type, reference_id, status, created_at FROM invitations WHERE receiver_id = ?`},
+	{"availability", `SELECT weekday, start_time, end_time FROM availability_windows WHERE user_id = ?`},
+	{"blackoutDates", `SELECT start_date, end_date, reason FROM availability_blackouts WHERE user_id = ?`},
+	{"certifications", `SELECT ct.name AS type, uc.issuer, uc.expires_at, uc.document_name, uc.status, uc.created_at FROM user_certifications uc JOIN certification_types ct ON ct.id = uc.certification_type_id WHERE uc.user_id = ?`},
+	{"linkedIdentities", `SELECT issuer, email, created_at FROM user_identities WHERE user_id = ?`},
+}
+
+// accountErasure runs when a deletion comes due. Registrations, events and groups
+// stay so organizers' records remain intact; the users row is anonymized instead.
+var accountErasure = []string{
+	`DELETE FROM user_skills WHERE user_id = ?`,
+	`DELETE FROM follows WHERE follower_id = ? OR following_id = ?`,
+	`DELETE FROM user_blocks WHERE blocker_id = ? OR blocked_id = ?`,
+	`DELETE FROM user_mutes WHERE muter_id = ? OR muted_id = ?`,
+	`DELETE FROM group_members WHERE user_id = ?`,
+	`DELETE FROM group_join_requests WHERE user_id = ?`,
+	`DELETE FROM group_join_answers WHERE user_id = ?`,
+	`DELETE FROM invite_link_uses WHERE user_id = ?`,
+	`DELETE FROM notifications WHERE user_id = ?`,
+	`DELETE FROM stream_events WHERE user_id = ?`,
+	`DELETE FROM notification_preferences WHERE user_id = ?`,
+	`DELETE FROM notification_settings WHERE user_id = ?`,
+	`DELETE FROM event_reminders_sent WHERE user_id = ?`,
+	`DELETE FROM webhook_deliveries WHERE event_id IN ( SELECT id FROM webhook_events WHERE json_extract(data, '$.user.id') = ? )`,
+	`DELETE FROM webhook_events WHERE json_extract(data, '$.user.id') = ?`,
+	`UPDATE webhooks SET created_by = NULL WHERE created_by = ?`,
+	`UPDATE notifications SET actor_id = NULL WHERE actor_id = ?`,
+	`DELETE FROM messages WHERE sender_id = ?`,
+	`DELETE FROM conversation_participants WHERE user_id = ?`,
+	`UPDATE conversations SET created_by = NULL WHERE created_by = ?`,
+	`DELETE FROM group_bans WHERE user_id = ?`,
+	`DELETE FROM group_post_reactions WHERE user_id = ? OR post_id IN ( SELECT id FROM group_posts WHERE author_id = ? )`,
+	`DELETE FROM group_post_comments WHERE author_id = ? OR post_id IN ( SELECT id FROM group_posts WHERE author_id = ? )`,
+	`DELETE FROM group_posts WHERE author_id = ?`,
+	`DELETE FROM invitations WHERE sender_id = ? OR receiver_id = ?`,
+	`DELETE FROM user_links WHERE user_id = ?`,
+	`DELETE FROM user_languages WHERE user_id = ?`,
+	`DELETE FROM email_verifications WHERE user_id = ?`,
+	`DELETE FROM user_recovery_codes WHERE user_id = ?`,
+	`DELETE FROM user_identities WHERE user_id = ?`,
+	`DELETE FROM user_privacy WHERE user_id = ?`,
+	`DELETE FROM availability_windows WHERE user_id = ?`,
+	`DELETE FROM availability_blackouts WHERE user_id = ?`,
+	`DELETE FROM user_certifications WHERE user_id = ?`,
+}
+
+// Stored files carry the uploader's id: pfp-<user>-<ts>, <ts>-<user> for event
+// images and group-<ts>-<user> for group images in uploads/, and cert-<user>-<ts>
+// in documents/.
+var userFilePatterns = map[string][]*regexp.Regexp{
+	"uploads": {
+		regexp.MustCompile(`^pfp-(\d+)-\d+`),
+		regexp.MustCompile(`^(?:group-)?\d+-(\d+)\.`),
+	},
+	documentsDir: {
+		regexp.MustCompile(`^cert-(\d+)-\d+`),
+	},
+}
+
+const deletedUserName = "Deleted user"
+
+func accountDeletionGrace() time.Duration {
+	return time.Duration(getEnvInt("ACCOUNT_DELETION_GRACE_DAYS", 30)) * 24 * time.Hour
+}
+
+// uploadedFilesOf returns the paths of every file the user uploaded.
+func uploadedFilesOf(userID int) ([]string, error) {
+	owner := strconv.Itoa(userID)
+	var files []string
+	for dir, patterns := range userFilePatterns {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			for _, pattern := range patterns {
+				if m := pattern.FindStringSubmatch(entry.Name()); m != nil && m[1] == owner {
+					files = append(files, filepath.Join(dir, entry.Name()))
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// queryMaps returns rows keyed by column name, for the export.
+func queryMaps(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[column] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// placeholderArgs repeats id once per "?" in query.
+func placeholderArgs(query string, id int) []interface{} {
+	args := []interface{}{}
+	for _, r := range query {
+		if r == '?' {
+			args = append(args, id)
+		}
+	}
+	return args
+}
+
+func buildDataExport(userID int) (map[string]interface{}, error) {
+	var u User
+	var totpEnabled bool
+	err := db.QueryRow(`SELECT id, name, email, role, profile_image_url, totp_enabled FROM users WHERE id = ?`, userID).
+		Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.ProfileImageURL, &totpEnabled)
+	if err != nil {
+		return nil, err
+	}
+	details, err := loadProfileDetails([]int{userID})
+	if err != nil {
+		return nil, err
+	}
+	u.ProfileDetails = *details[userID]
+	db.QueryRow(`SELECT email FROM email_verifications WHERE user_id = ?`, userID).Scan(&u.PendingEmail)
+	export := map[string]interface{}{
+		"exportedAt":       time.Now().UTC(),
+		"profile":          u,
+		"twoFactorEnabled": totpEnabled,
+	}
+	for _, section := range exportSections {
+		rows, err := queryMaps(section.Query, placeholderArgs(section.Query, userID)...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", section.Name, err)
+		}
+		export[section.Name] = rows
+	}
+	return export, nil
+}
+
+// anonymizeAccount erases a user's personal data while keeping the row, so
+// registrations and events that point at it still resolve.
+func anonymizeAccount(userID int) error {
+	files, err := uploadedFilesOf(userID)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Group images the user set go with their files, so clear them before the
+	// groups are handed over. Handover needs the memberships erasure removes.
+	if _, err := tx.Exec(`UPDATE groups SET profile_image_url = '' WHERE created_by_user_id = ?`, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := handOverGroupsOf(tx, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, stmt := range accountErasure {
+		if _, err := tx.Exec(stmt, placeholderArgs(stmt, userID)...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	// Keep the tombstone out of search and suggestions.
+	_, err = tx.Exec(`
+		INSERT INTO user_privacy (user_id, email_visibility, phone_visibility, registrations_visibility, followers_visibility, skills_visibility, availability_visibility, messages_from, discoverable, private_account)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, 1)
+	`, userID, audienceOnlyMe, audienceOnlyMe, audienceOnlyMe, audienceOnlyMe, audienceOnlyMe, audienceOnlyMe, audienceOnlyMe)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE users SET name = ?, email = ?, password_hash = '', profile_image_url = '',
+			bio = '', pronouns = '', city = '', phone = '',
+			totp_secret = NULL, totp_pending_secret = NULL, totp_enabled = 0, totp_last_counter = 0,
+			deletion_scheduled_for = NULL, deleted_at = ?
+		WHERE id = ?
+	`, deletedUserName, fmt.Sprintf("deleted-%d@deleted.invalid", userID), sqlTime(time.Now()), userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Images on events the user created go with the files.
+	if _, err := tx.Exec(`UPDATE events SET image_url = '' WHERE created_by_user_id = ?`, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, path := range files {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("anonymizeAccount: failed to remove %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// soleAdminGroups lists the groups userID is the only admin of. Deletion is
+// refused until they are handed over, as when leaving a group.
+func soleAdminGroups(userID int) ([]gin.H, error) {
+	rows, err := db.Query(`
+		SELECT g.id, g.name FROM groups g JOIN group_members gm ON gm.group_id = g.id
+		WHERE gm.user_id = ? AND gm.role = 'admin'
+		AND NOT EXISTS ( SELECT 1 FROM group_members o WHERE o.group_id = g.id AND o.role = 'admin' AND o.user_id != gm.user_id )
+		ORDER BY g.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := []gin.H{}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		groups = append(groups, gin.H{"id": id, "name": name})
+	}
+	return groups, rows.Err()
+}
+
+// handOverGroupsOf passes on the groups a departing user owns or is the last
+// admin of, to the earliest-joined other admin or else member. It backs up the
+// check at request time, since the other admins may leave during the grace
+// period. Groups with nobody else in them are left as they are.
+func handOverGroupsOf(tx *sql.Tx, userID int) error {
+	rows, err := tx.Query(`
+		SELECT g.id FROM groups g JOIN group_members gm ON gm.group_id = g.id AND gm.user_id = ? AND gm.role = 'admin'
+		WHERE g.created_by_user_id = ?
+		OR NOT EXISTS ( SELECT 1 FROM group_members o WHERE o.group_id = g.id AND o.role = 'admin' AND o.user_id != ? )
+	`, userID, userID, userID)
+	if err != nil {
+		return err
+	}
+	var groupIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		groupIDs = append(groupIDs, id)
+	}
+	rows.Close()
+	for _, groupID := range groupIDs {
+		var successorID int
+		err := tx.QueryRow(`
+			SELECT gm.user_id FROM group_members gm JOIN users u ON u.id = gm.user_id
+			WHERE gm.group_id = ? AND gm.user_id != ? AND u.deleted_at IS NULL AND u.deletion_scheduled_for IS NULL
+			ORDER BY gm.role = 'admin' DESC, gm.rowid LIMIT 1
+		`, groupID, userID).Scan(&successorID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := handOverGroup(tx, groupID, userID, successorID); err != nil {
+			return err
+		}
+		if err := logGroupAction(tx, groupID, userID, auditAdminHandover, successorID, "account deleted"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeDeletedAccounts anonymizes accounts whose grace period has run out.
+func purgeDeletedAccounts() error {
+	rows, err := db.Query(`SELECT id FROM users WHERE deleted_at IS NULL AND deletion_scheduled_for <= ?`, sqlTime(time.Now()))
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	for _, id := range ids {
+		if err := anonymizeAccount(id); err != nil {
+			log.Printf("purgeDeletedAccounts: user %d: %v", id, err)
+			continue
+		}
+		log.Printf("Deleted account %d", id)
+	}
+	return nil
+}
+
+// --- Account Data Handlers ---
+
+// ExportMyDataHandler returns a zip with data.json and the files I uploaded.
+func ExportMyDataHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	export, err := buildDataExport(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Println("ExportMyData error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	files, err := uploadedFilesOf(userID)
+	if err != nil {
+		log.Println("ExportMyData (uploads) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploads"})
+		return
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("data.json")
+	if err == nil {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(export)
+	}
+	for _, path := range files {
+		if err != nil {
+			break
+		}
+		var content []byte
+		content, err = os.ReadFile(path)
+		if err != nil {
+			break
+		}
+		w, err = zw.Create(filepath.ToSlash(path))
+		if err == nil {
+			_, err = w.Write(content)
+		}
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Println("ExportMyData (zip) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build export"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="vms-data-%d.zip"`, userID))
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+func GetAccountDeletionHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	var scheduledFor sql.NullTime
+	if err := db.QueryRow(`SELECT deletion_scheduled_for FROM users WHERE id = ?`, userID).Scan(&scheduledFor); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !scheduledFor.Valid {
+		c.JSON(http.StatusOK, gin.H{"scheduled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"scheduled": true, "scheduledFor": scheduledFor.Time})
+}
+
+// RequestAccountDeletionHandler schedules my account for deletion after the grace
+// period. Accounts with a password must confirm it.
+func RequestAccountDeletionHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	var payload struct {
+		Password string `json:"password"`
+	}
+	c.ShouldBindJSON(&payload)
+	var passwordHash string
+	if err := db.QueryRow(`SELECT password_hash FROM users WHERE id = ? AND deleted_at IS NULL`, userID).Scan(&passwordHash); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if passwordHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(payload.Password)); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+	}
+	groups, err := soleAdminGroups(userID)
+	if err != nil {
+		log.Println("RequestAccountDeletion (groups) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(groups) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "You are the last admin of these groups. Hand them over or delete them first.", "groups": groups})
+		return
+	}
+	scheduledFor := time.Now().Add(accountDeletionGrace()).UTC()
+	_, err = db.Exec(`UPDATE users SET deletion_scheduled_for = ? WHERE id = ?`, sqlTime(scheduledFor), userID)
+	if err != nil {
+		log.Println("RequestAccountDeletion error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account scheduled for deletion", "scheduledFor": scheduledFor})
+}
+
+func CancelAccountDeletionHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	_, err := db.Exec(`UPDATE users SET deletion_scheduled_for = NULL WHERE id = ? AND deleted_at IS NULL`, userID)
+	if err != nil {
+		log.Println("CancelAccountDeletion error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account deletion cancelled"})
+}
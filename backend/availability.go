@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	clockLayout = "15:04"
+	dateLayout  = "2006-01-02"
+)
+
+// Availability statuses for a volunteer at a given event.
+const (
+	availabilityAvailable   = "available"
+	availabilityUnavailable = "unavailable"
+	availabilityUnknown     = "unknown" // no weekly windows declared
+)
+
+type AvailabilityWindow struct {
+	Weekday   int    `json:"weekday"` // 0 = Sunday
+	StartTime string `json:"startTime"`
+	EndTime   string `json:"endTime"`
+}
+
+type BlackoutDate struct {
+	StartDate string `json:"startDate"`
+	EndDate   string `json:"endDate"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type Availability struct {
+	Weekly    []AvailabilityWindow `json:"weekly"`
+	Blackouts []BlackoutDate       `json:"blackouts"`
+	Status    string               `json:"status,omitempty"` // set when checked against an event
+}
+
+// AvailableUser is a user listed alongside their availability.
+type AvailableUser struct {
+	User
+	Availability *Availability `json:"availability"`
+}
+
+// validateTimeRange accepts an empty range (all day) or two "15:04" times in order.
+func validateTimeRange(start, end string) error {
+	if start == "" && end == "" {
+		return nil
+	}
+	s, err1 := time.Parse(clockLayout, start)
+	e, err2 := time.Parse(clockLayout, end)
+	if err1 != nil || err2 != nil {
+		return errors.New("Times must be given as HH:MM")
+	}
+	if !e.After(s) {
+		return errors.New("End time must be after start time")
+	}
+	return nil
+}
+
+// statusAt reports whether the volunteer can make an event on date between start
+// and end. Without event times, any window on that weekday counts.
+func (a *Availability) statusAt(date, start, end string) string {
+	day, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return availabilityUnknown
+	}
+	for _, b := range a.Blackouts {
+		if date >= b.StartDate && date <= b.EndDate {
+			return availabilityUnavailable
+		}
+	}
+	if len(a.Weekly) == 0 {
+		return availabilityUnknown
+	}
+	for _, w := range a.Weekly {
+		if w.Weekday != int(day.Weekday()) {
+			continue
+		}
+		// "HH:MM" strings compare correctly as text.
+		if start == "" || (w.StartTime <= start && w.EndTime >= end) {
+			return availabilityAvailable
+		}
+	}
+	return availabilityUnavailable
+}
+
+// loadAvailability reads the availability of each of ids. Blackout reasons are
+// only for their owner, so withReasons is set just when users read their own.
+func loadAvailability(ids []int, withReasons bool) (map[int]*Availability, error) {
+	result := make(map[int]*Availability, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		result[id] = &Availability{Weekly: []AvailabilityWindow{}, Blackouts: []BlackoutDate{}}
+		args[i] = id
+	}
+	placeholders := "?" + strings.Repeat(",?", len(ids)-1)
+	rows, err := db.Query(`
+		SELECT user_id, weekday, start_time, end_time FROM availability_windows
+		WHERE user_id IN (`+placeholders+`)
+		ORDER BY weekday, start_time
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var userID int
+		var w AvailabilityWindow
+		if err := rows.Scan(&userID, &w.Weekday, &w.StartTime, &w.EndTime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		result[userID].Weekly = append(result[userID].Weekly, w)
+	}
+	rows.Close()
+	rows, err = db.Query(`
+		SELECT user_id, start_date, end_date, reason FROM availability_blackouts
+		WHERE user_id IN (`+placeholders+`) AND end_date >= ?
+		ORDER BY start_date
+	`, append(args, time.Now().Format(dateLayout))...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int
+		var b BlackoutDate
+		if err := rows.Scan(&userID, &b.StartDate, &b.EndDate, &b.Reason); err != nil {
+			return nil, err
+		}
+		if !withReasons {
+			b.Reason = ""
+		}
+		result[userID].Blackouts = append(result[userID].Blackouts, b)
+	}
+	return result, rows.Err()
+}
+
+// --- Availability Handlers ---
+
+func GetMyAvailabilityHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	availability, err := loadAvailability([]int{userID}, true)
+	if err != nil {
+		log.Println("GetMyAvailability error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, availability[userID])
+}
+
+// UpdateMyAvailabilityHandler replaces my weekly windows and blackout dates.
+func UpdateMyAvailabilityHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	var payload struct {
+		Weekly    []AvailabilityWindow `json:"weekly"`
+		Blackouts []BlackoutDate       `json:"blackouts"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	for _, w := range payload.Weekly {
+		if w.Weekday < 0 || w.Weekday > 6 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Weekday must be between 0 (Sunday) and 6 (Saturday)"})
+			return
+		}
+		if w.StartTime == "" || w.EndTime == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Each window needs a start and end time"})
+			return
+		}
+		if err := validateTimeRange(w.StartTime, w.EndTime); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	for _, b := range payload.Blackouts {
+		_, err1 := time.Parse(dateLayout, b.StartDate)
+		_, err2 := time.Parse(dateLayout, b.EndDate)
+		if err1 != nil || err2 != nil || b.EndDate < b.StartDate {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Blackout dates must be YYYY-MM-DD with the end on or after the start"})
+			return
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("UpdateMyAvailability (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for _, stmt := range []string{
+		`DELETE FROM availability_windows WHERE user_id = ?`,
+		`DELETE FROM availability_blackouts WHERE user_id = ?`,
+	} {
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			tx.Rollback()
+			log.Println("UpdateMyAvailability (delete) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	for _, w := range payload.Weekly {
+		_, err := tx.Exec(`INSERT INTO availability_windows (user_id, weekday, start_time, end_time) VALUES (?, ?, ?, ?)`, userID, w.Weekday, w.StartTime, w.EndTime)
+		if err != nil {
+			tx.Rollback()
+			log.Println("UpdateMyAvailability (window) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	for _, b := range payload.Blackouts {
+		_, err := tx.Exec(`INSERT INTO availability_blackouts (user_id, start_date, end_date, reason) VALUES (?, ?, ?, ?)`, userID, b.StartDate, b.EndDate, strings.TrimSpace(b.Reason))
+		if err != nil {
+			tx.Rollback()
+			log.Println("UpdateMyAvailability (blackout) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("UpdateMyAvailability (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Availability updated successfully"})
+}
+
+// GetSuggestedVolunteersHandler lists volunteers who are free at the event's time
+// and not yet registered. People the organizer follows come first. Pass
+// includeUnknown=true to also list volunteers who haven't declared availability.
+// Only discoverable volunteers whose availability the organizer may see are
+// considered.
+func GetSuggestedVolunteersHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	role := c.GetString("role")
+	eventID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+	var date, startTime, endTime string
+	err = db.QueryRow(`SELECT date, start_time, end_time FROM events WHERE id = ?`, eventID).Scan(&date, &startTime, &endTime)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+	if allowed, _ := canManageEvent(eventID, myID, role); !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the event's organizer can see suggestions"})
+		return
+	}
+	users, err := queryUsers(`
+		SELECT u.id, u.name, u.email, u.role, u.profile_image_url
+		FROM users u LEFT JOIN user_privacy p ON p.user_id = u.id
+		WHERE u.role = 'Volunteer' AND u.deleted_at IS NULL AND COALESCE(p.discoverable, 1) = 1
+		AND u.id NOT IN ( SELECT user_id FROM registrations WHERE event_id = ? )
+		AND `+notBlockedClause("u.id")+`
+		ORDER BY u.name
+	`, eventID, myID, myID)
+	if err != nil {
+		log.Println("GetSuggestedVolunteers error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	ids := make([]int, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	privacy, err := newPrivacyContext(myID, role, ids)
+	if err != nil {
+		log.Println("GetSuggestedVolunteers (privacy) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	availability, err := loadAvailability(ids, false)
+	if err != nil {
+		log.Println("GetSuggestedVolunteers (availability) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	following := make(map[int]bool)
+	rows, err := db.Query(`SELECT following_id FROM follows WHERE follower_id = ?`, myID)
+	if err != nil {
+		log.Println("GetSuggestedVolunteers (follows) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			following[id] = true
+		}
+	}
+	rows.Close()
+	includeUnknown := c.Query("includeUnknown") == "true"
+	suggested := []AvailableUser{}
+	for _, u := range users {
+		if !privacy.canSeeAvailability(u.ID) {
+			continue
+		}
+		a := availability[u.ID]
+		a.Status = a.statusAt(date, startTime, endTime)
+		if a.Status == availabilityAvailable || (includeUnknown && a.Status == availabilityUnknown) {
+			u.IsFollowed = following[u.ID]
+			suggested = append(suggested, AvailableUser{User: u, Availability: a})
+		}
+	}
+	sort.SliceStable(suggested, func(i, j int) bool {
+		if suggested[i].Availability.Status != suggested[j].Availability.Status {
+			return suggested[i].Availability.Status == availabilityAvailable
+		}
+		return suggested[i].IsFollowed && !suggested[j].IsFollowed
+	})
+	for i := range suggested {
+		privacy.redactUser(&suggested[i].User)
+	}
+	c.JSON(http.StatusOK, gin.H{"users": suggested})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notBlockedClause filters column down to users with no block either way with
+// the viewer. Callers pass the viewer's id twice for the two placeholders.
+func notBlockedClause(column string) string {
+	return column + ` NOT IN (
+		SELECT blocked_id FROM user_blocks WHERE blocker_id = ?
+		UNION SELECT blocker_id FROM user_blocks WHERE blocked_id = ?
+	)`
+}
+
+// isBlockedEitherWay reports whether either user has blocked the other.
+func isBlockedEitherWay(a, b int) bool {
+	var n int
+	db.QueryRow(`
+		SELECT COUNT(*) FROM user_blocks
+		WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)
+	`, a, b, b, a).Scan(&n)
+	return n > 0
+}
+
+// mutedUserIDs returns who the user has muted. Muting is private to the muter.
+func mutedUserIDs(userID int) (map[int]bool, error) {
+	rows, err := db.Query(`SELECT muted_id FROM user_mutes WHERE muter_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	muted := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		muted[id] = true
+	}
+	return muted, rows.Err()
+}
+
+// --- Block Handlers ---
+
+// BlockUserHandler blocks a user. Follows in both directions and any pending
+// invitations or follow requests between the two are removed.
+func BlockUserHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	blockID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if myID == blockID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot block yourself"})
+		return
+	}
+	var exists int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ?`, blockID).Scan(&exists); err != nil || exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("BlockUser (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO user_blocks (blocker_id, blocked_id, created_at) VALUES (?, ?, ?)`, myID, blockID, sqlTime(time.Now()))
+	if err != nil {
+		tx.Rollback()
+		log.Println("BlockUser (insert) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	_, err = tx.Exec(`
+		DELETE FROM follows
+		WHERE (follower_id = ? AND following_id = ?) OR (follower_id = ? AND following_id = ?)
+	`, myID, blockID, blockID, myID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("BlockUser (follows) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	_, err = tx.Exec(`
+		DELETE FROM invitations
+		WHERE status = 'pending'
+		AND ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))
+	`, myID, blockID, blockID, myID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("BlockUser (invitations) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("BlockUser (tx commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User blocked"})
+}
+
+// UnblockUserHandler lifts a block. Removed follows are not restored.
+func UnblockUserHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	blockID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	_, err = db.Exec(`DELETE FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?`, myID, blockID)
+	if err != nil {
+		log.Println("UnblockUser error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User unblocked"})
+}
+
+func GetBlockedUsersHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	users, err := queryUsers(`
+		SELECT u.id, u.name, u.email, u.role, u.profile_image_url
+		FROM users u
+		JOIN user_blocks b ON b.blocked_id = u.id
+		WHERE b.blocker_id = ?
+		ORDER BY b.created_at DESC
+	`, myID)
+	if err != nil {
+		log.Println("GetBlockedUsers error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := redactUsers(myID, c.GetString("role"), users); err != nil {
+		log.Println("GetBlockedUsers redact error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// --- Mute Handlers ---
+
+// MuteUserHandler hides a user's activity from my events feed. The muted user
+// is not told and nothing else about the relationship changes.
+func MuteUserHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	muteID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if myID == muteID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot mute yourself"})
+		return
+	}
+	var exists int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ?`, muteID).Scan(&exists); err != nil || exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	_, err = db.Exec(`INSERT OR IGNORE INTO user_mutes (muter_id, muted_id, created_at) VALUES (?, ?, ?)`, myID, muteID, sqlTime(time.Now()))
+	if err != nil {
+		log.Println("MuteUser error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User muted"})
+}
+
+func UnmuteUserHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	muteID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	_, err = db.Exec(`DELETE FROM user_mutes WHERE muter_id = ? AND muted_id = ?`, myID, muteID)
+	if err != nil {
+		log.Println("UnmuteUser error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User unmuted"})
+}
+
+func GetMutedUsersHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	users, err := queryUsers(`
+		SELECT u.id, u.name, u.email, u.role, u.profile_image_url
+		FROM users u
+		JOIN user_mutes m ON m.muted_id = u.id
+		WHERE m.muter_id = ?
+		ORDER BY m.created_at DESC
+	`, myID)
+	if err != nil {
+		log.Println("GetMutedUsers error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := redactUsers(myID, c.GetString("role"), users); err != nil {
+		log.Println("GetMutedUsers redact error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
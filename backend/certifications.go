@@ -0,0 +1,552 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Certificate documents are personal, so they live outside the public uploads/
+// directory and are only served through DownloadCertificationDocumentHandler.
+const documentsDir = "documents"
+
+const maxDocumentSize = 10 << 20
+
+var allowedDocumentTypes = []string{".pdf", ".jpg", ".jpeg", ".png"}
+
+// Review statuses for an uploaded certification.
+const (
+	certificationPending  = "pending"
+	certificationVerified = "verified"
+	certificationRejected = "rejected"
+)
+
+type CertificationType struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type Certification struct {
+	ID           int               `json:"id"`
+	UserID       int               `json:"userId"`
+	UserName     string            `json:"userName,omitempty"`
+	Type         CertificationType `json:"type"`
+	Issuer       string            `json:"issuer"`
+	ExpiresAt    string            `json:"expiresAt"` // YYYY-MM-DD
+	DocumentName string            `json:"documentName"`
+	Status       string            `json:"status"`
+	ReviewedBy   *int              `json:"reviewedBy,omitempty"`
+	ReviewedAt   *time.Time        `json:"reviewedAt,omitempty"`
+	ReviewNote   string            `json:"reviewNote,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+}
+
+var defaultCertificationTypes = []CertificationType{
+	{Name: "Food Handler", Description: "Food hygiene certificate for handling and serving food"},
+	{Name: "Lifeguard", Description: "Pool or open-water lifeguard qualification"},
+	{Name: "First Aid", Description: "First aid certificate from a recognised provider"},
+	{Name: "CPR", Description: "Cardiopulmonary resuscitation certificate"},
+	{Name: "Driver's License", Description: "Valid driving licence"},
+	{Name: "Background Check", Description: "Criminal record check for work with children or vulnerable adults"},
+}
+
+func seedCertificationTypes() {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM certification_types`).Scan(&count); err != nil || count > 0 {
+		return
+	}
+	for _, t := range defaultCertificationTypes {
+		if _, err := db.Exec(`INSERT INTO certification_types (name, description) VALUES (?, ?)`, t.Name, t.Description); err != nil {
+			log.Fatalf("Failed to seed certification type %q: %v", t.Name, err)
+		}
+	}
+}
+
+const certificationColumns = `
+	uc.id, uc.user_id, u.name, ct.id, ct.name, ct.description, uc.issuer, uc.expires_at,
+	uc.document_name, uc.status, uc.reviewed_by, uc.reviewed_at, uc.review_note, uc.created_at
+`
+
+const certificationJoins = `
+	FROM user_certifications uc
+	JOIN certification_types ct ON ct.id = uc.certification_type_id
+	JOIN users u ON u.id = uc.user_id
+`
+
+func queryCertifications(query string, args ...interface{}) ([]Certification, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	certs := []Certification{}
+	for rows.Next() {
+		var cert Certification
+		var reviewedBy sql.NullInt64
+		var reviewedAt sql.NullTime
+		if err := rows.Scan(&cert.ID, &cert.UserID, &cert.UserName, &cert.Type.ID, &cert.Type.Name, &cert.Type.Description,
+			&cert.Issuer, &cert.ExpiresAt, &cert.DocumentName, &cert.Status, &reviewedBy, &reviewedAt, &cert.ReviewNote, &cert.CreatedAt); err != nil {
+			return nil, err
+		}
+		if reviewedBy.Valid {
+			by := int(reviewedBy.Int64)
+			cert.ReviewedBy = &by
+		}
+		if reviewedAt.Valid {
+			cert.ReviewedAt = &reviewedAt.Time
+		}
+		certs = append(certs, cert)
+	}
+	return certs, rows.Err()
+}
+
+// canReviewCertification lets admins review anything, and organizers review the
+// certificates of someone registered for one of their events. Requiring a type
+// on an event doesn't open up everyone's documents of that type.
+func canReviewCertification(reviewerID int, role string, ownerID int) bool {
+	return canVerifyVolunteer(reviewerID, role, ownerID)
+}
+
+// missingCertifications lists the certifications an event requires that the user
+// does not hold verified and valid through the event date.
+func missingCertifications(userID, eventID int) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT ct.name
+		FROM event_required_certifications rc
+		JOIN certification_types ct ON ct.id = rc.certification_type_id
+		JOIN events e ON e.id = rc.event_id
+		WHERE rc.event_id = ?
+		AND NOT EXISTS (
+			SELECT 1 FROM user_certifications uc
+			WHERE uc.user_id = ? AND uc.certification_type_id = rc.certification_type_id
+			AND uc.status = 'verified' AND uc.expires_at >= e.date
+		)
+		ORDER BY ct.name
+	`, eventID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var missing []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		missing = append(missing, name)
+	}
+	return missing, rows.Err()
+}
+
+// attachRequiredCertifications fills in RequiredCertifications on each event.
+func attachRequiredCertifications(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(events))
+	index := make(map[int][]int, len(events))
+	for i, e := range events {
+		args[i] = e.ID
+		index[e.ID] = append(index[e.ID], i)
+	}
+	rows, err := db.Query(`
+		SELECT rc.event_id, ct.id, ct.name, ct.description
+		FROM event_required_certifications rc
+		JOIN certification_types ct ON ct.id = rc.certification_type_id
+		WHERE rc.event_id IN (?`+strings.Repeat(",?", len(args)-1)+`)
+		ORDER BY ct.name
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventID int
+		var t CertificationType
+		if err := rows.Scan(&eventID, &t.ID, &t.Name, &t.Description); err != nil {
+			return err
+		}
+		for _, i := range index[eventID] {
+			events[i].RequiredCertifications = append(events[i].RequiredCertifications, t)
+		}
+	}
+	return rows.Err()
+}
+
+// setRequiredCertifications replaces an event's requirements inside tx.
+func setRequiredCertifications(tx *sql.Tx, eventID int, typeIDs []int) error {
+	if _, err := tx.Exec(`DELETE FROM event_required_certifications WHERE event_id = ?`, eventID); err != nil {
+		return err
+	}
+	for _, typeID := range typeIDs {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO event_required_certifications (event_id, certification_type_id) VALUES (?, ?)`, eventID, typeID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCertificationTypeIDs reads ids from a comma-separated form value and checks they exist.
+func parseCertificationTypeIDs(raw []string) ([]int, error) {
+	var ids []int
+	for _, value := range raw {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			id, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid certification type: %s", part)
+			}
+			var exists int
+			db.QueryRow(`SELECT COUNT(*) FROM certification_types WHERE id = ?`, id).Scan(&exists)
+			if exists == 0 {
+				return nil, fmt.Errorf("Unknown certification type: %d", id)
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// sendCertificationReminders notifies volunteers whose verified certificates
+// expire within CERT_EXPIRY_REMINDER_DAYS. Each certificate is reminded about once.
+func sendCertificationReminders() error {
+	today := time.Now()
+	horizon := today.AddDate(0, 0, getEnvInt("CERT_EXPIRY_REMINDER_DAYS", 30))
+	rows, err := db.Query(`
+		SELECT uc.id, uc.user_id, ct.name, uc.expires_at
+		FROM user_certifications uc
+		JOIN certification_types ct ON ct.id = uc.certification_type_id
+		JOIN users u ON u.id = uc.user_id
+		WHERE uc.status = 'verified' AND uc.reminder_sent_at IS NULL
+		AND uc.expires_at >= ? AND uc.expires_at <= ? AND u.deleted_at IS NULL
+	`, today.Format(dateLayout), horizon.Format(dateLayout))
+	if err != nil {
+		return err
+	}
+	type reminder struct {
+		id, userID int
+		certName   string
+		expiresAt  string
+	}
+	var due []reminder
+	for rows.Next() {
+		var r reminder
+		if err := rows.Scan(&r.id, &r.userID, &r.certName, &r.expiresAt); err == nil {
+			due = append(due, r)
+		}
+	}
+	rows.Close()
+	for _, r := range due {
+		// Emailed by default; the holder can move these to the digest or the app only.
+		err := insertNotifications(db, `SELECT ? AS user_id`, []interface{}{r.userID}, notifCertExpiring, 0,
+			gin.H{"certificationId": r.id, "certificationName": r.certName, "expiresAt": r.expiresAt}, defaultChannel(notifCertExpiring))
+		if err != nil {
+			log.Printf("Certification reminder for user %d failed: %v", r.userID, err)
+			continue
+		}
+		db.Exec(`UPDATE user_certifications SET reminder_sent_at = ? WHERE id = ?`, sqlTime(time.Now()), r.id)
+	}
+	return nil
+}
+
+// --- Certification Handlers ---
+
+func GetCertificationTypesHandler(c *gin.Context) {
+	rows, err := db.Query(`SELECT id, name, description FROM certification_types ORDER BY name`)
+	if err != nil {
+		log.Println("GetCertificationTypes error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	types := []CertificationType{}
+	for rows.Next() {
+		var t CertificationType
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description); err != nil {
+			log.Println("GetCertificationTypes scan error:", err)
+			continue
+		}
+		types = append(types, t)
+	}
+	c.JSON(http.StatusOK, gin.H{"certificationTypes": types})
+}
+
+func CreateCertificationTypeHandler(c *gin.Context) {
+	if c.GetString("role") != "Admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+	var payload CertificationType
+	if err := c.ShouldBindJSON(&payload); err != nil || strings.TrimSpace(payload.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	res, err := db.Exec(`INSERT INTO certification_types (name, description) VALUES (?, ?)`, strings.TrimSpace(payload.Name), strings.TrimSpace(payload.Description))
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Certification type already exists"})
+		return
+	}
+	id, _ := res.LastInsertId()
+	payload.ID = int(id)
+	c.JSON(http.StatusCreated, payload)
+}
+
+func GetMyCertificationsHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	certs, err := queryCertifications(`SELECT `+certificationColumns+certificationJoins+` WHERE uc.user_id = ? ORDER BY uc.expires_at DESC`, userID)
+	if err != nil {
+		log.Println("GetMyCertifications error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"certifications": certs})
+}
+
+// UploadCertificationHandler stores a certificate document (multipart field
+// "document") with its type, issuer and expiry. It waits for review before it counts.
+func UploadCertificationHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	typeID, err := strconv.Atoi(c.PostForm("certificationTypeId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid certification type"})
+		return
+	}
+	issuer := strings.TrimSpace(c.PostForm("issuer"))
+	expiresAt := c.PostForm("expiresAt")
+	if issuer == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Issuer is required"})
+		return
+	}
+	if expires, err := time.Parse(dateLayout, expiresAt); err != nil || expires.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be a future date (YYYY-MM-DD)"})
+		return
+	}
+	var exists int
+	db.QueryRow(`SELECT COUNT(*) FROM certification_types WHERE id = ?`, typeID).Scan(&exists)
+	if exists == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown certification type"})
+		return
+	}
+	file, err := c.FormFile("document")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No document uploaded"})
+		return
+	}
+	extension := strings.ToLower(filepath.Ext(file.Filename))
+	if !containsString(allowedDocumentTypes, extension) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Documents must be PDF, JPG or PNG"})
+		return
+	}
+	if file.Size > maxDocumentSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Documents must be 10 MB or smaller"})
+		return
+	}
+	if err := os.MkdirAll(documentsDir, 0o700); err != nil {
+		log.Println("UploadCertification (mkdir) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save document"})
+		return
+	}
+	filename := fmt.Sprintf("cert-%d-%d%s", userID, time.Now().UnixNano(), extension)
+	savePath := filepath.Join(documentsDir, filename)
+	if err := c.SaveUploadedFile(file, savePath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save document"})
+		return
+	}
+	res, err := db.Exec(`
+		INSERT INTO user_certifications (user_id, certification_type_id, issuer, expires_at, document_path, document_name, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, userID, typeID, issuer, expiresAt, savePath, filepath.Base(file.Filename), sqlTime(time.Now()))
+	if err != nil {
+		os.Remove(savePath)
+		log.Println("UploadCertification error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	id, _ := res.LastInsertId()
+	certs, err := queryCertifications(`SELECT `+certificationColumns+certificationJoins+` WHERE uc.id = ?`, id)
+	if err != nil || len(certs) == 0 {
+		log.Println("UploadCertification (reload) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusCreated, certs[0])
+}
+
+func DeleteCertificationHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	certID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid certification ID"})
+		return
+	}
+	var path string
+	err = db.QueryRow(`SELECT document_path FROM user_certifications WHERE id = ? AND user_id = ?`, certID, userID).Scan(&path)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certification not found"})
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM user_certifications WHERE id = ?`, certID); err != nil {
+		log.Println("DeleteCertification error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("DeleteCertification: failed to remove %s: %v", path, err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Certification deleted"})
+}
+
+// DownloadCertificationDocumentHandler serves the document to its owner and to
+// anyone allowed to review it.
+func DownloadCertificationDocumentHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	certID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid certification ID"})
+		return
+	}
+	var ownerID int
+	var path, name string
+	err = db.QueryRow(`SELECT user_id, document_path, document_name FROM user_certifications WHERE id = ?`, certID).
+		Scan(&ownerID, &path, &name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certification not found"})
+		return
+	}
+	if ownerID != myID && !canReviewCertification(myID, c.GetString("role"), ownerID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	c.FileAttachment(path, name)
+}
+
+// GetPendingCertificationsHandler is the review queue for admins and organizers.
+func GetPendingCertificationsHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	role := c.GetString("role")
+	query := `SELECT ` + certificationColumns + certificationJoins + ` WHERE uc.status = 'pending'`
+	var args []interface{}
+	switch role {
+	case "Admin":
+	case "Organizer":
+		query += `
+			AND uc.user_id IN (
+				SELECT r.user_id FROM registrations r
+				JOIN events e ON e.id = r.event_id WHERE e.created_by_user_id = ?
+			)`
+		args = append(args, myID)
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	certs, err := queryCertifications(query+` ORDER BY uc.created_at`, args...)
+	if err != nil {
+		log.Println("GetPendingCertifications error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"certifications": certs})
+}
+
+// ReviewCertificationHandler marks a certification verified or rejected.
+func ReviewCertificationHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	certID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid certification ID"})
+		return
+	}
+	var payload struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil || (payload.Status != certificationVerified && payload.Status != certificationRejected) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be \"verified\" or \"rejected\""})
+		return
+	}
+	var ownerID int
+	err = db.QueryRow(`SELECT user_id FROM user_certifications WHERE id = ?`, certID).Scan(&ownerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certification not found"})
+		return
+	}
+	if ownerID == myID || !canReviewCertification(myID, c.GetString("role"), ownerID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot review this certification"})
+		return
+	}
+	_, err = db.Exec(`
+		UPDATE user_certifications SET status = ?, reviewed_by = ?, reviewed_at = ?, review_note = ?
+		WHERE id = ?
+	`, payload.Status, myID, sqlTime(time.Now()), strings.TrimSpace(payload.Note), certID)
+	if err != nil {
+		log.Println("ReviewCertification error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Certification " + payload.Status})
+}
+
+// UpdateEventCertificationsHandler replaces the certifications an event requires.
+func UpdateEventCertificationsHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	eventID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+	var payload struct {
+		CertificationTypeIDs []int `json:"certificationTypeIds"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	allowed, err := canManageEvent(eventID, myID, c.GetString("role"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the event's organizer can change its requirements"})
+		return
+	}
+	raw := make([]string, len(payload.CertificationTypeIDs))
+	for i, id := range payload.CertificationTypeIDs {
+		raw[i] = strconv.Itoa(id)
+	}
+	typeIDs, err := parseCertificationTypeIDs(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("UpdateEventCertifications (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := setRequiredCertifications(tx, eventID, typeIDs); err != nil {
+		tx.Rollback()
+		log.Println("UpdateEventCertifications error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("UpdateEventCertifications (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Event requirements updated"})
+}
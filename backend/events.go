@@ -0,0 +1,293 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventVisibleClause hides members-only events from viewers outside the hosting
+// group. Organizers and registered volunteers keep seeing them. Callers pass the
+// viewer's id three times.
+func eventVisibleClause(alias string) string {
+	return `(` + alias + `.members_only = 0
+		OR ` + alias + `.created_by_user_id = ?
+		OR ` + alias + `.host_group_id IN ( SELECT group_id FROM group_members WHERE user_id = ? )
+		OR ` + alias + `.id IN ( SELECT event_id FROM registrations WHERE user_id = ? ))`
+}
+
+// canManageEvent reports whether the user may edit the event: its creator, an
+// admin of the hosting group, or a site Admin. sql.ErrNoRows means no such event.
+func canManageEvent(eventID, userID int, role string) (bool, error) {
+	var createdBy int
+	var hostGroupID sql.NullInt64
+	err := db.QueryRow(`SELECT created_by_user_id, host_group_id FROM events WHERE id = ?`, eventID).Scan(&createdBy, &hostGroupID)
+	if err != nil {
+		return false, err
+	}
+	if createdBy == userID || role == "Admin" {
+		return true, nil
+	}
+	if !hostGroupID.Valid {
+		return false, nil
+	}
+	groupRole, err := groupRole(int(hostGroupID.Int64), userID)
+	return groupRole == "admin", err
+}
+
+// attachHostGroups fills in the hosting group and members-only flag on each event.
+func attachHostGroups(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(events))
+	index := make(map[int][]int, len(events))
+	for i, e := range events {
+		args[i] = e.ID
+		index[e.ID] = append(index[e.ID], i)
+	}
+	rows, err := db.Query(`
+		SELECT e.id, g.id, g.name, e.members_only
+		FROM events e JOIN groups g ON g.id = e.host_group_id
+		WHERE e.id IN (?`+strings.Repeat(",?", len(args)-1)+`)
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventID, groupID int
+		var groupName string
+		var membersOnly bool
+		if err := rows.Scan(&eventID, &groupID, &groupName, &membersOnly); err != nil {
+			return err
+		}
+		for _, i := range index[eventID] {
+			events[i].HostGroupID = groupID
+			events[i].HostGroupName = groupName
+			events[i].MembersOnly = membersOnly
+		}
+	}
+	return rows.Err()
+}
+
+// notifyGroupOfEvent sends every member of the group bar the sender an event
+// invitation, which they can accept to register. It lapses once the event's day is over.
+func notifyGroupOfEvent(tx *sql.Tx, groupID, eventID, senderID int) error {
+	_, err := tx.Exec(`
+		INSERT INTO invitations (sender_id, receiver_id, invite_type, reference_id, status, expires_at)
+		SELECT ?, user_id, 'event', ?, 'pending', ( SELECT date || ' 23:59:59' FROM events WHERE id = ? ) FROM group_members
+		WHERE group_id = ? AND user_id != ?
+	`, senderID, eventID, eventID, groupID, senderID)
+	if err != nil {
+		return err
+	}
+	return notifyInvitations(tx, `i.invite_type = 'event' AND i.reference_id = ? AND i.sender_id = ?`, eventID, senderID)
+}
+
+// registrationBlocked checks whether the user may sign up for the event. It
+// returns 0 when they can, otherwise the status and body to respond with.
+func registrationBlocked(userID, eventID int) (int, gin.H) {
+	var eventDate string
+	var membersOnly bool
+	var hostGroupID sql.NullInt64
+	err := db.QueryRow(`SELECT date, members_only, host_group_id FROM events WHERE id = ?`, eventID).Scan(&eventDate, &membersOnly, &hostGroupID)
+	if err != nil {
+		return http.StatusNotFound, gin.H{"error": "Event not found"}
+	}
+	today := time.Now().Format("2006-01-02")
+	if eventDate < today {
+		return http.StatusBadRequest, gin.H{"error": "Cannot register for an event in the past."}
+	}
+	if membersOnly && hostGroupID.Valid {
+		role, err := groupRole(int(hostGroupID.Int64), userID)
+		if err != nil {
+			log.Println("registrationBlocked (membership) error:", err)
+			return http.StatusInternalServerError, gin.H{"error": "Database error"}
+		}
+		if role == "" {
+			return http.StatusForbidden, gin.H{"error": "This event is open to group members only"}
+		}
+	}
+	missing, err := missingCertifications(userID, eventID)
+	if err != nil {
+		log.Println("registrationBlocked (certifications) error:", err)
+		return http.StatusInternalServerError, gin.H{"error": "Database error"}
+	}
+	if len(missing) > 0 {
+		return http.StatusForbidden, gin.H{
+			"error":                 "This event requires a valid " + strings.Join(missing, ", ") + " certification",
+			"missingCertifications": missing,
+		}
+	}
+	return 0, nil
+}
+
+// eventChanges names the fields registered volunteers should hear about.
+func eventChanges(before, after Event) []string {
+	changes := []string{}
+	for _, f := range []struct {
+		name          string
+		before, after string
+	}{
+		{"name", before.Name, after.Name},
+		{"date", before.Date, after.Date},
+		{"startTime", before.StartTime, after.StartTime},
+		{"endTime", before.EndTime, after.EndTime},
+		{"locationAddress", before.LocationAddress, after.LocationAddress},
+		{"description", before.Description, after.Description},
+	} {
+		if f.before != f.after {
+			changes = append(changes, f.name)
+		}
+	}
+	return changes
+}
+
+// queryGroupEvents lists the group's upcoming events that the viewer may see.
+func queryGroupEvents(groupID, viewerID int) ([]Event, error) {
+	events, err := queryEvents(`
+		SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, e.start_time, e.end_time,
+		       e.created_by_user_id, u.email, u.name, u.profile_image_url
+		FROM events e
+		JOIN users u ON e.created_by_user_id = u.id
+		WHERE e.host_group_id = ? AND e.date >= ? AND `+eventVisibleClause("e")+`
+		ORDER BY e.date ASC
+	`, groupID, time.Now().Format("2006-01-02"), viewerID, viewerID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachHostGroups(events); err != nil {
+		return nil, err
+	}
+	return events, attachRequiredCertifications(events)
+}
+
+// UpdateEventHandler edits an event. It takes the same multipart fields as
+// CreateEventHandler; omitted fields are left unchanged.
+func UpdateEventHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	role := c.GetString("role")
+	eventID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+	allowed, err := canManageEvent(eventID, userID, role)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+	if err != nil {
+		log.Println("UpdateEvent (permission) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the event's organizer or its group's admins can edit it"})
+		return
+	}
+	var e Event
+	var hostGroupID sql.NullInt64
+	err = db.QueryRow(`
+		SELECT name, date, start_time, end_time, description, location_address, image_url, host_group_id, members_only
+		FROM events WHERE id = ?
+	`, eventID).Scan(&e.Name, &e.Date, &e.StartTime, &e.EndTime, &e.Description, &e.LocationAddress, &e.ImageURL, &hostGroupID, &e.MembersOnly)
+	if err != nil {
+		log.Println("UpdateEvent (load) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	before := e
+	for field, dst := range map[string]*string{"name": &e.Name, "description": &e.Description} {
+		if v, ok := c.GetPostForm(field); ok {
+			if strings.TrimSpace(v) == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Name and description cannot be empty"})
+				return
+			}
+			*dst = strings.TrimSpace(v)
+		}
+	}
+	if v, ok := c.GetPostForm("locationAddress"); ok {
+		e.LocationAddress = v
+	}
+	if v, ok := c.GetPostForm("date"); ok && v != e.Date {
+		if _, err := time.Parse(dateLayout, v); err != nil || v < time.Now().Format("2006-01-02") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Date must be YYYY-MM-DD and not in the past"})
+			return
+		}
+		e.Date = v
+	}
+	if v, ok := c.GetPostForm("startTime"); ok {
+		e.StartTime = v
+	}
+	if v, ok := c.GetPostForm("endTime"); ok {
+		e.EndTime = v
+	}
+	if err := validateTimeRange(e.StartTime, e.EndTime); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if v, ok := c.GetPostForm("membersOnly"); ok {
+		e.MembersOnly = v == "true"
+		if e.MembersOnly && !hostGroupID.Valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only group-hosted events can be members-only"})
+			return
+		}
+	}
+	oldImageURL := e.ImageURL
+	if file, err := c.FormFile("image"); err == nil {
+		extension := filepath.Ext(file.Filename)
+		filename := fmt.Sprintf("%d-%d%s", time.Now().UnixNano(), userID, extension)
+		savePath := filepath.Join("uploads", filename)
+		if err := c.SaveUploadedFile(file, savePath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+			return
+		}
+		e.ImageURL = "http://localhost:8080/uploads/" + filename
+	}
+	_, err = db.Exec(`
+		UPDATE events SET name = ?, date = ?, start_time = ?, end_time = ?, description = ?, location_address = ?, image_url = ?, members_only = ?
+		WHERE id = ?
+	`, e.Name, e.Date, e.StartTime, e.EndTime, e.Description, e.LocationAddress, e.ImageURL, e.MembersOnly, eventID)
+	if err != nil {
+		log.Println("UpdateEvent error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if e.ImageURL != oldImageURL {
+		removeUploadedImage(oldImageURL)
+	}
+	if changes := eventChanges(before, e); len(changes) > 0 {
+		err := notifyAll(db, `SELECT user_id FROM registrations WHERE event_id = ?`, []interface{}{eventID},
+			notifEventUpdated, userID, gin.H{"eventId": eventID, "changes": changes})
+		if err != nil {
+			log.Println("UpdateEvent (notify) error:", err)
+		}
+	}
+	updated, err := queryEvents(`
+		SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, e.start_time, e.end_time,
+		       e.created_by_user_id, u.email, u.name, u.profile_image_url
+		FROM events e JOIN users u ON e.created_by_user_id = u.id
+		WHERE e.id = ?
+	`, eventID)
+	if err == nil {
+		err = attachHostGroups(updated)
+	}
+	if err == nil {
+		err = attachRequiredCertifications(updated)
+	}
+	if err != nil || len(updated) == 0 {
+		log.Println("UpdateEvent (reload) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, updated[0])
+}
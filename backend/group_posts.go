@@ -0,0 +1,499 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Group post kinds. Announcements come from admins and notify every member;
+// discussions can be started by anyone in the group.
+const (
+	postAnnouncement = "announcement"
+	postDiscussion   = "discussion"
+)
+
+// postReactions are the reactions members can leave on a post.
+var postReactions = []string{"like", "love", "celebrate", "thanks"}
+
+type GroupPostComment struct {
+	ID        int       `json:"id"`
+	Author    User      `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type GroupPost struct {
+	ID           int                `json:"id"`
+	GroupID      int                `json:"groupId"`
+	Author       User               `json:"author"`
+	Kind         string             `json:"kind"`
+	Title        string             `json:"title,omitempty"`
+	Body         string             `json:"body"`
+	Pinned       bool               `json:"pinned"`
+	Locked       bool               `json:"locked"`
+	CommentCount int                `json:"commentCount"`
+	Reactions    map[string]int     `json:"reactions"`
+	MyReactions  []string           `json:"myReactions"`
+	Comments     []GroupPostComment `json:"comments,omitempty"`
+	CreatedAt    time.Time          `json:"createdAt"`
+}
+
+// queryGroupPosts loads up to limit posts matching where (-1 for all), leaving
+// out authors the viewer has blocked or been blocked by. args must fill where's
+// placeholders.
+func queryGroupPosts(viewerID, limit int, where string, args ...interface{}) ([]GroupPost, error) {
+	rows, err := db.Query(`
+		SELECT p.id, p.group_id, p.kind, p.title, p.body, p.pinned, p.locked, p.created_at,
+		       u.id, u.name, u.profile_image_url,
+		       (SELECT COUNT(*) FROM group_post_comments c WHERE c.post_id = p.id)
+		FROM group_posts p JOIN users u ON u.id = p.author_id
+		WHERE `+where+` AND `+notBlockedClause("p.author_id")+`
+		ORDER BY p.pinned DESC, p.id DESC
+		LIMIT ?
+	`, append(args, viewerID, viewerID, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	posts := []GroupPost{}
+	index := make(map[int]int)
+	for rows.Next() {
+		var p GroupPost
+		if err := rows.Scan(&p.ID, &p.GroupID, &p.Kind, &p.Title, &p.Body, &p.Pinned, &p.Locked, &p.CreatedAt,
+			&p.Author.ID, &p.Author.Name, &p.Author.ProfileImageURL, &p.CommentCount); err != nil {
+			return nil, err
+		}
+		p.Reactions = map[string]int{}
+		p.MyReactions = []string{}
+		index[p.ID] = len(posts)
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil || len(posts) == 0 {
+		return posts, err
+	}
+	ids := make([]interface{}, 0, len(posts))
+	for _, p := range posts {
+		ids = append(ids, p.ID)
+	}
+	reactionRows, err := db.Query(`
+		SELECT post_id, reaction, COUNT(*), SUM(user_id = ?)
+		FROM group_post_reactions
+		WHERE post_id IN (?`+strings.Repeat(",?", len(ids)-1)+`)
+		GROUP BY post_id, reaction
+	`, append([]interface{}{viewerID}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer reactionRows.Close()
+	for reactionRows.Next() {
+		var postID, count, mine int
+		var reaction string
+		if err := reactionRows.Scan(&postID, &reaction, &count, &mine); err != nil {
+			return nil, err
+		}
+		p := &posts[index[postID]]
+		p.Reactions[reaction] = count
+		if mine > 0 {
+			p.MyReactions = append(p.MyReactions, reaction)
+		}
+	}
+	return posts, reactionRows.Err()
+}
+
+// loadGroupPost parses :postId and fetches that post's author and lock state,
+// writing the error response itself when it isn't in the group.
+func loadGroupPost(c *gin.Context, groupID int) (postID, authorID int, locked, ok bool) {
+	postID, err := strconv.Atoi(c.Param("postId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+		return 0, 0, false, false
+	}
+	err = db.QueryRow(`SELECT author_id, locked FROM group_posts WHERE id = ? AND group_id = ?`, postID, groupID).Scan(&authorID, &locked)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return 0, 0, false, false
+	}
+	return postID, authorID, locked, true
+}
+
+// notifyAnnouncement tells every member but the author about a new announcement.
+// When the author asks for email it goes out to members who haven't picked a
+// channel for announcements themselves.
+func notifyAnnouncement(tx *sql.Tx, groupID, postID, authorID int, email bool) error {
+	fallback := channelInApp
+	if email {
+		fallback = channelEmail
+	}
+	return insertNotifications(tx, `SELECT user_id FROM group_members WHERE group_id = ?`, []interface{}{groupID},
+		notifAnnouncement, authorID, gin.H{"groupId": groupID, "postId": postID}, fallback)
+}
+
+// --- Group Post Handlers ---
+
+// GetGroupPostsHandler lists the group's feed for members: pinned posts first,
+// then newest. Page back with ?before=<id>; ?kind= filters to one kind.
+func GetGroupPostsHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, _, ok := requireGroupRole(c, "member")
+	if !ok {
+		return
+	}
+	before, err := strconv.Atoi(c.Query("before"))
+	if err != nil || before <= 0 {
+		before = math.MaxInt32
+	}
+	where := `p.group_id = ? AND p.id < ?`
+	args := []interface{}{groupID, before}
+	if kind := c.Query("kind"); kind != "" {
+		where += ` AND p.kind = ?`
+		args = append(args, kind)
+	}
+	posts, err := queryGroupPosts(myID, 50, where, args...)
+	if err != nil {
+		log.Println("GetGroupPosts error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"posts": posts})
+}
+
+// CreateGroupPostHandler posts to the group feed. Only admins can post
+// announcements; "email": true also sends an announcement by email.
+func CreateGroupPostHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, myRole, ok := requireGroupRole(c, "member")
+	if !ok {
+		return
+	}
+	var payload struct {
+		Kind   string `json:"kind"`
+		Title  string `json:"title"`
+		Body   string `json:"body"`
+		Pinned bool   `json:"pinned"`
+		Email  bool   `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil || strings.TrimSpace(payload.Body) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A post needs a body"})
+		return
+	}
+	if payload.Kind == "" {
+		payload.Kind = postDiscussion
+	}
+	if payload.Kind != postAnnouncement && payload.Kind != postDiscussion {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Kind must be announcement or discussion"})
+		return
+	}
+	if payload.Kind == postAnnouncement && myRole != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only group admins can post announcements"})
+		return
+	}
+	if payload.Pinned && !canModerateGroup(myRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only group admins and moderators can pin posts"})
+		return
+	}
+	title := strings.TrimSpace(payload.Title)
+	body := strings.TrimSpace(payload.Body)
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("CreateGroupPost (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	res, err := tx.Exec(`
+		INSERT INTO group_posts (group_id, author_id, kind, title, body, pinned, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, groupID, myID, payload.Kind, title, body, payload.Pinned, sqlTime(time.Now()))
+	if err != nil {
+		tx.Rollback()
+		log.Println("CreateGroupPost error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	postID, _ := res.LastInsertId()
+	if payload.Kind == postAnnouncement {
+		if err := notifyAnnouncement(tx, groupID, int(postID), myID, payload.Email); err != nil {
+			tx.Rollback()
+			log.Println("CreateGroupPost (notify) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("CreateGroupPost (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	posts, err := queryGroupPosts(myID, 1, `p.id = ?`, postID)
+	if err != nil || len(posts) == 0 {
+		log.Println("CreateGroupPost (reload) error:", err)
+		c.JSON(http.StatusCreated, gin.H{"id": postID})
+		return
+	}
+	c.JSON(http.StatusCreated, posts[0])
+}
+
+// GetGroupPostHandler returns one post with its comments, oldest first.
+func GetGroupPostHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, _, ok := requireGroupRole(c, "member")
+	if !ok {
+		return
+	}
+	postID, _, _, ok := loadGroupPost(c, groupID)
+	if !ok {
+		return
+	}
+	posts, err := queryGroupPosts(myID, 1, `p.id = ?`, postID)
+	if err != nil {
+		log.Println("GetGroupPost error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(posts) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return
+	}
+	post := posts[0]
+	rows, err := db.Query(`
+		SELECT c.id, c.body, c.created_at, u.id, u.name, u.profile_image_url
+		FROM group_post_comments c JOIN users u ON u.id = c.author_id
+		WHERE c.post_id = ? AND `+notBlockedClause("c.author_id")+`
+		ORDER BY c.id
+	`, postID, myID, myID)
+	if err != nil {
+		log.Println("GetGroupPost (comments) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	post.Comments = []GroupPostComment{}
+	for rows.Next() {
+		var cm GroupPostComment
+		if err := rows.Scan(&cm.ID, &cm.Body, &cm.CreatedAt, &cm.Author.ID, &cm.Author.Name, &cm.Author.ProfileImageURL); err != nil {
+			log.Println("GetGroupPost (comment scan) error:", err)
+			continue
+		}
+		post.Comments = append(post.Comments, cm)
+	}
+	c.JSON(http.StatusOK, post)
+}
+
+// DeleteGroupPostHandler removes a post with its comments and reactions. Authors
+// can delete their own; moderators and admins can delete anyone's.
+func DeleteGroupPostHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, myRole, ok := requireGroupRole(c, "member")
+	if !ok {
+		return
+	}
+	postID, authorID, _, ok := loadGroupPost(c, groupID)
+	if !ok {
+		return
+	}
+	if authorID != myID && !canModerateGroup(myRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own posts"})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("DeleteGroupPost (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for _, stmt := range []string{
+		`DELETE FROM group_post_comments WHERE post_id = ?`,
+		`DELETE FROM group_post_reactions WHERE post_id = ?`,
+		`DELETE FROM notifications WHERE type = 'announcement' AND json_extract(payload, '$.postId') = ?`,
+		`DELETE FROM group_posts WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(stmt, postID); err != nil {
+			tx.Rollback()
+			log.Println("DeleteGroupPost error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if authorID != myID {
+		if err := logGroupAction(tx, groupID, myID, auditPostDeleted, authorID, fmt.Sprintf("post %d", postID)); err != nil {
+			tx.Rollback()
+			log.Println("DeleteGroupPost (audit) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("DeleteGroupPost (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Post deleted"})
+}
+
+// updateGroupPostFlag sets pinned or locked on a post. Moderators and admins only.
+func updateGroupPostFlag(c *gin.Context, column, action string) {
+	myID := c.GetInt("userID")
+	groupID, _, ok := requireGroupRole(c, "moderator")
+	if !ok {
+		return
+	}
+	postID, authorID, _, ok := loadGroupPost(c, groupID)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Value *bool `json:"value"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Value == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Send {\"value\": true} or {\"value\": false}"})
+		return
+	}
+	// column is one of our own constants, never user input.
+	if _, err := db.Exec(`UPDATE group_posts SET `+column+` = ? WHERE id = ?`, *payload.Value, postID); err != nil {
+		log.Println("UpdateGroupPost ("+column+") error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := logGroupAction(db, groupID, myID, action, authorID, fmt.Sprintf("post %d: %t", postID, *payload.Value)); err != nil {
+		log.Println("UpdateGroupPost (audit) error:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Post updated", column: *payload.Value})
+}
+
+func PinGroupPostHandler(c *gin.Context) {
+	updateGroupPostFlag(c, "pinned", auditPostPinned)
+}
+
+// LockGroupPostHandler stops further comments on a thread.
+func LockGroupPostHandler(c *gin.Context) {
+	updateGroupPostFlag(c, "locked", auditPostLocked)
+}
+
+// CreateGroupPostCommentHandler adds a comment. Locked threads only take
+// comments from moderators and admins.
+func CreateGroupPostCommentHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, myRole, ok := requireGroupRole(c, "member")
+	if !ok {
+		return
+	}
+	postID, authorID, locked, ok := loadGroupPost(c, groupID)
+	if !ok {
+		return
+	}
+	if locked && !canModerateGroup(myRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This thread is locked"})
+		return
+	}
+	if isBlockedEitherWay(myID, authorID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot comment on this post"})
+		return
+	}
+	var payload struct {
+		Body string `json:"body"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil || strings.TrimSpace(payload.Body) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A comment needs a body"})
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	res, err := db.Exec(`INSERT INTO group_post_comments (post_id, author_id, body, created_at) VALUES (?, ?, ?, ?)`, postID, myID, strings.TrimSpace(payload.Body), sqlTime(now))
+	if err != nil {
+		log.Println("CreateGroupPostComment error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	commentID, _ := res.LastInsertId()
+	comment := GroupPostComment{ID: int(commentID), Body: strings.TrimSpace(payload.Body), CreatedAt: now}
+	db.QueryRow(`SELECT id, name, profile_image_url FROM users WHERE id = ?`, myID).Scan(&comment.Author.ID, &comment.Author.Name, &comment.Author.ProfileImageURL)
+	c.JSON(http.StatusCreated, comment)
+}
+
+// DeleteGroupPostCommentHandler removes a comment. Authors can delete their own;
+// moderators and admins can delete anyone's.
+func DeleteGroupPostCommentHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, myRole, ok := requireGroupRole(c, "member")
+	if !ok {
+		return
+	}
+	postID, _, _, ok := loadGroupPost(c, groupID)
+	if !ok {
+		return
+	}
+	commentID, err := strconv.Atoi(c.Param("commentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+	var authorID int
+	if err := db.QueryRow(`SELECT author_id FROM group_post_comments WHERE id = ? AND post_id = ?`, commentID, postID).Scan(&authorID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return
+	}
+	if authorID != myID && !canModerateGroup(myRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own comments"})
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM group_post_comments WHERE id = ?`, commentID); err != nil {
+		log.Println("DeleteGroupPostComment error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if authorID != myID {
+		if err := logGroupAction(db, groupID, myID, auditCommentDeleted, authorID, fmt.Sprintf("comment %d on post %d", commentID, postID)); err != nil {
+			log.Println("DeleteGroupPostComment (audit) error:", err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Comment deleted"})
+}
+
+func AddGroupPostReactionHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, _, ok := requireGroupRole(c, "member")
+	if !ok {
+		return
+	}
+	postID, _, _, ok := loadGroupPost(c, groupID)
+	if !ok {
+		return
+	}
+	reaction := c.Param("reaction")
+	if !containsString(postReactions, reaction) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reaction must be one of " + strings.Join(postReactions, ", ")})
+		return
+	}
+	_, err := db.Exec(`INSERT OR IGNORE INTO group_post_reactions (post_id, user_id, reaction, created_at) VALUES (?, ?, ?, ?)`, postID, myID, reaction, sqlTime(time.Now()))
+	if err != nil {
+		log.Println("AddGroupPostReaction error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Reaction added"})
+}
+
+func RemoveGroupPostReactionHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, _, ok := requireGroupRole(c, "member")
+	if !ok {
+		return
+	}
+	postID, _, _, ok := loadGroupPost(c, groupID)
+	if !ok {
+		return
+	}
+	_, err := db.Exec(`DELETE FROM group_post_reactions WHERE post_id = ? AND user_id = ? AND reaction = ?`, postID, myID, c.Param("reaction"))
+	if err != nil {
+		log.Println("RemoveGroupPostReaction error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Reaction removed"})
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Screening question kinds. Skill questions aren't answered by the applicant;
+// they are checked against the applicant's profile.
+const (
+	questionText   = "text"
+	questionChoice = "choice"
+	questionSkill  = "skill"
+)
+
+const maxScreeningQuestions = 10
+
+// ScreeningQuestion is asked of everyone requesting to join a closed group.
+type ScreeningQuestion struct {
+	ID       int           `json:"id"`
+	Kind     string        `json:"kind"`
+	Prompt   string        `json:"prompt"`
+	Options  []string      `json:"options,omitempty"` // choice questions
+	SkillID  int           `json:"skillId,omitempty"` // skill questions
+	Skill    *CatalogSkill `json:"skill,omitempty"`
+	Required bool          `json:"required"`
+}
+
+// JoinAnswer is an applicant's answer as stored with their join request.
+type JoinAnswer struct {
+	QuestionID int    `json:"questionId"`
+	Prompt     string `json:"prompt,omitempty"`
+	Answer     string `json:"answer"`
+}
+
+// JoinRequest is a pending applicant together with their answers.
+type JoinRequest struct {
+	User
+	Answers []JoinAnswer `json:"answers"`
+}
+
+// clearJoinAnswersSQL drops an applicant's answers once their request is settled.
+const clearJoinAnswersSQL = `DELETE FROM group_join_answers WHERE group_id = ? AND user_id = ?`
+
+func loadScreeningQuestions(groupID int) ([]ScreeningQuestion, error) {
+	rows, err := db.Query(`
+		SELECT q.id, q.kind, q.prompt, q.options, q.required, s.id, s.name, s.category, s.requires_verification
+		FROM group_questions q LEFT JOIN skills s ON s.id = q.skill_id
+		WHERE q.group_id = ?
+		ORDER BY q.position
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	questions := []ScreeningQuestion{}
+	for rows.Next() {
+		var q ScreeningQuestion
+		var options string
+		var skillID sql.NullInt64
+		var skillName, skillCategory sql.NullString
+		var requiresVerification sql.NullBool
+		if err := rows.Scan(&q.ID, &q.Kind, &q.Prompt, &options, &q.Required, &skillID, &skillName, &skillCategory, &requiresVerification); err != nil {
+			return nil, err
+		}
+		if q.Kind == questionChoice {
+			json.Unmarshal([]byte(options), &q.Options)
+		}
+		if skillID.Valid {
+			q.SkillID = int(skillID.Int64)
+			q.Skill = &CatalogSkill{ID: q.SkillID, Name: skillName.String, Category: skillCategory.String, RequiresVerification: requiresVerification.Bool}
+		}
+		questions = append(questions, q)
+	}
+	return questions, rows.Err()
+}
+
+// screenApplicant checks the answers against the group's questions and fills in
+// skill questions from the applicant's profile. The error, if any, is meant for
+// the applicant.
+func screenApplicant(groupID, userID int, given []JoinAnswer) ([]JoinAnswer, error) {
+	questions, err := loadScreeningQuestions(groupID)
+	if err != nil {
+		return nil, err
+	}
+	byQuestion := make(map[int]string, len(given))
+	for _, a := range given {
+		byQuestion[a.QuestionID] = strings.TrimSpace(a.Answer)
+	}
+	answers := []JoinAnswer{}
+	for _, q := range questions {
+		answer := byQuestion[q.ID]
+		switch q.Kind {
+		case questionSkill:
+			var has int
+			db.QueryRow(`SELECT COUNT(*) FROM user_skills WHERE user_id = ? AND skill_id = ?`, userID, q.SkillID).Scan(&has)
+			answer = "no"
+			if has > 0 {
+				answer = "yes"
+			} else if q.Required {
+				return nil, fmt.Errorf("This group requires the %s skill. Add it to your profile first.", q.Skill.Name)
+			}
+		case questionChoice:
+			if answer != "" && !containsString(q.Options, answer) {
+				return nil, fmt.Errorf("%q is not one of the options for %q", answer, q.Prompt)
+			}
+		}
+		if answer == "" && q.Required {
+			return nil, fmt.Errorf("Please answer %q", q.Prompt)
+		}
+		if answer != "" {
+			answers = append(answers, JoinAnswer{QuestionID: q.ID, Prompt: q.Prompt, Answer: answer})
+		}
+	}
+	return answers, nil
+}
+
+// loadJoinAnswers returns each applicant's answers to the group's questions.
+func loadJoinAnswers(groupID int) (map[int][]JoinAnswer, error) {
+	rows, err := db.Query(`
+		SELECT a.user_id, a.question_id, q.prompt, a.answer
+		FROM group_join_answers a JOIN group_questions q ON q.id = a.question_id
+		WHERE a.group_id = ?
+		ORDER BY q.position
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	answers := make(map[int][]JoinAnswer)
+	for rows.Next() {
+		var userID int
+		var a JoinAnswer
+		if err := rows.Scan(&userID, &a.QuestionID, &a.Prompt, &a.Answer); err != nil {
+			return nil, err
+		}
+		answers[userID] = append(answers[userID], a)
+	}
+	return answers, rows.Err()
+}
+
+// validateScreeningQuestions tidies an admin's question list in place.
+func validateScreeningQuestions(questions []ScreeningQuestion) error {
+	if len(questions) > maxScreeningQuestions {
+		return fmt.Errorf("A group can ask at most %d questions", maxScreeningQuestions)
+	}
+	for i := range questions {
+		q := &questions[i]
+		q.Prompt = strings.TrimSpace(q.Prompt)
+		switch q.Kind {
+		case questionText:
+		case questionChoice:
+			options := []string{}
+			for _, o := range q.Options {
+				if o = strings.TrimSpace(o); o != "" && !containsString(options, o) {
+					options = append(options, o)
+				}
+			}
+			if len(options) < 2 {
+				return errors.New("Multiple choice questions need at least two options")
+			}
+			q.Options = options
+		case questionSkill:
+			skill, err := resolveSkill("", q.SkillID)
+			if err != nil {
+				return errors.New("Unknown skill")
+			}
+			if q.Prompt == "" {
+				q.Prompt = "Do you have the " + skill.Name + " skill?"
+			}
+		default:
+			return errors.New("Question kind must be text, choice or skill")
+		}
+		if q.Prompt == "" {
+			return errors.New("Every question needs a prompt")
+		}
+	}
+	return nil
+}
+
+// --- Screening Handlers ---
+
+// GetScreeningQuestionsHandler lists the questions an applicant will be asked.
+func GetScreeningQuestionsHandler(c *gin.Context) {
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	if isSecretGroup(groupID) {
+		if role, _ := groupRole(groupID, c.GetInt("userID")); role == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+			return
+		}
+	}
+	questions, err := loadScreeningQuestions(groupID)
+	if err != nil {
+		log.Println("GetScreeningQuestions error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"questions": questions})
+}
+
+// UpdateScreeningQuestionsHandler replaces the group's questions. Answers that
+// pending applicants gave to removed questions are dropped.
+func UpdateScreeningQuestionsHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, ok := requireGroupAdmin(c)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Questions []ScreeningQuestion `json:"questions"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	if err := validateScreeningQuestions(payload.Questions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("UpdateScreeningQuestions (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Questions sent back with their id keep it, so existing answers still match.
+	kept := []interface{}{groupID}
+	for _, q := range payload.Questions {
+		if q.ID > 0 {
+			kept = append(kept, q.ID)
+		}
+	}
+	notKept := ""
+	if len(kept) > 1 {
+		notKept = ` AND id NOT IN (?` + strings.Repeat(",?", len(kept)-2) + `)`
+	}
+	stmts := []string{
+		`DELETE FROM group_join_answers WHERE question_id IN ( SELECT id FROM group_questions WHERE group_id = ?` + notKept + ` )`,
+		`DELETE FROM group_questions WHERE group_id = ?` + notKept,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, kept...); err != nil {
+			tx.Rollback()
+			log.Println("UpdateScreeningQuestions (delete) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	for i, q := range payload.Questions {
+		options, _ := json.Marshal(q.Options)
+		var skillID interface{}
+		if q.Kind == questionSkill {
+			skillID = q.SkillID
+		}
+		var res sql.Result
+		if q.ID > 0 {
+			res, err = tx.Exec(`
+				UPDATE group_questions SET position = ?, kind = ?, prompt = ?, options = ?, skill_id = ?, required = ?
+				WHERE id = ? AND group_id = ?
+			`, i, q.Kind, q.Prompt, string(options), skillID, q.Required, q.ID, groupID)
+			if err == nil {
+				if n, _ := res.RowsAffected(); n == 0 {
+					err = fmt.Errorf("question %d is not in this group", q.ID)
+				}
+			}
+		} else {
+			_, err = tx.Exec(`
+				INSERT INTO group_questions (group_id, position, kind, prompt, options, skill_id, required)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, groupID, i, q.Kind, q.Prompt, string(options), skillID, q.Required)
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Println("UpdateScreeningQuestions error:", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not save question " + strconv.Itoa(i+1)})
+			return
+		}
+	}
+	if err := logGroupAction(tx, groupID, myID, auditQuestionsUpdated, 0, fmt.Sprintf("%d questions", len(payload.Questions))); err != nil {
+		tx.Rollback()
+		log.Println("UpdateScreeningQuestions (audit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("UpdateScreeningQuestions (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	questions, err := loadScreeningQuestions(groupID)
+	if err != nil {
+		log.Println("UpdateScreeningQuestions (reload) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"questions": questions})
+}
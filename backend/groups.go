@@ -0,0 +1,730 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// groupRole returns the user's role in the group, or "" if they are not a member.
+func groupRole(groupID, userID int) (string, error) {
+	var role string
+	err := db.QueryRow(`SELECT role FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// Group roles, lowest first. Moderators handle join requests and can remove or
+// ban ordinary members; admins manage everything else.
+var groupRoleRank = map[string]int{"member": 1, "moderator": 2, "admin": 3}
+
+// canModerateGroup reports whether a group role may handle members.
+func canModerateGroup(role string) bool {
+	return groupRoleRank[role] >= groupRoleRank["moderator"]
+}
+
+// requireGroupRole parses :id and checks the caller holds at least minRole in
+// that group, writing the error response itself when not.
+func requireGroupRole(c *gin.Context, minRole string) (int, string, bool) {
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return 0, "", false
+	}
+	var exists int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM groups WHERE id = ?`, groupID).Scan(&exists); err != nil || exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return 0, "", false
+	}
+	role, err := groupRole(groupID, c.GetInt("userID"))
+	if err != nil {
+		log.Println("requireGroupRole error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return 0, "", false
+	}
+	if groupRoleRank[role] < groupRoleRank[minRole] {
+		switch {
+		case role == "" && isSecretGroup(groupID):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		case minRole == "member":
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this group"})
+		case minRole == "admin":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only group admins can do this"})
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only group admins and moderators can do this"})
+		}
+		return 0, "", false
+	}
+	return groupID, role, true
+}
+
+func requireGroupAdmin(c *gin.Context) (int, bool) {
+	groupID, _, ok := requireGroupRole(c, "admin")
+	return groupID, ok
+}
+
+// Group privacy modes.
+const (
+	groupOpen   = "open"   // anyone can join straight away
+	groupClosed = "closed" // joining needs a moderator's approval
+	groupSecret = "secret" // invite-only and hidden from non-members
+)
+
+func validGroupPrivacy(p string) bool {
+	return p == groupOpen || p == groupClosed || p == groupSecret
+}
+
+func isSecretGroup(groupID int) bool {
+	var privacy string
+	db.QueryRow(`SELECT privacy FROM groups WHERE id = ?`, groupID).Scan(&privacy)
+	return privacy == groupSecret
+}
+
+// hasPendingGroupInvitation reports whether the user has been invited to the
+// group and hasn't answered yet.
+func hasPendingGroupInvitation(groupID, userID int) bool {
+	var n int
+	db.QueryRow(`
+		SELECT COUNT(*) FROM invitations
+		WHERE invite_type = 'group' AND reference_id = ? AND receiver_id = ? AND status = 'pending' AND `+invitationLiveClause("invitations")+`
+	`, groupID, userID).Scan(&n)
+	return n > 0
+}
+
+// isBannedFromGroup reports whether the user has been banned from the group.
+func isBannedFromGroup(groupID, userID int) bool {
+	var banned int
+	db.QueryRow(`SELECT COUNT(*) FROM group_bans WHERE group_id = ? AND user_id = ?`, groupID, userID).Scan(&banned)
+	return banned > 0
+}
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Group audit log actions.
+const (
+	auditGroupUpdated      = "group_updated"
+	auditOwnershipTransfer = "ownership_transferred"
+	auditRoleChanged       = "role_changed"
+	auditMemberRemoved     = "member_removed"
+	auditMemberBanned      = "member_banned"
+	auditMemberUnbanned    = "member_unbanned"
+	auditJoinApproved      = "join_request_approved"
+	auditJoinDenied        = "join_request_denied"
+	auditAdminHandover     = "admin_handover"
+	auditPostDeleted       = "post_deleted"
+	auditCommentDeleted    = "comment_deleted"
+	auditPostPinned        = "post_pinned"
+	auditPostLocked        = "post_locked"
+	auditQuestionsUpdated  = "questions_updated"
+	auditWebhookCreated    = "webhook_created"
+	auditWebhookUpdated    = "webhook_updated"
+	auditWebhookDeleted    = "webhook_deleted"
+)
+
+// logGroupAction records who did what in a group. targetID is 0 when the action
+// isn't aimed at a member.
+func logGroupAction(ex sqlExecer, groupID, actorID int, action string, targetID int, details string) error {
+	var target interface{}
+	if targetID != 0 {
+		target = targetID
+	}
+	_, err := ex.Exec(`
+		INSERT INTO group_audit_log (group_id, actor_id, action, target_user_id, details, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, groupID, actorID, action, target, details, sqlTime(time.Now()))
+	return err
+}
+
+// memberTarget loads the :userId member for a moderation action. Admins can act
+// on anyone except the owner; moderators only on ordinary members.
+func memberTarget(c *gin.Context, groupID int, myRole string) (int, string, bool) {
+	targetID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, "", false
+	}
+	if targetID == c.GetInt("userID") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot do this to yourself"})
+		return 0, "", false
+	}
+	targetRole, err := groupRole(groupID, targetID)
+	if err != nil {
+		log.Println("memberTarget error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return 0, "", false
+	}
+	if targetRole == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of this group"})
+		return 0, "", false
+	}
+	var ownerID int
+	db.QueryRow(`SELECT created_by_user_id FROM groups WHERE id = ?`, groupID).Scan(&ownerID)
+	if targetID == ownerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "The group owner cannot be changed or removed"})
+		return 0, "", false
+	}
+	if myRole != "admin" && groupRoleRank[targetRole] >= groupRoleRank[myRole] {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Moderators can only act on ordinary members"})
+		return 0, "", false
+	}
+	return targetID, targetRole, true
+}
+
+// removeUploadedImage deletes a picture we stored in uploads/. Placeholder URLs
+// and images hosted elsewhere are left alone.
+func removeUploadedImage(imageURL string) {
+	const prefix = "/uploads/"
+	i := strings.Index(imageURL, prefix)
+	if i < 0 {
+		return
+	}
+	path := filepath.Join("uploads", filepath.Base(imageURL[i+len(prefix):]))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove %s: %v", path, err)
+	}
+}
+
+// handOverGroup makes successorID an admin, and the owner too when the leaving
+// user owned the group.
+func handOverGroup(tx *sql.Tx, groupID, leavingID, successorID int) error {
+	res, err := tx.Exec(`UPDATE group_members SET role = 'admin' WHERE group_id = ? AND user_id = ?`, groupID, successorID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	_, err = tx.Exec(`UPDATE groups SET created_by_user_id = ? WHERE id = ? AND created_by_user_id = ?`, successorID, groupID, leavingID)
+	return err
+}
+
+// admitJoinRequests turns every pending join request, bar those from banned
+// users, into a membership.
+func admitJoinRequests(tx *sql.Tx, groupID int) error {
+	_, err := tx.Exec(`
+		INSERT OR IGNORE INTO group_members (group_id, user_id, role)
+		SELECT group_id, user_id, 'member' FROM group_join_requests
+		WHERE group_id = ? AND user_id NOT IN ( SELECT user_id FROM group_bans WHERE group_id = ? )
+	`, groupID, groupID)
+	if err != nil {
+		return err
+	}
+	err = notifyAll(tx, `SELECT user_id FROM group_join_requests WHERE group_id = ?`, []interface{}{groupID}, notifJoinApproved, 0, gin.H{"groupId": groupID})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM group_join_requests WHERE group_id = ?`, groupID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM group_join_answers WHERE group_id = ?`, groupID)
+	return err
+}
+
+// --- Group Management Handlers ---
+
+// UpdateGroupHandler edits name, description, privacy and picture. It takes the
+// same multipart fields as CreateGroupHandler; omitted fields are left unchanged.
+func UpdateGroupHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	groupID, ok := requireGroupAdmin(c)
+	if !ok {
+		return
+	}
+	var name, description, imageURL, privacy string
+	err := db.QueryRow(`SELECT name, description, profile_image_url, privacy FROM groups WHERE id = ?`, groupID).Scan(&name, &description, &imageURL, &privacy)
+	if err != nil {
+		log.Println("UpdateGroup (load) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if v, ok := c.GetPostForm("name"); ok {
+		if strings.TrimSpace(v) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name cannot be empty"})
+			return
+		}
+		name = strings.TrimSpace(v)
+	}
+	if v, ok := c.GetPostForm("description"); ok {
+		if strings.TrimSpace(v) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Description cannot be empty"})
+			return
+		}
+		description = strings.TrimSpace(v)
+	}
+	oldPrivacy := privacy
+	if v, ok := c.GetPostForm("privacy"); ok {
+		if !validGroupPrivacy(v) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Privacy must be open, closed or secret"})
+			return
+		}
+		privacy = v
+	}
+	oldImageURL := imageURL
+	if file, err := c.FormFile("image"); err == nil {
+		extension := filepath.Ext(file.Filename)
+		filename := fmt.Sprintf("group-%d-%d%s", time.Now().UnixNano(), userID, extension)
+		savePath := filepath.Join("uploads", filename)
+		if err := c.SaveUploadedFile(file, savePath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+			return
+		}
+		imageURL = "http://localhost:8080/uploads/" + filename
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("UpdateGroup (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	_, err = tx.Exec(`UPDATE groups SET name = ?, description = ?, profile_image_url = ?, privacy = ? WHERE id = ?`, name, description, imageURL, privacy, groupID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("UpdateGroup error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Opening the group lets everyone who was waiting straight in.
+	if privacy == groupOpen && oldPrivacy != groupOpen {
+		if err := admitJoinRequests(tx, groupID); err != nil {
+			tx.Rollback()
+			log.Println("UpdateGroup (admit requests) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	details := ""
+	if privacy != oldPrivacy {
+		details = "privacy: " + oldPrivacy + " -> " + privacy
+	}
+	if err := logGroupAction(tx, groupID, userID, auditGroupUpdated, 0, details); err != nil {
+		tx.Rollback()
+		log.Println("UpdateGroup (audit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("UpdateGroup (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if imageURL != oldImageURL {
+		removeUploadedImage(oldImageURL)
+	}
+	c.JSON(http.StatusOK, gin.H{"id": groupID, "name": name, "description": description, "profileImageUrl": imageURL, "privacy": privacy})
+}
+
+// DeleteGroupHandler removes the group with its memberships, join requests,
+// pending invitations, bans, audit log, feed and webhooks. Its events stay with
+// their creators.
+func DeleteGroupHandler(c *gin.Context) {
+	groupID, ok := requireGroupAdmin(c)
+	if !ok {
+		return
+	}
+	var imageURL string
+	db.QueryRow(`SELECT profile_image_url FROM groups WHERE id = ?`, groupID).Scan(&imageURL)
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("DeleteGroup (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for _, stmt := range []string{
+		`DELETE FROM group_members WHERE group_id = ?`,
+		`DELETE FROM group_join_requests WHERE group_id = ?`,
+		`DELETE FROM group_join_answers WHERE group_id = ?`,
+		`DELETE FROM group_questions WHERE group_id = ?`,
+		`DELETE FROM invite_link_uses WHERE link_id IN ( SELECT id FROM invite_links WHERE kind = 'group' AND target_id = ? )`,
+		`DELETE FROM invite_links WHERE kind = 'group' AND target_id = ?`,
+		`DELETE FROM invitations WHERE invite_type = 'group' AND reference_id = ?`,
+		`DELETE FROM notifications WHERE json_extract(payload, '$.groupId') = ?`,
+		`DELETE FROM group_bans WHERE group_id = ?`,
+		`DELETE FROM group_audit_log WHERE group_id = ?`,
+		`DELETE FROM group_post_comments WHERE post_id IN ( SELECT id FROM group_posts WHERE group_id = ? )`,
+		`DELETE FROM group_post_reactions WHERE post_id IN ( SELECT id FROM group_posts WHERE group_id = ? )`,
+		`DELETE FROM group_posts WHERE group_id = ?`,
+		`DELETE FROM webhook_deliveries WHERE webhook_id IN ( SELECT id FROM webhooks WHERE group_id = ? )`,
+		`DELETE FROM webhook_events WHERE group_id = ?`,
+		`DELETE FROM webhooks WHERE group_id = ?`,
+		`UPDATE events SET host_group_id = NULL, members_only = 0 WHERE host_group_id = ?`,
+		`DELETE FROM groups WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(stmt, groupID); err != nil {
+			tx.Rollback()
+			log.Println("DeleteGroup error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("DeleteGroup (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	removeUploadedImage(imageURL)
+	c.JSON(http.StatusOK, gin.H{"message": "Group deleted"})
+}
+
+// TransferGroupOwnershipHandler hands the group to another member, who becomes
+// an admin. Only the current owner can do this; they stay on as an admin.
+func TransferGroupOwnershipHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	groupID, ok := requireGroupAdmin(c)
+	if !ok {
+		return
+	}
+	var payload struct {
+		UserID int `json:"userId"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.UserID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var ownerID int
+	db.QueryRow(`SELECT created_by_user_id FROM groups WHERE id = ?`, groupID).Scan(&ownerID)
+	if ownerID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the group owner can transfer ownership"})
+		return
+	}
+	if payload.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You already own this group"})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("TransferGroupOwnership (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := handOverGroup(tx, groupID, userID, payload.UserID); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The new owner must be a member of the group"})
+			return
+		}
+		log.Println("TransferGroupOwnership error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := logGroupAction(tx, groupID, userID, auditOwnershipTransfer, payload.UserID, ""); err != nil {
+		tx.Rollback()
+		log.Println("TransferGroupOwnership (audit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("TransferGroupOwnership (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Ownership transferred"})
+}
+
+// --- Group Moderation Handlers ---
+
+type GroupBan struct {
+	User      User      `json:"user"`
+	BannedBy  int       `json:"bannedBy"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type GroupAuditEntry struct {
+	ID        int       `json:"id"`
+	Action    string    `json:"action"`
+	Actor     User      `json:"actor"`
+	Target    *User     `json:"target,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ChangeMemberRoleHandler promotes or demotes a member. Admins only.
+func ChangeMemberRoleHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, myRole, ok := requireGroupRole(c, "admin")
+	if !ok {
+		return
+	}
+	var payload struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil || groupRoleRank[payload.Role] == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be admin, moderator or member"})
+		return
+	}
+	targetID, targetRole, ok := memberTarget(c, groupID, myRole)
+	if !ok {
+		return
+	}
+	if targetRole == payload.Role {
+		c.JSON(http.StatusOK, gin.H{"message": "Role unchanged", "role": targetRole})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("ChangeMemberRole (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	_, err = tx.Exec(`UPDATE group_members SET role = ? WHERE group_id = ? AND user_id = ?`, payload.Role, groupID, targetID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("ChangeMemberRole error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := logGroupAction(tx, groupID, myID, auditRoleChanged, targetID, targetRole+" -> "+payload.Role); err != nil {
+		tx.Rollback()
+		log.Println("ChangeMemberRole (audit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("ChangeMemberRole (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated", "role": payload.Role})
+}
+
+// RemoveGroupMemberHandler takes a member out of the group. They can ask to
+// join again; use a ban to stop that.
+func RemoveGroupMemberHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, myRole, ok := requireGroupRole(c, "moderator")
+	if !ok {
+		return
+	}
+	targetID, _, ok := memberTarget(c, groupID, myRole)
+	if !ok {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("RemoveGroupMember (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, targetID); err != nil {
+		tx.Rollback()
+		log.Println("RemoveGroupMember error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := logGroupAction(tx, groupID, myID, auditMemberRemoved, targetID, ""); err != nil {
+		tx.Rollback()
+		log.Println("RemoveGroupMember (audit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("RemoveGroupMember (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// BanGroupMemberHandler removes the user if they are a member and stops them
+// requesting to join or being invited again. Non-members can be banned too.
+func BanGroupMemberHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, myRole, ok := requireGroupRole(c, "moderator")
+	if !ok {
+		return
+	}
+	var payload struct {
+		Reason string `json:"reason"`
+	}
+	c.ShouldBindJSON(&payload)
+	targetID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if role, _ := groupRole(groupID, targetID); role != "" {
+		if _, _, ok := memberTarget(c, groupID, myRole); !ok {
+			return
+		}
+	} else if targetID == myID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot do this to yourself"})
+		return
+	}
+	var exists int
+	if db.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ? AND deleted_at IS NULL`, targetID).Scan(&exists); exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	reason := strings.TrimSpace(payload.Reason)
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("BanGroupMember (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for _, stmt := range []string{
+		`DELETE FROM group_members WHERE group_id = ? AND user_id = ?`,
+		`DELETE FROM group_join_requests WHERE group_id = ? AND user_id = ?`,
+		clearJoinAnswersSQL,
+		`DELETE FROM invitations WHERE invite_type = 'group' AND reference_id = ? AND receiver_id = ? AND status = 'pending'`,
+	} {
+		if _, err := tx.Exec(stmt, groupID, targetID); err != nil {
+			tx.Rollback()
+			log.Println("BanGroupMember (cleanup) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO group_bans (group_id, user_id, banned_by, reason, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, groupID, targetID, myID, reason, sqlTime(time.Now()))
+	if err != nil {
+		tx.Rollback()
+		log.Println("BanGroupMember error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := logGroupAction(tx, groupID, myID, auditMemberBanned, targetID, reason); err != nil {
+		tx.Rollback()
+		log.Println("BanGroupMember (audit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("BanGroupMember (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User banned from group"})
+}
+
+func UnbanGroupMemberHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, _, ok := requireGroupRole(c, "moderator")
+	if !ok {
+		return
+	}
+	targetID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	res, err := db.Exec(`DELETE FROM group_bans WHERE group_id = ? AND user_id = ?`, groupID, targetID)
+	if err != nil {
+		log.Println("UnbanGroupMember error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not banned"})
+		return
+	}
+	if err := logGroupAction(db, groupID, myID, auditMemberUnbanned, targetID, ""); err != nil {
+		log.Println("UnbanGroupMember (audit) error:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User unbanned"})
+}
+
+func GetGroupBansHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	groupID, _, ok := requireGroupRole(c, "moderator")
+	if !ok {
+		return
+	}
+	rows, err := db.Query(`
+		SELECT u.id, u.name, u.email, u.role, u.profile_image_url, b.banned_by, b.reason, b.created_at
+		FROM group_bans b JOIN users u ON u.id = b.user_id
+		WHERE b.group_id = ?
+		ORDER BY b.created_at DESC
+	`, groupID)
+	if err != nil {
+		log.Println("GetGroupBans error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	bans := []GroupBan{}
+	users := []User{}
+	for rows.Next() {
+		var b GroupBan
+		if err := rows.Scan(&b.User.ID, &b.User.Name, &b.User.Email, &b.User.Role, &b.User.ProfileImageURL, &b.BannedBy, &b.Reason, &b.CreatedAt); err != nil {
+			log.Println("GetGroupBans scan error:", err)
+			continue
+		}
+		bans = append(bans, b)
+		users = append(users, b.User)
+	}
+	if err := redactUsers(myID, c.GetString("role"), users); err != nil {
+		log.Println("GetGroupBans (redact) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for i := range bans {
+		bans[i].User = users[i]
+	}
+	c.JSON(http.StatusOK, gin.H{"bans": bans})
+}
+
+// GetGroupAuditLogHandler lists the group's moderation history, newest first.
+// Page back with ?before=<id>; ?limit= caps the page (default 50, max 200).
+func GetGroupAuditLogHandler(c *gin.Context) {
+	groupID, _, ok := requireGroupRole(c, "admin")
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	before, err := strconv.Atoi(c.Query("before"))
+	if err != nil || before <= 0 {
+		before = math.MaxInt32
+	}
+	rows, err := db.Query(`
+		SELECT l.id, l.action, l.details, l.created_at, a.id, a.name, a.profile_image_url,
+		       t.id, t.name, t.profile_image_url
+		FROM group_audit_log l
+		JOIN users a ON a.id = l.actor_id
+		LEFT JOIN users t ON t.id = l.target_user_id
+		WHERE l.group_id = ? AND l.id < ?
+		ORDER BY l.id DESC
+		LIMIT ?
+	`, groupID, before, limit)
+	if err != nil {
+		log.Println("GetGroupAuditLog error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	entries := []GroupAuditEntry{}
+	for rows.Next() {
+		var e GroupAuditEntry
+		var targetID sql.NullInt64
+		var targetName, targetImage sql.NullString
+		if err := rows.Scan(&e.ID, &e.Action, &e.Details, &e.CreatedAt, &e.Actor.ID, &e.Actor.Name, &e.Actor.ProfileImageURL, &targetID, &targetName, &targetImage); err != nil {
+			log.Println("GetGroupAuditLog scan error:", err)
+			continue
+		}
+		if targetID.Valid {
+			e.Target = &User{ID: int(targetID.Int64), Name: targetName.String, ProfileImageURL: targetImage.String}
+		}
+		entries = append(entries, e)
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
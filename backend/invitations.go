@@ -0,0 +1,504 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// invitationLiveClause filters out invitations whose expiry has passed.
+func invitationLiveClause(alias string) string {
+	return `(` + alias + `.expires_at IS NULL OR ` + alias + `.expires_at > CURRENT_TIMESTAMP)`
+}
+
+// invitationExpiry is when a newly sent invitation lapses. days <= 0 picks the
+// default, INVITATION_TTL.
+func invitationExpiry(days int) string {
+	ttl := getEnvDuration("INVITATION_TTL", 14*24*time.Hour)
+	if days > 0 {
+		ttl = time.Duration(days) * 24 * time.Hour
+	}
+	return sqlTime(time.Now().Add(ttl))
+}
+
+// SentInvitation is a pending invitation as its sender sees it.
+type SentInvitation struct {
+	ID          int    `json:"id"`
+	Receiver    User   `json:"receiver"`
+	InviteType  string `json:"inviteType"`
+	ReferenceID int    `json:"referenceId"`
+	CreatedAt   string `json:"createdAt"`
+	ExpiresAt   string `json:"expiresAt,omitempty"`
+}
+
+// GetSentInvitationsHandler lists my group and event invitations that are still
+// waiting for an answer, so I can revoke them.
+func GetSentInvitationsHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	rows, err := db.Query(`
+		SELECT i.id, i.invite_type, i.reference_id, i.created_at, i.expires_at,
+		       r.id, r.name, r.email, r.profile_image_url
+		FROM invitations i JOIN users r ON r.id = i.receiver_id
+		WHERE i.sender_id = ? AND i.status = 'pending' AND i.invite_type IN ('group', 'event') AND `+invitationLiveClause("i")+`
+		ORDER BY i.created_at DESC
+	`, myID)
+	if err != nil {
+		log.Println("GetSentInvitations error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	invitations := []SentInvitation{}
+	receivers := []User{}
+	for rows.Next() {
+		var inv SentInvitation
+		var expiresAt sql.NullString
+		if err := rows.Scan(&inv.ID, &inv.InviteType, &inv.ReferenceID, &inv.CreatedAt, &expiresAt,
+			&inv.Receiver.ID, &inv.Receiver.Name, &inv.Receiver.Email, &inv.Receiver.ProfileImageURL); err != nil {
+			log.Println("GetSentInvitations scan error:", err)
+			continue
+		}
+		inv.ExpiresAt = expiresAt.String
+		invitations = append(invitations, inv)
+		receivers = append(receivers, inv.Receiver)
+	}
+	if err := redactUsers(myID, c.GetString("role"), receivers); err != nil {
+		log.Println("GetSentInvitations redact error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for i := range invitations {
+		invitations[i].Receiver = receivers[i]
+	}
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// RevokeInvitationHandler withdraws an invitation I sent that hasn't been answered.
+func RevokeInvitationHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	invitationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+	res, err := db.Exec(`
+		UPDATE invitations SET status = 'revoked'
+		WHERE id = ? AND sender_id = ? AND status = 'pending' AND invite_type IN ('group', 'event')
+	`, invitationID, myID)
+	if err != nil {
+		log.Println("RevokeInvitation error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found or already handled"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+}
+
+// --- Invite Links ---
+
+// What an invite link grants.
+const (
+	linkGroup = "group" // membership, whatever the group's privacy
+	linkEvent = "event" // registration, subject to the usual checks
+)
+
+// InviteLink is a shareable code. Admins see who has used it.
+type InviteLink struct {
+	ID        int             `json:"id"`
+	Code      string          `json:"code"`
+	URL       string          `json:"url"`
+	Kind      string          `json:"kind"`
+	TargetID  int             `json:"targetId"`
+	CreatedBy User            `json:"createdBy"`
+	MaxUses   int             `json:"maxUses,omitempty"` // 0 means unlimited
+	Uses      int             `json:"uses"`
+	ExpiresAt string          `json:"expiresAt,omitempty"`
+	Revoked   bool            `json:"revoked"`
+	CreatedAt string          `json:"createdAt"`
+	UsedBy    []InviteLinkUse `json:"usedBy"`
+}
+
+type InviteLinkUse struct {
+	User   User   `json:"user"`
+	UsedAt string `json:"usedAt"`
+}
+
+// queryInviteLinks lists the links for a group or event, newest first, with
+// the people who used them.
+func queryInviteLinks(viewerID int, viewerRole, kind string, targetID int) ([]InviteLink, error) {
+	rows, err := db.Query(`
+		SELECT l.id, l.code, l.kind, l.target_id, l.max_uses, l.use_count, l.expires_at, l.revoked, l.created_at,
+		       u.id, u.name, u.email, u.profile_image_url
+		FROM invite_links l JOIN users u ON u.id = l.created_by
+		WHERE l.kind = ? AND l.target_id = ?
+		ORDER BY l.created_at DESC, l.id DESC
+	`, kind, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	links := []InviteLink{}
+	index := map[int]int{}
+	people := []User{}
+	for rows.Next() {
+		var l InviteLink
+		var maxUses sql.NullInt64
+		var expiresAt sql.NullString
+		if err := rows.Scan(&l.ID, &l.Code, &l.Kind, &l.TargetID, &maxUses, &l.Uses, &expiresAt, &l.Revoked, &l.CreatedAt,
+			&l.CreatedBy.ID, &l.CreatedBy.Name, &l.CreatedBy.Email, &l.CreatedBy.ProfileImageURL); err != nil {
+			return nil, err
+		}
+		l.URL = appBaseURL + "/invite/" + l.Code
+		l.MaxUses = int(maxUses.Int64)
+		l.ExpiresAt = expiresAt.String
+		l.UsedBy = []InviteLinkUse{}
+		index[l.ID] = len(links)
+		links = append(links, l)
+		people = append(people, l.CreatedBy)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	uses, err := db.Query(`
+		SELECT x.link_id, x.used_at, u.id, u.name, u.email, u.profile_image_url
+		FROM invite_link_uses x
+		JOIN invite_links l ON l.id = x.link_id
+		JOIN users u ON u.id = x.user_id
+		WHERE l.kind = ? AND l.target_id = ?
+		ORDER BY x.used_at
+	`, kind, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer uses.Close()
+	type useRow struct {
+		link int
+		use  InviteLinkUse
+	}
+	var used []useRow
+	for uses.Next() {
+		var r useRow
+		if err := uses.Scan(&r.link, &r.use.UsedAt, &r.use.User.ID, &r.use.User.Name, &r.use.User.Email, &r.use.User.ProfileImageURL); err != nil {
+			return nil, err
+		}
+		used = append(used, r)
+		people = append(people, r.use.User)
+	}
+	if err := uses.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := redactUsers(viewerID, viewerRole, people); err != nil {
+		return nil, err
+	}
+	for i := range links {
+		links[i].CreatedBy = people[i]
+	}
+	for i, r := range used {
+		r.use.User = people[len(links)+i]
+		l := &links[index[r.link]]
+		l.UsedBy = append(l.UsedBy, r.use)
+	}
+	return links, nil
+}
+
+// createInviteLink mints a link from the request body: {"maxUses": n,
+// "expiresInHours": h}, both optional.
+func createInviteLink(c *gin.Context, kind string, targetID int) {
+	myID := c.GetInt("userID")
+	var payload struct {
+		MaxUses        int `json:"maxUses"`
+		ExpiresInHours int `json:"expiresInHours"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	if payload.MaxUses < 0 || payload.ExpiresInHours < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "maxUses and expiresInHours cannot be negative"})
+		return
+	}
+	var maxUses, expiresAt interface{}
+	if payload.MaxUses > 0 {
+		maxUses = payload.MaxUses
+	}
+	if payload.ExpiresInHours > 0 {
+		expiresAt = sqlTime(time.Now().Add(time.Duration(payload.ExpiresInHours) * time.Hour))
+	}
+	code, err := randomURLToken(12)
+	if err != nil {
+		log.Println("CreateInviteLink (code) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create link"})
+		return
+	}
+	_, err = db.Exec(`
+		INSERT INTO invite_links (code, kind, target_id, created_by, max_uses, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, code, kind, targetID, myID, maxUses, expiresAt)
+	if err != nil {
+		log.Println("CreateInviteLink error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	links, err := queryInviteLinks(myID, c.GetString("role"), kind, targetID)
+	if err != nil || len(links) == 0 {
+		log.Println("CreateInviteLink (reload) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusCreated, links[0])
+}
+
+func listInviteLinks(c *gin.Context, kind string, targetID int) {
+	links, err := queryInviteLinks(c.GetInt("userID"), c.GetString("role"), kind, targetID)
+	if err != nil {
+		log.Println("GetInviteLinks error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"links": links})
+}
+
+// revokeInviteLink stops a link working. Its history stays visible.
+func revokeInviteLink(c *gin.Context, kind string, targetID int) {
+	linkID, err := strconv.Atoi(c.Param("linkId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return
+	}
+	res, err := db.Exec(`UPDATE invite_links SET revoked = 1 WHERE id = ? AND kind = ? AND target_id = ?`, linkID, kind, targetID)
+	if err != nil {
+		log.Println("RevokeInviteLink error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Link revoked"})
+}
+
+// requireEventManager parses :id and checks the caller may manage the event.
+func requireEventManager(c *gin.Context) (int, bool) {
+	eventID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return 0, false
+	}
+	allowed, err := canManageEvent(eventID, c.GetInt("userID"), c.GetString("role"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return 0, false
+	}
+	if err != nil {
+		log.Println("requireEventManager error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return 0, false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the event's organizer or its group's admins can do this"})
+		return 0, false
+	}
+	return eventID, true
+}
+
+func CreateGroupInviteLinkHandler(c *gin.Context) {
+	if groupID, ok := requireGroupAdmin(c); ok {
+		createInviteLink(c, linkGroup, groupID)
+	}
+}
+
+func GetGroupInviteLinksHandler(c *gin.Context) {
+	if groupID, ok := requireGroupAdmin(c); ok {
+		listInviteLinks(c, linkGroup, groupID)
+	}
+}
+
+func RevokeGroupInviteLinkHandler(c *gin.Context) {
+	if groupID, ok := requireGroupAdmin(c); ok {
+		revokeInviteLink(c, linkGroup, groupID)
+	}
+}
+
+func CreateEventInviteLinkHandler(c *gin.Context) {
+	if eventID, ok := requireEventManager(c); ok {
+		createInviteLink(c, linkEvent, eventID)
+	}
+}
+
+func GetEventInviteLinksHandler(c *gin.Context) {
+	if eventID, ok := requireEventManager(c); ok {
+		listInviteLinks(c, linkEvent, eventID)
+	}
+}
+
+func RevokeEventInviteLinkHandler(c *gin.Context) {
+	if eventID, ok := requireEventManager(c); ok {
+		revokeInviteLink(c, linkEvent, eventID)
+	}
+}
+
+// usableInviteLink looks up a code and checks it still works. On failure it
+// returns the status and message to respond with.
+func usableInviteLink(code string) (InviteLink, int, string) {
+	var l InviteLink
+	var maxUses sql.NullInt64
+	var expiresAt sql.NullString
+	var expired bool
+	err := db.QueryRow(`
+		SELECT id, kind, target_id, max_uses, use_count, expires_at, revoked,
+		       expires_at IS NOT NULL AND expires_at <= CURRENT_TIMESTAMP
+		FROM invite_links WHERE code = ?
+	`, code).Scan(&l.ID, &l.Kind, &l.TargetID, &maxUses, &l.Uses, &expiresAt, &l.Revoked, &expired)
+	if err != nil {
+		return l, http.StatusNotFound, "Invite link not found"
+	}
+	l.Code = code
+	l.MaxUses = int(maxUses.Int64)
+	l.ExpiresAt = expiresAt.String
+	switch {
+	case l.Revoked:
+		return l, http.StatusGone, "This invite link has been revoked"
+	case expired:
+		return l, http.StatusGone, "This invite link has expired"
+	case l.MaxUses > 0 && l.Uses >= l.MaxUses:
+		return l, http.StatusGone, "This invite link has been used up"
+	}
+	return l, 0, ""
+}
+
+// GetInviteLinkHandler previews where a code leads, so the app can show what
+// the user is about to join.
+func GetInviteLinkHandler(c *gin.Context) {
+	link, status, message := usableInviteLink(c.Param("code"))
+	if status == http.StatusNotFound {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+	resp := gin.H{"kind": link.Kind, "valid": status == 0, "expiresAt": link.ExpiresAt}
+	if status != 0 {
+		resp["reason"] = message
+	}
+	switch link.Kind {
+	case linkGroup:
+		var g Group
+		err := db.QueryRow(`
+			SELECT g.id, g.name, g.description, g.profile_image_url, g.privacy, COUNT(m.user_id)
+			FROM groups g LEFT JOIN group_members m ON m.group_id = g.id
+			WHERE g.id = ? GROUP BY g.id
+		`, link.TargetID).Scan(&g.ID, &g.Name, &g.Description, &g.ProfileImageURL, &g.Privacy, &g.MemberCount)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invite link not found"})
+			return
+		}
+		role, _ := groupRole(g.ID, c.GetInt("userID"))
+		g.IsMember = role != ""
+		resp["group"] = g
+	case linkEvent:
+		var e Event
+		err := db.QueryRow(`SELECT id, name, date, start_time, end_time, location_address, image_url FROM events WHERE id = ?`, link.TargetID).
+			Scan(&e.ID, &e.Name, &e.Date, &e.StartTime, &e.EndTime, &e.LocationAddress, &e.ImageURL)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invite link not found"})
+			return
+		}
+		resp["event"] = e
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// AcceptInviteLinkHandler joins the group or registers for the event behind a
+// code. Using a link again after it has already worked for you is a no-op.
+func AcceptInviteLinkHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	link, status, message := usableInviteLink(c.Param("code"))
+	if status != 0 {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+	var already int
+	var join string
+	switch link.Kind {
+	case linkGroup:
+		if isBannedFromGroup(link.TargetID, myID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You have been banned from this group"})
+			return
+		}
+		db.QueryRow(`SELECT COUNT(*) FROM group_members WHERE group_id = ? AND user_id = ?`, link.TargetID, myID).Scan(&already)
+		join = `INSERT OR IGNORE INTO group_members (group_id, user_id, role) VALUES (?, ?, 'member')`
+	case linkEvent:
+		db.QueryRow(`SELECT COUNT(*) FROM registrations WHERE event_id = ? AND user_id = ?`, link.TargetID, myID).Scan(&already)
+		if already == 0 {
+			if status, body := registrationBlocked(myID, link.TargetID); status != 0 {
+				c.JSON(status, body)
+				return
+			}
+		}
+		join = `INSERT OR IGNORE INTO registrations (event_id, user_id) VALUES (?, ?)`
+	}
+	if already > 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "You're already in", "kind": link.Kind, "targetId": link.TargetID})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("AcceptInviteLink (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Claim a use first so two people can't both take the last one.
+	res, err := tx.Exec(`
+		UPDATE invite_links SET use_count = use_count + 1
+		WHERE id = ? AND revoked = 0 AND (max_uses IS NULL OR use_count < max_uses)
+	`, link.ID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("AcceptInviteLink (claim) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusGone, gin.H{"error": "This invite link has been used up"})
+		return
+	}
+	stmts := []string{join}
+	if link.Kind == linkGroup {
+		// A pending request is moot once the link has let them in.
+		stmts = append(stmts, `DELETE FROM group_join_requests WHERE group_id = ? AND user_id = ?`, clearJoinAnswersSQL)
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, link.TargetID, myID); err != nil {
+			tx.Rollback()
+			log.Println("AcceptInviteLink error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO invite_link_uses (link_id, user_id) VALUES (?, ?)`, link.ID, myID); err != nil {
+		tx.Rollback()
+		log.Println("AcceptInviteLink (record use) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("AcceptInviteLink (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	message = "Joined group"
+	if link.Kind == linkEvent {
+		message = "Registered for event"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "kind": link.Kind, "targetId": link.TargetID})
+}
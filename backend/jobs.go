@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// startBackgroundJobs launches the periodic maintenance tasks.
+func startBackgroundJobs() {
+	go runEvery("purge deleted accounts", getEnvDuration("ACCOUNT_PURGE_INTERVAL", time.Hour), purgeDeletedAccounts)
+	go runEvery("certification expiry reminders", getEnvDuration("CERT_REMINDER_INTERVAL", 24*time.Hour), sendCertificationReminders)
+	go runEvery("prune stream events", time.Hour, pruneStreamEvents)
+	go runEvery("prune login attempts", time.Hour, pruneLoginAttempts)
+	go runEvery("event reminders", getEnvDuration("EVENT_REMINDER_INTERVAL", time.Minute), sendEventReminders)
+	go runEvery("webhook deliveries", getEnvDuration("WEBHOOK_INTERVAL", 5*time.Second), deliverWebhooks)
+	go runEvery("prune webhook deliveries", time.Hour, pruneWebhookDeliveries)
+	go runEvery("notification emails", getEnvDuration("NOTIFICATION_EMAIL_INTERVAL", time.Minute), sendNotificationEmails)
+	go runEvery("notification digests", getEnvDuration("NOTIFICATION_DIGEST_INTERVAL", time.Hour), sendNotificationDigests)
+}
+
+// runEvery runs job once straight away and then on every tick, logging failures.
+func runEvery(name string, interval time.Duration, job func() error) {
+	for {
+		if err := job(); err != nil {
+			log.Printf("Job %q failed: %v", name, err)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"sort"
+	"strings"
+)
+
+// Email is a plain-text message. Headers carries extras such as List-Unsubscribe.
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+	Headers map[string]string
+}
+
+// Mailer delivers email. SMTP is used when SMTP_HOST is set; otherwise messages
+// are written to the log, which is enough for local development.
+type Mailer interface {
+	Send(msg Email) error
+}
+
+var mailer Mailer = logMailer{}
+
+// appBaseURL is where links in outgoing email point.
+var appBaseURL = "http://localhost:8080"
+
+func initMailer() {
+	appBaseURL = strings.TrimSuffix(getEnv("APP_BASE_URL", appBaseURL), "/")
+	host := getEnv("SMTP_HOST", "")
+	if host == "" {
+		mailer = logMailer{}
+		return
+	}
+	m := smtpMailer{
+		addr: fmt.Sprintf("%s:%d", host, getEnvInt("SMTP_PORT", 587)),
+		from: getEnv("MAIL_FROM", "no-reply@vms.local"),
+	}
+	if username := getEnv("SMTP_USERNAME", ""); username != "" {
+		m.auth = smtp.PlainAuth("", username, getEnv("SMTP_PASSWORD", ""), host)
+	}
+	mailer = m
+}
+
+type logMailer struct{}
+
+func (logMailer) Send(msg Email) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (m smtpMailer) Send(msg Email) error {
+	headers := map[string]string{
+		"From":         m.from,
+		"To":           msg.To,
+		"Subject":      msg.Subject,
+		"MIME-Version": "1.0",
+		"Content-Type": "text/plain; charset=UTF-8",
+	}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		// Header values come from our own code, but never let a newline through.
+		fmt.Fprintf(&b, "%s: %s\r\n", k, strings.NewReplacer("\r", "", "\n", "").Replace(headers[k]))
+	}
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, []byte(b.String()))
+}
+
+// sendEmailAsync keeps slow mail servers out of the request path.
+func sendEmailAsync(msg Email) {
+	go func() {
+		if err := mailer.Send(msg); err != nil {
+			log.Printf("Failed to send email to %s: %v", msg.To, err)
+		}
+	}()
+}
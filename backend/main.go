@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -20,6 +21,8 @@ import (
 var db *sql.DB
 var jwtKey = []byte("my_secret_key")
 
+const sessionTTL = 24 * time.Hour
+
 // --- Struct Definitions ---
 type User struct {
 	ID              int    `json:"id"`
@@ -27,7 +30,10 @@ type User struct {
 	Email           string `json:"email"`
 	Role            string `json:"role"`
 	ProfileImageURL string `json:"profileImageUrl"`
-	IsFollowed      bool   `json."isFollowed"`
+	IsFollowed      bool   `json:"isFollowed"`
+	FollowRequested bool   `json:"followRequested,omitempty"` // a follow request from me is awaiting approval
+	PendingEmail    string `json:"pendingEmail,omitempty"`
+	ProfileDetails
 }
 type Credentials struct {
 	Email    string `json:"email"`
@@ -36,32 +42,41 @@ type Credentials struct {
 type Claims struct {
 	UserID int    `json:"userId"`
 	Role   string `json:"role"`
+	Scope  string `json:"scope,omitempty"` // empty for a full session
 	jwt.RegisteredClaims
 }
 type SkillsPayload struct {
-	Skills []string `json:"skills"`
+	Skills []SkillSelection `json:"skills"`
 }
 type VolunteerInfo struct {
-	ID              int      `json:"id"`
-	Name            string   `json:"name"`
-	Email           string   `json:"email"`
-	ProfileImageURL string   `json:"profileImageUrl"`
-	Skills          []string `json:"skills"`
+	ID              int         `json:"id"`
+	Name            string      `json:"name"`
+	Email           string      `json:"email"`
+	ProfileImageURL string      `json:"profileImageUrl"`
+	Skills          []string    `json:"skills"`
+	SkillDetails    []UserSkill `json:"skillDetails"`
+	ProfileDetails
 }
 type Event struct {
-	ID                      int      `json:"id"`
-	Name                    string   `json:"name"`
-	Date                    string   `json:"date"`
-	Description             string   `json:"description"`
-	CreatedBy               int      `json:"createdBy"`
-	CreatedByEmail          string   `json:"createdByEmail"`
-	CreatedByName           string   `json:"createdByName"`
-	OrganizerProfilePicture string   `json:"organizerProfilePicture"`
-	ImageURL                string   `json:"imageUrl"`
-	LocationAddress         string   `json:"locationAddress"`
-	IsRegistered            bool     `json:"isRegistered"`
-	FollowersGoing          []string `json:"followersGoing"`
-	FollowersGoingCount     int      `json:"followersGoingCount"`
+	ID                      int                 `json:"id"`
+	Name                    string              `json:"name"`
+	Date                    string              `json:"date"`
+	StartTime               string              `json:"startTime,omitempty"` // "15:04", optional
+	EndTime                 string              `json:"endTime,omitempty"`
+	Description             string              `json:"description"`
+	CreatedBy               int                 `json:"createdBy"`
+	CreatedByEmail          string              `json:"createdByEmail"`
+	CreatedByName           string              `json:"createdByName"`
+	OrganizerProfilePicture string              `json:"organizerProfilePicture"`
+	ImageURL                string              `json:"imageUrl"`
+	LocationAddress         string              `json:"locationAddress"`
+	IsRegistered            bool                `json:"isRegistered"`
+	FollowersGoing          []string            `json:"followersGoing"`
+	FollowersGoingCount     int                 `json:"followersGoingCount"`
+	RequiredCertifications  []CertificationType `json:"requiredCertifications,omitempty"`
+	HostGroupID             int                 `json:"hostGroupId,omitempty"`
+	HostGroupName           string              `json:"hostGroupName,omitempty"`
+	MembersOnly             bool                `json:"membersOnly,omitempty"`
 }
 type RegisterPayload struct {
 	Name     string `json:"name"`
@@ -77,25 +92,33 @@ type Group struct {
 	CreatedByUserID int    `json:"createdByUserID"`
 	MemberCount     int    `json:"memberCount"`
 	IsMember        bool   `json:"isMember"`
+	Privacy         string `json:"privacy,omitempty"` // "open", "closed" or "secret"
 }
 type GroupDetails struct {
-	ID                int    `json:"id"`
-	Name              string `json:"name"`
-	Description       string `json:"description"`
-	ProfileImageURL   string `json:"profileImageUrl"`
-	CreatedByUserID   int    `json:"createdByUserID"`
-	Members           []User `json:"members"`
-	IsMember          bool   `json:"isMember"`
-	IsAdmin           bool   `json:"isAdmin"`
-	HasPendingRequest bool   `json:"hasPendingRequest"`
+	ID                int            `json:"id"`
+	Name              string         `json:"name"`
+	Description       string         `json:"description"`
+	ProfileImageURL   string         `json:"profileImageUrl"`
+	CreatedByUserID   int            `json:"createdByUserID"`
+	Privacy           string         `json:"privacy"`
+	Members           []User         `json:"members"`
+	IsMember          bool           `json:"isMember"`
+	IsAdmin           bool           `json:"isAdmin"`
+	HasPendingRequest bool           `json:"hasPendingRequest"`
+	MyRole            string         `json:"myRole,omitempty"`
+	MemberRoles       map[int]string `json:"memberRoles"` // group role by user id
+	Events            []Event        `json:"events"`      // upcoming events the group hosts
 }
 type Invitation struct {
-	ID         int    `json:"id"`
-	Sender     User   `json:"sender"`
-	Group      *Group `json:"group,omitempty"`
-	InviteType string `json:"inviteType"`
-	Status     string `json:"status"`
-	CreatedAt  string `json:"createdAt"`
+	ID         int        `json:"id"`
+	Sender     User       `json:"sender"`
+	Group      *Group     `json:"group,omitempty"`
+	Event      *Event     `json:"event,omitempty"`
+	Post       *GroupPost `json:"post,omitempty"` // for announcements
+	InviteType string     `json:"inviteType"`
+	Status     string     `json:"status"`
+	CreatedAt  string     `json:"createdAt"`
+	ExpiresAt  string     `json:"expiresAt,omitempty"`
 }
 
 // initDB initializes the database and creates tables if they don't exist
@@ -163,7 +186,7 @@ func initDB() {
 	CREATE TABLE IF NOT EXISTS group_members (
 		group_id INTEGER,
 		user_id INTEGER,
-		role TEXT NOT NULL, -- "admin" or "member"
+		role TEXT NOT NULL, -- "admin", "moderator" or "member"
 		PRIMARY KEY (group_id, user_id),
 		FOREIGN KEY (group_id) REFERENCES groups (id) ON DELETE CASCADE,
 		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
@@ -181,9 +204,9 @@ func initDB() {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		sender_id INTEGER NOT NULL,
 		receiver_id INTEGER NOT NULL,
-		invite_type TEXT NOT NULL, -- "group" or "event"
-		reference_id INTEGER NOT NULL, -- group_id or event_id
-		status TEXT NOT NULL DEFAULT "pending", -- "pending", "accepted", "declined"
+		invite_type TEXT NOT NULL, -- "group", "event" or "follow" (a request to follow a private account)
+		reference_id INTEGER NOT NULL, -- group_id, event_id, or the followed user's id
+		status TEXT NOT NULL DEFAULT "pending", -- "pending", "accepted", "declined", "revoked"
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE,
 		FOREIGN KEY (receiver_id) REFERENCES users (id) ON DELETE CASCADE
@@ -199,6 +222,522 @@ func initDB() {
 	execOrFatal(db, createGroupJoinRequestsTable)
 	execOrFatal(db, createInvitationsTable)
 
+	// Two-factor authentication
+	addColumnIfMissing(db, "users", "totp_secret", "TEXT")
+	addColumnIfMissing(db, "users", "totp_pending_secret", "TEXT")
+	addColumnIfMissing(db, "users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfMissing(db, "users", "totp_last_counter", "INTEGER NOT NULL DEFAULT 0")
+	createRecoveryCodesTable := `
+	CREATE TABLE IF NOT EXISTS user_recovery_codes (
+		user_id INTEGER,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME,
+		PRIMARY KEY (user_id, code_hash),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	createAppSettingsTable := `
+	CREATE TABLE IF NOT EXISTS app_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);`
+	execOrFatal(db, createRecoveryCodesTable)
+	execOrFatal(db, createAppSettingsTable)
+
+	// Single sign-on
+	createUserIdentitiesTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		email TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (issuer, subject),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	createOIDCLoginStatesTable := `
+	CREATE TABLE IF NOT EXISTS oidc_login_states (
+		state TEXT PRIMARY KEY,
+		nonce TEXT NOT NULL,
+		code_verifier TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	execOrFatal(db, createUserIdentitiesTable)
+	execOrFatal(db, createOIDCLoginStatesTable)
+
+	// Login throttling
+	createLoginAttemptsTable := `
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		account TEXT PRIMARY KEY, -- normalised email, or "2fa:<user id>" for second-factor attempts
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_failure_at DATETIME NOT NULL,
+		locked_until DATETIME
+	);`
+	execOrFatal(db, createLoginAttemptsTable)
+
+	// Editable profiles
+	addColumnIfMissing(db, "users", "bio", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfMissing(db, "users", "pronouns", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfMissing(db, "users", "city", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfMissing(db, "users", "phone", "TEXT NOT NULL DEFAULT ''")
+	createUserLinksTable := `
+	CREATE TABLE IF NOT EXISTS user_links (
+		user_id INTEGER,
+		label TEXT NOT NULL,
+		url TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	createUserLanguagesTable := `
+	CREATE TABLE IF NOT EXISTS user_languages (
+		user_id INTEGER,
+		language TEXT NOT NULL,
+		PRIMARY KEY (user_id, language),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	createEmailVerificationsTable := `
+	CREATE TABLE IF NOT EXISTS email_verifications (
+		token TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		email TEXT NOT NULL, -- the new address waiting to be confirmed
+		expires_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createUserLinksTable)
+	execOrFatal(db, createUserLanguagesTable)
+	execOrFatal(db, createEmailVerificationsTable)
+
+	// Privacy settings. Users without a row get defaultPrivacySettings.
+	createUserPrivacyTable := `
+	CREATE TABLE IF NOT EXISTS user_privacy (
+		user_id INTEGER PRIMARY KEY,
+		email_visibility TEXT NOT NULL, -- "everyone", "followers" or "only_me"
+		phone_visibility TEXT NOT NULL,
+		registrations_visibility TEXT NOT NULL,
+		followers_visibility TEXT NOT NULL,
+		skills_visibility TEXT NOT NULL,
+		discoverable INTEGER NOT NULL DEFAULT 1,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createUserPrivacyTable)
+	addColumnIfMissing(db, "user_privacy", "private_account", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfMissing(db, "user_privacy", "availability_visibility", "TEXT NOT NULL DEFAULT 'everyone'")
+	addColumnIfMissing(db, "user_privacy", "messages_from", "TEXT NOT NULL DEFAULT 'everyone'")
+
+	// Blocking hides two users from each other; muting only quiets my feed.
+	createUserBlocksTable := `
+	CREATE TABLE IF NOT EXISTS user_blocks (
+		blocker_id INTEGER NOT NULL,
+		blocked_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (blocker_id, blocked_id),
+		FOREIGN KEY (blocker_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (blocked_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	createUserMutesTable := `
+	CREATE TABLE IF NOT EXISTS user_mutes (
+		muter_id INTEGER NOT NULL,
+		muted_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (muter_id, muted_id),
+		FOREIGN KEY (muter_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (muted_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createUserBlocksTable)
+	execOrFatal(db, createUserMutesTable)
+
+	// Account deletion: scheduled first, anonymized once the grace period ends.
+	addColumnIfMissing(db, "users", "deletion_scheduled_for", "DATETIME")
+	addColumnIfMissing(db, "users", "deleted_at", "DATETIME")
+
+	// Availability. Times are "15:04" strings; weekday follows time.Weekday (0 = Sunday).
+	addColumnIfMissing(db, "events", "start_time", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfMissing(db, "events", "end_time", "TEXT NOT NULL DEFAULT ''")
+	createAvailabilityWindowsTable := `
+	CREATE TABLE IF NOT EXISTS availability_windows (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		weekday INTEGER NOT NULL,
+		start_time TEXT NOT NULL,
+		end_time TEXT NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	createBlackoutDatesTable := `
+	CREATE TABLE IF NOT EXISTS availability_blackouts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		start_date TEXT NOT NULL,
+		end_date TEXT NOT NULL, -- inclusive
+		reason TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createAvailabilityWindowsTable)
+	execOrFatal(db, createBlackoutDatesTable)
+
+	// Skill catalog. user_skills.skill keeps the canonical name for older queries.
+	createSkillsTable := `
+	CREATE TABLE IF NOT EXISTS skills (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		category TEXT NOT NULL,
+		requires_verification INTEGER NOT NULL DEFAULT 0
+	);`
+	createSkillSynonymsTable := `
+	CREATE TABLE IF NOT EXISTS skill_synonyms (
+		synonym TEXT PRIMARY KEY, -- stored normalized, see normalizeSkillName
+		skill_id INTEGER NOT NULL,
+		FOREIGN KEY (skill_id) REFERENCES skills (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createSkillsTable)
+	execOrFatal(db, createSkillSynonymsTable)
+	addColumnIfMissing(db, "user_skills", "skill_id", "INTEGER REFERENCES skills (id)")
+	addColumnIfMissing(db, "user_skills", "proficiency", "TEXT NOT NULL DEFAULT '"+defaultProficiency+"'")
+	addColumnIfMissing(db, "user_skills", "verified_by", "INTEGER REFERENCES users (id)")
+	addColumnIfMissing(db, "user_skills", "verified_at", "DATETIME")
+	addColumnIfMissing(db, "user_skills", "verification_expires_at", "DATETIME")
+	seedSkillCatalog()
+	migrateFreeTextSkills()
+
+	// Certifications. Documents are stored under documents/, never served statically.
+	createCertificationTypesTable := `
+	CREATE TABLE IF NOT EXISTS certification_types (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		description TEXT NOT NULL DEFAULT ''
+	);`
+	createUserCertificationsTable := `
+	CREATE TABLE IF NOT EXISTS user_certifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		certification_type_id INTEGER NOT NULL,
+		issuer TEXT NOT NULL,
+		expires_at TEXT NOT NULL, -- YYYY-MM-DD
+		document_path TEXT NOT NULL,
+		document_name TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending', -- "pending", "verified", "rejected"
+		reviewed_by INTEGER,
+		reviewed_at DATETIME,
+		review_note TEXT NOT NULL DEFAULT '',
+		reminder_sent_at DATETIME,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (certification_type_id) REFERENCES certification_types (id)
+	);`
+	createEventRequiredCertificationsTable := `
+	CREATE TABLE IF NOT EXISTS event_required_certifications (
+		event_id INTEGER NOT NULL,
+		certification_type_id INTEGER NOT NULL,
+		PRIMARY KEY (event_id, certification_type_id),
+		FOREIGN KEY (event_id) REFERENCES events (id) ON DELETE CASCADE,
+		FOREIGN KEY (certification_type_id) REFERENCES certification_types (id)
+	);`
+	execOrFatal(db, createCertificationTypesTable)
+	execOrFatal(db, createUserCertificationsTable)
+	execOrFatal(db, createEventRequiredCertificationsTable)
+	seedCertificationTypes()
+
+	// Group moderation
+	createGroupBansTable := `
+	CREATE TABLE IF NOT EXISTS group_bans (
+		group_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		banned_by INTEGER NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (group_id, user_id),
+		FOREIGN KEY (group_id) REFERENCES groups (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	createGroupAuditLogTable := `
+	CREATE TABLE IF NOT EXISTS group_audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		group_id INTEGER NOT NULL,
+		actor_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		target_user_id INTEGER,
+		details TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (group_id) REFERENCES groups (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createGroupBansTable)
+	execOrFatal(db, createGroupAuditLogTable)
+	// "open" groups can be joined instantly, "closed" ones need approval and
+	// "secret" ones are invite-only and hidden from non-members.
+	addColumnIfMissing(db, "groups", "privacy", "TEXT NOT NULL DEFAULT 'closed'")
+
+	// Group-hosted events. Admins of the host group manage the event alongside
+	// its creator; members-only events are hidden from everyone else.
+	addColumnIfMissing(db, "events", "host_group_id", "INTEGER REFERENCES groups (id)")
+	addColumnIfMissing(db, "events", "members_only", "INTEGER NOT NULL DEFAULT 0")
+
+	// Group feed
+	createGroupPostsTable := `
+	CREATE TABLE IF NOT EXISTS group_posts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		group_id INTEGER NOT NULL,
+		author_id INTEGER NOT NULL,
+		kind TEXT NOT NULL, -- "announcement" or "discussion"
+		title TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL,
+		pinned INTEGER NOT NULL DEFAULT 0,
+		locked INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (group_id) REFERENCES groups (id) ON DELETE CASCADE,
+		FOREIGN KEY (author_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	createGroupPostCommentsTable := `
+	CREATE TABLE IF NOT EXISTS group_post_comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_id INTEGER NOT NULL,
+		author_id INTEGER NOT NULL,
+		body TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (post_id) REFERENCES group_posts (id) ON DELETE CASCADE,
+		FOREIGN KEY (author_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	createGroupPostReactionsTable := `
+	CREATE TABLE IF NOT EXISTS group_post_reactions (
+		post_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		reaction TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (post_id, user_id, reaction),
+		FOREIGN KEY (post_id) REFERENCES group_posts (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createGroupPostsTable)
+	execOrFatal(db, createGroupPostCommentsTable)
+	execOrFatal(db, createGroupPostReactionsTable)
+
+	// Join-request screening. Denials reach the applicant as a notification
+	// carrying the moderator's message.
+	createGroupQuestionsTable := `
+	CREATE TABLE IF NOT EXISTS group_questions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		group_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		kind TEXT NOT NULL, -- "text", "choice" or "skill"
+		prompt TEXT NOT NULL,
+		options TEXT NOT NULL DEFAULT '[]', -- JSON array, for choice questions
+		skill_id INTEGER, -- for skill questions
+		required INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY (group_id) REFERENCES groups (id) ON DELETE CASCADE,
+		FOREIGN KEY (skill_id) REFERENCES skills (id)
+	);`
+	createGroupJoinAnswersTable := `
+	CREATE TABLE IF NOT EXISTS group_join_answers (
+		group_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		question_id INTEGER NOT NULL,
+		answer TEXT NOT NULL,
+		PRIMARY KEY (group_id, user_id, question_id),
+		FOREIGN KEY (question_id) REFERENCES group_questions (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createGroupQuestionsTable)
+	execOrFatal(db, createGroupJoinAnswersTable)
+	addColumnIfMissing(db, "invitations", "message", "TEXT NOT NULL DEFAULT ''") // only read by migrateNotifications
+
+	// Invitation expiry and shareable invite links
+	addColumnIfMissing(db, "invitations", "expires_at", "DATETIME") // NULL never expires
+	createInviteLinksTable := `
+	CREATE TABLE IF NOT EXISTS invite_links (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL UNIQUE,
+		kind TEXT NOT NULL, -- "group" or "event"
+		target_id INTEGER NOT NULL, -- group_id or event_id
+		created_by INTEGER NOT NULL,
+		max_uses INTEGER, -- NULL is unlimited
+		use_count INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME, -- NULL never expires
+		revoked INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (created_by) REFERENCES users (id)
+	);`
+	createInviteLinkUsesTable := `
+	CREATE TABLE IF NOT EXISTS invite_link_uses (
+		link_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (link_id, user_id),
+		FOREIGN KEY (link_id) REFERENCES invite_links (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createInviteLinksTable)
+	execOrFatal(db, createInviteLinkUsesTable)
+	execOrFatal(db, `CREATE INDEX IF NOT EXISTS idx_invite_links_target ON invite_links (kind, target_id)`)
+
+	// Notifications. Invitations remain the record of what can be accepted or
+	// declined; each gets a notification of type "invitation".
+	createNotificationsTable := `
+	CREATE TABLE IF NOT EXISTS notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		type TEXT NOT NULL,
+		actor_id INTEGER, -- NULL for system notices
+		payload TEXT NOT NULL DEFAULT '{}', -- JSON, shape depends on type
+		invitation_id INTEGER,
+		read_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE SET NULL,
+		FOREIGN KEY (invitation_id) REFERENCES invitations (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createNotificationsTable)
+	execOrFatal(db, `CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications (user_id, id)`)
+	execOrFatal(db, `CREATE INDEX IF NOT EXISTS idx_notifications_invitation ON notifications (invitation_id)`)
+	// Set on insert from the recipient's preference: 'pending' until emailed,
+	// 'digest' until batched, then 'sent'. NULL when not emailed at all.
+	addColumnIfMissing(db, "notifications", "email_status", "TEXT")
+	execOrFatal(db, `CREATE INDEX IF NOT EXISTS idx_notifications_email ON notifications (email_status, user_id)`)
+
+	// Notification preferences: a channel per type, plus digest and quiet hours.
+	createNotificationPreferencesTable := `
+	CREATE TABLE IF NOT EXISTS notification_preferences (
+		user_id INTEGER NOT NULL,
+		type TEXT NOT NULL,
+		channel TEXT NOT NULL, -- in_app, email, digest or off
+		PRIMARY KEY (user_id, type),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	createNotificationSettingsTable := `
+	CREATE TABLE IF NOT EXISTS notification_settings (
+		user_id INTEGER PRIMARY KEY,
+		digest_frequency TEXT NOT NULL DEFAULT 'daily', -- daily or weekly
+		quiet_start TEXT NOT NULL DEFAULT '', -- HH:MM, empty when not set
+		quiet_end TEXT NOT NULL DEFAULT '',
+		timezone TEXT NOT NULL DEFAULT 'UTC',
+		last_digest_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createNotificationPreferencesTable)
+	execOrFatal(db, createNotificationSettingsTable)
+	migrateNotifications()
+
+	// Event reminders. A row claims one reminder for one registrant; starts_at is
+	// part of the key so a rescheduled event is reminded about afresh.
+	createEventRemindersSentTable := `
+	CREATE TABLE IF NOT EXISTS event_reminders_sent (
+		event_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		starts_at DATETIME NOT NULL,
+		offset_minutes INTEGER NOT NULL,
+		claim TEXT NOT NULL, -- random per run; picks out the rows that run inserted
+		sent_at DATETIME NOT NULL,
+		PRIMARY KEY (event_id, user_id, starts_at, offset_minutes),
+		FOREIGN KEY (event_id) REFERENCES events (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createEventRemindersSentTable)
+	execOrFatal(db, `CREATE INDEX IF NOT EXISTS idx_event_reminders_claim ON event_reminders_sent (claim)`)
+
+	// Direct messages. direct_key is "<low id>:<high id>" for a conversation
+	// between two people, so there is only ever one per pair; group
+	// conversations leave it NULL.
+	createConversationsTable := `
+	CREATE TABLE IF NOT EXISTS conversations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		direct_key TEXT UNIQUE,
+		title TEXT,
+		created_by INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_message_at DATETIME, -- NULL until the first message
+		FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL
+	);`
+	createConversationParticipantsTable := `
+	CREATE TABLE IF NOT EXISTS conversation_participants (
+		conversation_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		last_read_message_id INTEGER NOT NULL DEFAULT 0,
+		joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		left_at DATETIME, -- set when they leave; kept for read receipts
+		PRIMARY KEY (conversation_id, user_id),
+		FOREIGN KEY (conversation_id) REFERENCES conversations (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+	createMessagesTable := `
+	CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		conversation_id INTEGER NOT NULL,
+		sender_id INTEGER NOT NULL,
+		body TEXT NOT NULL,
+		event_id INTEGER, -- set when sent to all of an event's registrants
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (conversation_id) REFERENCES conversations (id) ON DELETE CASCADE,
+		FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (event_id) REFERENCES events (id) ON DELETE SET NULL
+	);`
+	execOrFatal(db, createConversationsTable)
+	execOrFatal(db, createConversationParticipantsTable)
+	execOrFatal(db, createMessagesTable)
+	execOrFatal(db, `CREATE INDEX IF NOT EXISTS idx_conversation_participants_user ON conversation_participants (user_id)`)
+	execOrFatal(db, `CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages (conversation_id, id)`)
+
+	// Live stream. Rows are written by the triggers in stream.go and kept for
+	// STREAM_RETENTION so reconnecting clients can catch up.
+	createStreamEventsTable := `
+	CREATE TABLE IF NOT EXISTS stream_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER, -- set for events meant for one user
+		topic TEXT, -- "group:<id>" or "event:<id>" for shared ones
+		type TEXT NOT NULL,
+		data TEXT NOT NULL, -- JSON
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	execOrFatal(db, createStreamEventsTable)
+	execOrFatal(db, `CREATE INDEX IF NOT EXISTS idx_stream_events_created ON stream_events (created_at)`)
+	for _, trigger := range streamTriggers {
+		execOrFatal(db, trigger)
+	}
+
+	// Webhooks. Events are recorded once by the triggers in webhooks.go and
+	// delivered to each subscribed webhook separately.
+	createWebhooksTable := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		group_id INTEGER NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL, -- JSON array of event types
+		active INTEGER NOT NULL DEFAULT 1,
+		created_by INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (group_id) REFERENCES groups (id) ON DELETE CASCADE,
+		FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL
+	);`
+	createWebhookEventsTable := `
+	CREATE TABLE IF NOT EXISTS webhook_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		group_id INTEGER NOT NULL,
+		type TEXT NOT NULL,
+		data TEXT NOT NULL, -- JSON
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	createWebhookDeliveriesTable := `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event_id INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending', -- pending, succeeded or failed
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		response_status INTEGER,
+		response_body TEXT,
+		error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		completed_at DATETIME,
+		FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE,
+		FOREIGN KEY (event_id) REFERENCES webhook_events (id) ON DELETE CASCADE
+	);`
+	execOrFatal(db, createWebhooksTable)
+	execOrFatal(db, createWebhookEventsTable)
+	execOrFatal(db, createWebhookDeliveriesTable)
+	execOrFatal(db, `CREATE INDEX IF NOT EXISTS idx_webhooks_group ON webhooks (group_id)`)
+	execOrFatal(db, `CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at)`)
+	execOrFatal(db, `CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id)`)
+	for _, trigger := range webhookTriggers {
+		execOrFatal(db, trigger)
+	}
+
 	log.Println("Database initialized successfully")
 }
 
@@ -209,54 +748,170 @@ func execOrFatal(db *sql.DB, sql string) {
 	}
 }
 
+// addColumnIfMissing lets databases created by an older build pick up new columns.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		log.Fatalf("Failed to inspect table %s: %v", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			log.Fatalf("Failed to inspect table %s: %v", table, err)
+		}
+		if name == column {
+			return
+		}
+	}
+	rows.Close()
+	execOrFatal(db, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+}
+
 func main() {
 	initDB()
 	defer db.Close()
+	startStreamHub()
+	initRateLimits()
+	initMailer()
+	initEventReminders()
+	startBackgroundJobs()
 
 	r := gin.Default()
+	// Client IPs key the rate limits, so X-Forwarded-For is only believed from
+	// the proxies named in TRUSTED_PROXIES (comma-separated IPs or CIDRs).
+	var trustedProxies []string
+	for _, p := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			trustedProxies = append(trustedProxies, p)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"POST", "GET", "OPTIONS", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Last-Event-ID"},
+		ExposeHeaders:    []string{"Content-Length", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"},
 		AllowCredentials: true,
 	}))
 
 	r.Static("/uploads", "./uploads")
 
 	// --- Public Routes ---
-	r.POST("/register", RegisterHandler)
-	r.POST("/login", LoginHandler)
+	r.GET("/stream", streamTokenFromQuery, AuthMiddleware(scopeStream), StreamHandler)
+	r.POST("/register", RateLimitByIP(registerLimiter), RegisterHandler)
+	r.POST("/login", RateLimitByIP(loginLimiter), LoginHandler)
+	r.POST("/login/2fa", RateLimitByIP(loginLimiter), LoginTwoFactorHandler)
+	r.GET("/auth/oidc/login", OIDCLoginHandler)
+	r.GET("/auth/oidc/callback", OIDCCallbackHandler)
+	r.GET("/profile/verify-email", VerifyEmailHandler)
+	r.GET("/unsubscribe", UnsubscribeConfirmHandler)
+	r.POST("/unsubscribe", UnsubscribeHandler)
 	r.GET("/seed-database", SeedDatabaseHandler)
 
+	// --- Two-Factor Enrollment (also reachable with an enrollment-only token) ---
+	twoFactor := r.Group("/profile/2fa")
+	twoFactor.Use(AuthMiddleware(scopeTwoFactorEnroll), RateLimitWrites())
+	{
+		twoFactor.GET("", GetTwoFactorStatusHandler)
+		twoFactor.POST("/setup", SetupTwoFactorHandler)
+		twoFactor.POST("/enable", EnableTwoFactorHandler)
+		twoFactor.POST("/disable", DisableTwoFactorHandler)
+		twoFactor.POST("/recovery-codes", RegenerateRecoveryCodesHandler)
+	}
+
 	// --- Protected Routes ---
 	protected := r.Group("/")
-	protected.Use(AuthMiddleware())
+	protected.Use(AuthMiddleware(), RateLimitWrites())
 	{
 		// Event
 		protected.GET("/events", GetEventsHandler) // Updated
 		protected.POST("/events", CreateEventHandler)
+		protected.PUT("/events/:id", UpdateEventHandler)
 		protected.POST("/events/:id/register", RegisterForEventHandler)
+		protected.DELETE("/events/:id/register", UnregisterFromEventHandler)
+		protected.POST("/events/:id/messages", MessageEventRegistrantsHandler)
 		protected.GET("/events/:id/volunteers", GetVolunteersForEventHandler)
+		protected.GET("/events/:id/suggested-volunteers", GetSuggestedVolunteersHandler)
+		protected.PUT("/events/:id/required-certifications", UpdateEventCertificationsHandler)
 		// Dashboard
 		protected.GET("/organizer/events", GetOrganizerEventsHandler) // Updated
 		protected.GET("/volunteer/events", GetVolunteerEventsHandler) // Updated
 		// Profile
 		protected.GET("/profile/me", GetMyProfileHandler)
+		protected.PUT("/profile/me", UpdateMyProfileHandler)
 		protected.GET("/profile/skills", GetSkillsHandler)
 		protected.POST("/profile/skills", UpdateSkillsHandler)
+		protected.GET("/skills", SearchSkillsHandler)
+		protected.POST("/skills", CreateSkillHandler)
+		protected.POST("/users/:id/skills/:skillId/verify", VerifySkillHandler)
+		protected.DELETE("/users/:id/skills/:skillId/verify", RevokeSkillVerificationHandler)
 		protected.POST("/profile/picture", UploadProfilePictureHandler)
+		protected.GET("/profile/certifications", GetMyCertificationsHandler)
+		protected.POST("/profile/certifications", UploadCertificationHandler)
+		protected.DELETE("/profile/certifications/:id", DeleteCertificationHandler)
+		protected.GET("/profile/availability", GetMyAvailabilityHandler)
+		protected.PUT("/profile/availability", UpdateMyAvailabilityHandler)
+		protected.GET("/profile/export", ExportMyDataHandler)
+		protected.GET("/profile/deletion", GetAccountDeletionHandler)
+		protected.POST("/profile/deletion", RequestAccountDeletionHandler)
+		protected.DELETE("/profile/deletion", CancelAccountDeletionHandler)
 		// Follows
 		protected.GET("/users", GetUsersHandler) // Updated
 		protected.GET("/users/following", GetFollowingHandler)
+		protected.GET("/users/follow-requests", GetFollowRequestsHandler)
+		protected.POST("/users/follow-requests/:id/approve", AcceptInvitationHandler)
+		protected.POST("/users/follow-requests/:id/deny", DeclineInvitationHandler)
 		protected.GET("/users/followers", GetFollowersHandler)
 		protected.POST("/users/follow/:id", FollowUserHandler)
 		protected.POST("/users/unfollow/:id", UnfollowUserHandler)
+		protected.GET("/users/blocked", GetBlockedUsersHandler)
+		protected.POST("/users/block/:id", BlockUserHandler)
+		protected.POST("/users/unblock/:id", UnblockUserHandler)
+		protected.GET("/users/muted", GetMutedUsersHandler)
+		protected.POST("/users/mute/:id", MuteUserHandler)
+		protected.POST("/users/unmute/:id", UnmuteUserHandler)
+		protected.GET("/users/:id", GetUserProfileHandler)
+		protected.GET("/users/:id/followers", GetUserFollowersHandler)
+		protected.GET("/users/:id/following", GetUserFollowingHandler)
+		// Certifications
+		protected.GET("/certification-types", GetCertificationTypesHandler)
+		protected.POST("/certification-types", CreateCertificationTypeHandler)
+		protected.GET("/certifications/pending", GetPendingCertificationsHandler)
+		protected.GET("/certifications/:id/document", DownloadCertificationDocumentHandler)
+		protected.POST("/certifications/:id/review", ReviewCertificationHandler)
+		// Privacy
+		protected.GET("/profile/privacy", GetPrivacySettingsHandler)
+		protected.PUT("/profile/privacy", UpdatePrivacySettingsHandler)
 		// Groups
 		protected.GET("/groups", GetGroupsHandler)
 		protected.POST("/groups", CreateGroupHandler)
 		protected.GET("/groups/:id", GetGroupDetailsHandler)
+		protected.PUT("/groups/:id", UpdateGroupHandler)
+		protected.DELETE("/groups/:id", DeleteGroupHandler)
+		protected.POST("/groups/:id/transfer-ownership", TransferGroupOwnershipHandler)
 		protected.POST("/groups/:id/leave", LeaveGroupHandler)
+		protected.PUT("/groups/:id/members/:userId/role", ChangeMemberRoleHandler)
+		protected.DELETE("/groups/:id/members/:userId", RemoveGroupMemberHandler)
+		protected.GET("/groups/:id/bans", GetGroupBansHandler)
+		protected.POST("/groups/:id/bans/:userId", BanGroupMemberHandler)
+		protected.DELETE("/groups/:id/bans/:userId", UnbanGroupMemberHandler)
+		protected.GET("/groups/:id/audit-log", GetGroupAuditLogHandler)
+		// Group Feed
+		protected.GET("/groups/:id/posts", GetGroupPostsHandler)
+		protected.POST("/groups/:id/posts", CreateGroupPostHandler)
+		protected.GET("/groups/:id/posts/:postId", GetGroupPostHandler)
+		protected.DELETE("/groups/:id/posts/:postId", DeleteGroupPostHandler)
+		protected.PUT("/groups/:id/posts/:postId/pin", PinGroupPostHandler)
+		protected.PUT("/groups/:id/posts/:postId/lock", LockGroupPostHandler)
+		protected.POST("/groups/:id/posts/:postId/comments", CreateGroupPostCommentHandler)
+		protected.DELETE("/groups/:id/posts/:postId/comments/:commentId", DeleteGroupPostCommentHandler)
+		protected.PUT("/groups/:id/posts/:postId/reactions/:reaction", AddGroupPostReactionHandler)
+		protected.DELETE("/groups/:id/posts/:postId/reactions/:reaction", RemoveGroupPostReactionHandler)
 		protected.GET("/profile/my-groups", GetMyGroupsHandler)
 		// Group Join Requests
 		protected.POST("/groups/:id/request-join", RequestJoinGroupHandler)
@@ -264,19 +919,63 @@ func main() {
 		protected.GET("/groups/:id/requests", GetJoinRequestsHandler)
 		protected.POST("/groups/:id/requests/approve", ApproveJoinRequestHandler)
 		protected.POST("/groups/:id/requests/deny", DenyJoinRequestHandler)
+		protected.GET("/groups/:id/questions", GetScreeningQuestionsHandler)
+		protected.PUT("/groups/:id/questions", UpdateScreeningQuestionsHandler)
 		// Invitation
 		protected.GET("/groups/:id/invitable-followers", GetInvitableFollowersHandler)
 		protected.POST("/groups/:id/invite", CreateGroupInvitationHandler)
+		protected.GET("/groups/:id/invite-links", GetGroupInviteLinksHandler)
+		protected.POST("/groups/:id/invite-links", CreateGroupInviteLinkHandler)
+		protected.DELETE("/groups/:id/invite-links/:linkId", RevokeGroupInviteLinkHandler)
+		protected.GET("/events/:id/invite-links", GetEventInviteLinksHandler)
+		protected.POST("/events/:id/invite-links", CreateEventInviteLinkHandler)
+		protected.DELETE("/events/:id/invite-links/:linkId", RevokeEventInviteLinkHandler)
+		protected.GET("/invite/:code", GetInviteLinkHandler)
+		protected.POST("/invite/:code", AcceptInviteLinkHandler)
+		protected.GET("/invitations/sent", GetSentInvitationsHandler)
+		protected.DELETE("/invitations/:id", RevokeInvitationHandler)
+		protected.POST("/invitations/:id/accept", AcceptInvitationHandler)
+		protected.POST("/invitations/:id/decline", DeclineInvitationHandler)
+		// Webhooks
+		protected.GET("/groups/:id/webhooks", GetGroupWebhooksHandler)
+		protected.POST("/groups/:id/webhooks", CreateGroupWebhookHandler)
+		protected.PUT("/groups/:id/webhooks/:webhookId", UpdateGroupWebhookHandler)
+		protected.DELETE("/groups/:id/webhooks/:webhookId", DeleteGroupWebhookHandler)
+		protected.POST("/groups/:id/webhooks/:webhookId/ping", PingGroupWebhookHandler)
+		protected.GET("/groups/:id/webhooks/:webhookId/deliveries", GetWebhookDeliveriesHandler)
+		protected.POST("/groups/:id/webhooks/:webhookId/deliveries/:deliveryId/redeliver", RedeliverWebhookHandler)
+		// Messages
+		protected.GET("/conversations", GetConversationsHandler)
+		protected.POST("/conversations", CreateConversationHandler)
+		protected.GET("/conversations/:id", GetConversationHandler)
+		protected.GET("/conversations/:id/messages", GetMessagesHandler)
+		protected.POST("/conversations/:id/messages", SendMessageHandler)
+		protected.POST("/conversations/:id/read", MarkConversationReadHandler)
+		protected.POST("/conversations/:id/leave", LeaveConversationHandler)
+		protected.GET("/messages/unread-count", GetUnreadMessageCountHandler)
+		// Notifications and the live stream
+		protected.POST("/stream/token", CreateStreamTokenHandler)
 		protected.GET("/notifications", GetNotificationsHandler)
+		protected.GET("/notifications/unread-count", GetUnreadNotificationCountHandler)
+		protected.GET("/notifications/preferences", GetNotificationSettingsHandler)
+		protected.PUT("/notifications/preferences", UpdateNotificationSettingsHandler)
+		protected.POST("/notifications/read-all", MarkAllNotificationsReadHandler)
+		protected.POST("/notifications/:id/read", MarkNotificationReadHandler)
 		protected.POST("/notifications/:id/accept", AcceptInvitationHandler)
 		protected.POST("/notifications/:id/decline", DeclineInvitationHandler)
+		// Admin
+		protected.GET("/admin/security", GetSecuritySettingsHandler)
+		protected.PUT("/admin/security", UpdateSecuritySettingsHandler)
 	}
 
 	r.Run(":8080")
 }
 
-// --- Middleware (Unchanged) ---
-func AuthMiddleware() gin.HandlerFunc {
+// --- Middleware ---
+
+// AuthMiddleware accepts full session tokens, plus tokens carrying one of the
+// given restricted scopes.
+func AuthMiddleware(allowedScopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -288,19 +987,93 @@ func AuthMiddleware() gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
 			return
 		}
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) { return jwtKey, nil })
-		if err != nil || !token.Valid {
+		claims, err := parseToken(tokenString)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+		if claims.Scope != "" && !containsString(allowedScopes, claims.Scope) {
+			if claims.Scope == scopeTwoFactorEnroll {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Two-factor setup required"})
+				return
+			}
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			return
 		}
 		c.Set("userID", claims.UserID)
 		c.Set("role", claims.Role)
+		c.Set("scope", claims.Scope)
 		c.Next()
 	}
 }
 
-// --- Auth Handlers (Unchanged) ---
+func parseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) { return jwtKey, nil })
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+// issueToken signs a token for the user. An empty scope creates a full session.
+func issueToken(userID int, role, scope string, ttl time.Duration) (string, error) {
+	claims := &Claims{UserID: userID, Role: role, Scope: scope, RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl))}}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtKey)
+}
+
+// getEnv reads a configuration value from the environment.
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// sqlTime formats t the way SQLite's CURRENT_TIMESTAMP does, so stored times compare as text.
+func sqlTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
+
+// --- Auth Handlers ---
 func RegisterHandler(c *gin.Context) {
 	var payload RegisterPayload
 	if err := c.ShouldBindJSON(&payload); err != nil {
@@ -311,6 +1084,11 @@ func RegisterHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input. Name, email, password, and role are required."})
 		return
 	}
+	// Admin accounts are provisioned directly in the database, never through sign-up.
+	if payload.Role != "Volunteer" && payload.Role != "Organizer" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be Volunteer or Organizer."})
+		return
+	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
@@ -334,12 +1112,18 @@ func LoginHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	account := loginAccountKey(creds.Email)
+	if rejectIfThrottled(c, account) {
+		return
+	}
 	var passwordHash string
 	var storedUser User
-	query := `SELECT id, role, password_hash FROM users WHERE email = ?`
-	err := db.QueryRow(query, creds.Email).Scan(&storedUser.ID, &storedUser.Role, &passwordHash)
+	var totpEnabled bool
+	query := `SELECT id, role, password_hash, totp_enabled FROM users WHERE email = ?`
+	err := db.QueryRow(query, creds.Email).Scan(&storedUser.ID, &storedUser.Role, &passwordHash, &totpEnabled)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			recordLoginFailure(account)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
 		}
@@ -348,18 +1132,17 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(creds.Password)); err != nil {
+		recordLoginFailure(account)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &Claims{UserID: storedUser.ID, Role: storedUser.Role, RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(expirationTime)}}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	clearLoginFailures(account)
+	result, err := loginResult(storedUser.ID, storedUser.Role, totpEnabled)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": tokenString, "role": storedUser.Role})
+	c.JSON(http.StatusOK, result)
 }
 
 // --- Event Handlers ---
@@ -367,6 +1150,7 @@ func LoginHandler(c *gin.Context) {
 // UPDATED: GetEventsHandler - New sorting logic
 func GetEventsHandler(c *gin.Context) {
 	myID := c.GetInt("userID")
+	role := c.GetString("role")
 
 	// 1. Get all users the current user follows
 	followingIDs := make(map[int]bool)
@@ -425,11 +1209,27 @@ func GetEventsHandler(c *gin.Context) {
 		}
 		followedNameRows.Close()
 	}
+	followedIDs := make([]int, 0, len(followingIDs))
+	for id := range followingIDs {
+		followedIDs = append(followedIDs, id)
+	}
+	privacy, err := newPrivacyContext(myID, role, followedIDs)
+	if err != nil {
+		log.Println("GetEvents/Privacy error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	muted, err := mutedUserIDs(myID)
+	if err != nil {
+		log.Println("GetEvents/Muted error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 
 	// 4. Get all events with new sorting
 	today := time.Now().Format("2006-01-02")
 	query := `
-		SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, 
+		SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, e.start_time, e.end_time, 
 		       e.created_by_user_id, u.email, u.name, u.profile_image_url,
 			   -- NEW: Priority column for sorting
 			   CASE 
@@ -442,10 +1242,10 @@ func GetEventsHandler(c *gin.Context) {
 			   END as priority
 		FROM events e
 		JOIN users u ON e.created_by_user_id = u.id
-		WHERE e.date >= ?
+		WHERE e.date >= ? AND ` + eventVisibleClause("e") + `
 		ORDER BY priority ASC, e.date ASC
 	`
-	rows, err := db.Query(query, myID, today)
+	rows, err := db.Query(query, myID, today, myID, myID, myID)
 	if err != nil {
 		log.Println("GetEvents error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -457,10 +1257,14 @@ func GetEventsHandler(c *gin.Context) {
 	for rows.Next() {
 		var e Event
 		var priority int // We scan priority but don't need to send it
-		if err := rows.Scan(&e.ID, &e.Name, &e.Date, &e.Description, &e.LocationAddress, &e.ImageURL, &e.CreatedBy, &e.CreatedByEmail, &e.CreatedByName, &e.OrganizerProfilePicture, &priority); err != nil {
+		if err := rows.Scan(&e.ID, &e.Name, &e.Date, &e.Description, &e.LocationAddress, &e.ImageURL, &e.StartTime, &e.EndTime, &e.CreatedBy, &e.CreatedByEmail, &e.CreatedByName, &e.OrganizerProfilePicture, &priority); err != nil {
 			log.Println("GetEvents scan error:", err)
 			continue
 		}
+		// Muted organizers' events stay out of the feed unless I'm already going.
+		if muted[e.CreatedBy] && !containsInt(eventRegistrations[e.ID], myID) {
+			continue
+		}
 
 		// 5. Calculate social context
 		registrants := eventRegistrations[e.ID]
@@ -468,7 +1272,7 @@ func GetEventsHandler(c *gin.Context) {
 		for _, userID := range registrants {
 			if userID == myID {
 				e.IsRegistered = true
-			} else if followingIDs[userID] {
+			} else if followingIDs[userID] && !muted[userID] && privacy.canSeeRegistrations(userID) {
 				e.FollowersGoingCount++
 				if len(e.FollowersGoing) < 3 {
 					e.FollowersGoing = append(e.FollowersGoing, followedUserDetails[userID])
@@ -477,15 +1281,48 @@ func GetEventsHandler(c *gin.Context) {
 		}
 		events = append(events, e)
 	}
+	if err := attachHostGroups(events); err != nil {
+		log.Println("GetEvents/HostGroups error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := attachRequiredCertifications(events); err != nil {
+		log.Println("GetEvents/Certifications error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := redactEvents(myID, role, events); err != nil {
+		log.Println("GetEvents/Redact error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"events": events})
 }
 func CreateEventHandler(c *gin.Context) {
 	userID := c.GetInt("userID")
 	role := c.GetString("role")
-	if role != "Organizer" {
+	// Group admins may host events for their group even without an organizer account.
+	hostGroupID, _ := strconv.Atoi(c.PostForm("hostGroupId"))
+	if hostGroupID != 0 {
+		groupRole, err := groupRole(hostGroupID, userID)
+		if err != nil {
+			log.Println("CreateEvent (host group) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if groupRole != "admin" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Only group admins can host events for the group"})
+			return
+		}
+	} else if role != "Organizer" {
 		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Only organizers can create events"})
 		return
 	}
+	membersOnly := c.PostForm("membersOnly") == "true"
+	if membersOnly && hostGroupID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only group-hosted events can be members-only"})
+		return
+	}
 	name := c.PostForm("name")
 	date := c.PostForm("date")
 	description := c.PostForm("description")
@@ -499,6 +1336,17 @@ func CreateEventHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot create an event in the past."})
 		return
 	}
+	startTime := c.PostForm("startTime")
+	endTime := c.PostForm("endTime")
+	if err := validateTimeRange(startTime, endTime); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	requiredCertifications, err := parseCertificationTypeIDs(c.PostFormArray("requiredCertifications"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	file, err := c.FormFile("image")
 	imageURL := ""
 	if err == nil {
@@ -511,28 +1359,65 @@ func CreateEventHandler(c *gin.Context) {
 		}
 		imageURL = "http://localhost:8080/uploads/" + filename
 	}
-	query := `INSERT INTO events (name, date, description, location_address, image_url, created_by_user_id) VALUES (?, ?, ?, ?, ?, ?)`
-	res, err := db.Exec(query, name, date, description, locationAddress, imageURL, userID)
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("CreateEvent (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
+		return
+	}
+	var host interface{}
+	if hostGroupID != 0 {
+		host = hostGroupID
+	}
+	query := `INSERT INTO events (name, date, start_time, end_time, description, location_address, image_url, created_by_user_id, host_group_id, members_only) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := tx.Exec(query, name, date, startTime, endTime, description, locationAddress, imageURL, userID, host, membersOnly)
 	if err != nil {
+		tx.Rollback()
 		log.Println("CreateEvent error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
 		return
 	}
 	newEventID, _ := res.LastInsertId()
+	if err := setRequiredCertifications(tx, int(newEventID), requiredCertifications); err != nil {
+		tx.Rollback()
+		log.Println("CreateEvent (certifications) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
+		return
+	}
+	if hostGroupID != 0 {
+		if err := notifyGroupOfEvent(tx, hostGroupID, int(newEventID), userID); err != nil {
+			tx.Rollback()
+			log.Println("CreateEvent (notify group) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("CreateEvent (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
+		return
+	}
 	var createdEvent Event
 	queryRow := `
-		SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, 
+		SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, e.start_time, e.end_time, 
 		       e.created_by_user_id, u.email, u.name, u.profile_image_url
 		FROM events e JOIN users u ON e.created_by_user_id = u.id
 		WHERE e.id = ?
 	`
-	err = db.QueryRow(queryRow, newEventID).Scan(&createdEvent.ID, &createdEvent.Name, &createdEvent.Date, &createdEvent.Description, &createdEvent.LocationAddress, &createdEvent.ImageURL, &createdEvent.CreatedBy, &createdEvent.CreatedByEmail, &createdEvent.CreatedByName, &createdEvent.OrganizerProfilePicture)
+	err = db.QueryRow(queryRow, newEventID).Scan(&createdEvent.ID, &createdEvent.Name, &createdEvent.Date, &createdEvent.Description, &createdEvent.LocationAddress, &createdEvent.ImageURL, &createdEvent.StartTime, &createdEvent.EndTime, &createdEvent.CreatedBy, &createdEvent.CreatedByEmail, &createdEvent.CreatedByName, &createdEvent.OrganizerProfilePicture)
 	if err != nil {
 		log.Println("CreateEvent/QueryRow error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve created event"})
 		return
 	}
-	c.JSON(http.StatusCreated, createdEvent)
+	created := []Event{createdEvent}
+	if err := attachHostGroups(created); err != nil {
+		log.Println("CreateEvent/HostGroups error:", err)
+	}
+	if err := attachRequiredCertifications(created); err != nil {
+		log.Println("CreateEvent/Certifications error:", err)
+	}
+	c.JSON(http.StatusCreated, created[0])
 }
 func RegisterForEventHandler(c *gin.Context) {
 	userID := c.GetInt("userID")
@@ -542,15 +1427,8 @@ func RegisterForEventHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
 		return
 	}
-	var eventDate string
-	err = db.QueryRow(`SELECT date FROM events WHERE id = ?`, eventID).Scan(&eventDate)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
-		return
-	}
-	today := time.Now().Format("2006-01-02")
-	if eventDate < today {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot register for an event in the past."})
+	if status, body := registrationBlocked(userID, eventID); status != 0 {
+		c.JSON(status, body)
 		return
 	}
 	query := `INSERT OR IGNORE INTO registrations (user_id, event_id) VALUES (?, ?)`
@@ -567,16 +1445,32 @@ func RegisterForEventHandler(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Registered successfully"})
 }
-func GetVolunteersForEventHandler(c *gin.Context) {
-	role := c.GetString("role")
-	if role != "Organizer" {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+
+// UnregisterFromEventHandler cancels the caller's registration.
+func UnregisterFromEventHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	eventID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
 		return
 	}
-	eventIDStr := c.Param("id")
-	eventID, err := strconv.Atoi(eventIDStr)
+	res, err := db.Exec(`DELETE FROM registrations WHERE user_id = ? AND event_id = ?`, userID, eventID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		log.Println("UnregisterFromEvent error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not registered for this event"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Registration cancelled"})
+}
+func GetVolunteersForEventHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	role := c.GetString("role")
+	eventID, ok := requireEventManager(c)
+	if !ok {
 		return
 	}
 	query := `
@@ -623,9 +1517,42 @@ func GetVolunteersForEventHandler(c *gin.Context) {
 				v.Skills = append(v.Skills, skill)
 			}
 		}
+		ids := make([]int, 0, len(volunteersMap))
+		for id := range volunteersMap {
+			ids = append(ids, id)
+		}
+		skillDetails, err := loadUserSkills(ids)
+		if err != nil {
+			log.Println("GetVolunteers skill details error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error fetching skills"})
+			return
+		}
+		for id, skills := range skillDetails {
+			volunteersMap[id].SkillDetails = skills
+		}
+		details, err := loadProfileDetails(ids)
+		if err != nil {
+			log.Println("GetVolunteers details error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error fetching profiles"})
+			return
+		}
+		for id, d := range details {
+			volunteersMap[id].ProfileDetails = *d
+		}
+	}
+	ids := make([]int, 0, len(volunteersMap))
+	for id := range volunteersMap {
+		ids = append(ids, id)
+	}
+	privacy, err := newPrivacyContext(myID, role, ids)
+	if err != nil {
+		log.Println("GetVolunteers privacy error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
 	}
 	volunteerList := make([]VolunteerInfo, 0, len(volunteersMap))
 	for _, v := range volunteersMap {
+		privacy.redactVolunteer(v)
 		volunteerList = append(volunteerList, *v)
 	}
 	c.JSON(http.StatusOK, gin.H{"volunteers": volunteerList})
@@ -636,14 +1563,16 @@ func GetOrganizerEventsHandler(c *gin.Context) {
 	userID := c.GetInt("userID")
 	today := time.Now().Format("2006-01-02")
 	query := `
-		SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, 
+		SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, e.start_time, e.end_time, 
 		       e.created_by_user_id, u.email, u.name, u.profile_image_url
 		FROM events e
 		JOIN users u ON e.created_by_user_id = u.id
-		WHERE e.created_by_user_id = ? AND e.date >= ?
+		WHERE (e.created_by_user_id = ? OR e.host_group_id IN (
+			SELECT group_id FROM group_members WHERE user_id = ? AND role = 'admin'
+		)) AND e.date >= ?
 		ORDER BY e.date ASC
 	`
-	rows, err := db.Query(query, userID, today)
+	rows, err := db.Query(query, userID, userID, today)
 	if err != nil {
 		log.Println("GetOrganizerEvents error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -653,19 +1582,34 @@ func GetOrganizerEventsHandler(c *gin.Context) {
 	events := []Event{}
 	for rows.Next() {
 		var e Event
-		if err := rows.Scan(&e.ID, &e.Name, &e.Date, &e.Description, &e.LocationAddress, &e.ImageURL, &e.CreatedBy, &e.CreatedByEmail, &e.CreatedByName, &e.OrganizerProfilePicture); err != nil {
+		if err := rows.Scan(&e.ID, &e.Name, &e.Date, &e.Description, &e.LocationAddress, &e.ImageURL, &e.StartTime, &e.EndTime, &e.CreatedBy, &e.CreatedByEmail, &e.CreatedByName, &e.OrganizerProfilePicture); err != nil {
 			log.Println("GetOrganizerEvents scan error:", err)
 			continue
 		}
 		events = append(events, e)
 	}
+	if err := attachHostGroups(events); err != nil {
+		log.Println("GetOrganizerEvents host groups error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := attachRequiredCertifications(events); err != nil {
+		log.Println("GetOrganizerEvents certifications error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := redactEvents(userID, c.GetString("role"), events); err != nil {
+		log.Println("GetOrganizerEvents redact error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"events": events})
 }
 func GetVolunteerEventsHandler(c *gin.Context) {
 	userID := c.GetInt("userID")
 	today := time.Now().Format("2006-01-02")
 	query := `
-		SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, 
+		SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, e.start_time, e.end_time, 
 		       e.created_by_user_id, u.email, u.name, u.profile_image_url
 		FROM events e
 		JOIN users u ON e.created_by_user_id = u.id
@@ -683,36 +1627,48 @@ func GetVolunteerEventsHandler(c *gin.Context) {
 	events := []Event{}
 	for rows.Next() {
 		var e Event
-		if err := rows.Scan(&e.ID, &e.Name, &e.Date, &e.Description, &e.LocationAddress, &e.ImageURL, &e.CreatedBy, &e.CreatedByEmail, &e.CreatedByName, &e.OrganizerProfilePicture); err != nil {
+		if err := rows.Scan(&e.ID, &e.Name, &e.Date, &e.Description, &e.LocationAddress, &e.ImageURL, &e.StartTime, &e.EndTime, &e.CreatedBy, &e.CreatedByEmail, &e.CreatedByName, &e.OrganizerProfilePicture); err != nil {
 			log.Println("GetVolunteerEvents scan error:", err)
 			continue
 		}
 		events = append(events, e)
 	}
+	if err := attachHostGroups(events); err != nil {
+		log.Println("GetVolunteerEvents host groups error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := attachRequiredCertifications(events); err != nil {
+		log.Println("GetVolunteerEvents certifications error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := redactEvents(userID, c.GetString("role"), events); err != nil {
+		log.Println("GetVolunteerEvents redact error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"events": events})
 }
 
-// --- Profile & Skills Handlers (Unchanged) ---
+// --- Profile & Skills Handlers ---
 func GetSkillsHandler(c *gin.Context) {
 	userID := c.GetInt("userID")
-	query := `SELECT skill FROM user_skills WHERE user_id = ?`
-	rows, err := db.Query(query, userID)
+	userSkills, err := loadUserSkills([]int{userID})
 	if err != nil {
 		log.Println("GetSkills error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	defer rows.Close()
 	var skills []string
-	for rows.Next() {
-		var skill string
-		if err := rows.Scan(&skill); err != nil {
-			continue
-		}
-		skills = append(skills, skill)
+	for _, us := range userSkills[userID] {
+		skills = append(skills, us.Name)
 	}
-	c.JSON(http.StatusOK, gin.H{"skills": skills})
+	c.JSON(http.StatusOK, gin.H{"skills": skills, "details": userSkills[userID]})
 }
+
+// UpdateSkillsHandler sets my skills from the catalog. Skills I keep retain their
+// verification; only the proficiency is updated.
 func UpdateSkillsHandler(c *gin.Context) {
 	userID := c.GetInt("userID")
 	var payload SkillsPayload
@@ -720,21 +1676,37 @@ func UpdateSkillsHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
 		return
 	}
+	skills, err := validateSkillSelections(payload.Skills)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		log.Println("UpdateSkills (tx begin) error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	_, err = tx.Exec(`DELETE FROM user_skills WHERE user_id = ?`, userID)
+	deleteQuery := `DELETE FROM user_skills WHERE user_id = ?`
+	keep := []interface{}{userID}
+	if len(skills) > 0 {
+		deleteQuery += ` AND (skill_id IS NULL OR skill_id NOT IN (?` + strings.Repeat(",?", len(skills)-1) + `))`
+		for _, skill := range skills {
+			keep = append(keep, skill.ID)
+		}
+	}
+	_, err = tx.Exec(deleteQuery, keep...)
 	if err != nil {
 		tx.Rollback()
 		log.Println("UpdateSkills (delete) error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	if len(payload.Skills) > 0 {
-		stmt, err := tx.Prepare(`INSERT INTO user_skills (user_id, skill) VALUES (?, ?)`)
+	if len(skills) > 0 {
+		stmt, err := tx.Prepare(`
+			INSERT INTO user_skills (user_id, skill, skill_id, proficiency) VALUES (?, ?, ?, ?)
+			ON CONFLICT(user_id, skill) DO UPDATE SET proficiency = excluded.proficiency
+		`)
 		if err != nil {
 			tx.Rollback()
 			log.Println("UpdateSkills (prepare) error:", err)
@@ -742,8 +1714,8 @@ func UpdateSkillsHandler(c *gin.Context) {
 			return
 		}
 		defer stmt.Close()
-		for _, skill := range payload.Skills {
-			_, err := stmt.Exec(userID, skill)
+		for _, skill := range skills {
+			_, err := stmt.Exec(userID, skill.Name, skill.ID, skill.Proficiency)
 			if err != nil {
 				tx.Rollback()
 				log.Println("UpdateSkills (insert) error:", err)
@@ -773,6 +1745,14 @@ func GetMyProfileHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	details, err := loadProfileDetails([]int{u.ID})
+	if err != nil {
+		log.Println("GetMyProfile (details) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	u.ProfileDetails = *details[u.ID]
+	db.QueryRow(`SELECT email FROM email_verifications WHERE user_id = ? AND expires_at > ?`, u.ID, sqlTime(time.Now())).Scan(&u.PendingEmail)
 	c.JSON(http.StatusOK, u)
 }
 func UploadProfilePictureHandler(c *gin.Context) {
@@ -805,6 +1785,7 @@ func UploadProfilePictureHandler(c *gin.Context) {
 // UPDATED: GetUsersHandler - New sorting logic
 func GetUsersHandler(c *gin.Context) {
 	myID := c.GetInt("userID")
+	role := c.GetString("role")
 	searchTerm := c.Query("search")
 
 	// Find my groups
@@ -823,32 +1804,49 @@ func GetUsersHandler(c *gin.Context) {
 	}
 	groupRows.Close()
 
+	// IN (NULL) matches nothing, which keeps the query valid for users with no groups.
+	groupPlaceholders := "NULL"
+	if len(myGroupIDs) > 0 {
+		groupPlaceholders = "?" + strings.Repeat(",?", len(myGroupIDs)-1)
+	}
 	var args []interface{}
 	query := `
 		SELECT 
 			u.id, u.name, u.email, u.role, u.profile_image_url,
 			CASE WHEN f.follower_id IS NOT NULL THEN 1 ELSE 0 END as isFollowed,
+			EXISTS (
+				SELECT 1 FROM invitations fr
+				WHERE fr.sender_id = ? AND fr.receiver_id = u.id AND fr.invite_type = 'follow' AND fr.status = 'pending'
+			) as followRequested,
 			-- Priority 1: In common groups. Priority 2: Everyone else.
 			CASE 
 				WHEN u.id IN (
-					SELECT user_id FROM group_members WHERE group_id IN (?` + strings.Repeat(",?", len(myGroupIDs)-1) + `) AND user_id != ?
+					SELECT user_id FROM group_members WHERE group_id IN (` + groupPlaceholders + `) AND user_id != ?
 				) THEN 1
 				ELSE 2
 			END as priority
 		FROM users u
 		LEFT JOIN follows f ON u.id = f.follower_id AND f.following_id = ?
+		LEFT JOIN user_privacy p ON p.user_id = u.id
 		WHERE u.id != ? 
+		AND u.deleted_at IS NULL
 		AND u.id NOT IN (
 			SELECT following_id FROM follows WHERE follower_id = ?
 		)
+		AND ` + notBlockedClause("u.id") + `
 	`
+	args = append(args, myID)
 	args = append(args, myGroupIDs...)
-	args = append(args, myID, myID, myID, myID)
+	args = append(args, myID, myID, myID, myID, myID, myID)
 
 	if searchTerm != "" {
-		query += " AND (u.name LIKE ? OR u.email LIKE ?)"
+		// Only match on email where the owner shows it publicly, or search would reveal hidden addresses.
+		query += " AND (u.name LIKE ? OR (u.email LIKE ? AND COALESCE(p.email_visibility, ?) = ?))"
 		likeTerm := "%" + searchTerm + "%"
-		args = append(args, likeTerm, likeTerm)
+		args = append(args, likeTerm, likeTerm, defaultPrivacySettings.EmailVisibility, audienceEveryone)
+	} else {
+		// Users who opted out of discovery can still be found by name, just not suggested.
+		query += " AND COALESCE(p.discoverable, 1) = 1"
 	}
 
 	query += " ORDER BY priority ASC, u.name ASC"
@@ -865,12 +1863,17 @@ func GetUsersHandler(c *gin.Context) {
 	for rows.Next() {
 		var u User
 		var priority int
-		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.ProfileImageURL, &u.IsFollowed, &priority); err != nil {
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.ProfileImageURL, &u.IsFollowed, &u.FollowRequested, &priority); err != nil {
 			log.Println("GetUsers scan error:", err)
 			continue
 		}
 		allUsers = append(allUsers, u)
 	}
+	if err := redactUsers(myID, role, allUsers); err != nil {
+		log.Println("GetUsers redact error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"users": allUsers})
 }
 func GetFollowingHandler(c *gin.Context) {
@@ -897,6 +1900,11 @@ func GetFollowingHandler(c *gin.Context) {
 		}
 		myFollowing = append(myFollowing, u)
 	}
+	if err := redactUsers(myID, c.GetString("role"), myFollowing); err != nil {
+		log.Println("GetFollowing redact error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"users": myFollowing})
 }
 func GetFollowersHandler(c *gin.Context) {
@@ -923,6 +1931,11 @@ func GetFollowersHandler(c *gin.Context) {
 		}
 		myFollowers = append(myFollowers, u)
 	}
+	if err := redactUsers(myID, c.GetString("role"), myFollowers); err != nil {
+		log.Println("GetFollowers redact error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"users": myFollowers})
 }
 func FollowUserHandler(c *gin.Context) {
@@ -937,13 +1950,40 @@ func FollowUserHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot follow yourself"})
 		return
 	}
+	var exists int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ?`, followID).Scan(&exists); err != nil || exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if isBlockedEitherWay(myID, followID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot follow this user"})
+		return
+	}
+	if isPrivateAccount(followID) {
+		var alreadyFollowing int
+		db.QueryRow(`SELECT COUNT(*) FROM follows WHERE follower_id = ? AND following_id = ?`, myID, followID).Scan(&alreadyFollowing)
+		if alreadyFollowing == 0 {
+			if err := createFollowRequest(myID, followID); err != nil {
+				log.Println("FollowUser (request) error:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			c.JSON(http.StatusAccepted, gin.H{"message": "Follow request sent", "requested": true})
+			return
+		}
+	}
 	query := `INSERT OR IGNORE INTO follows (follower_id, following_id) VALUES (?, ?)`
-	_, err = db.Exec(query, myID, followID)
+	res, err := db.Exec(query, myID, followID)
 	if err != nil {
 		log.Println("FollowUser error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		if err := notify(db, followID, notifNewFollower, myID, nil); err != nil {
+			log.Println("FollowUser (notify) error:", err)
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "User followed successfully"})
 }
 func UnfollowUserHandler(c *gin.Context) {
@@ -961,10 +2001,17 @@ func UnfollowUserHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	// Unfollowing also withdraws a follow request that is still waiting.
+	_, err = db.Exec(`DELETE FROM invitations WHERE sender_id = ? AND receiver_id = ? AND invite_type = 'follow' AND status = 'pending'`, myID, unfollowID)
+	if err != nil {
+		log.Println("UnfollowUser (request) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "User unfollowed successfully"})
 }
 
-// --- Group Handlers (Unchanged) ---
+// --- Group Handlers ---
 func CreateGroupHandler(c *gin.Context) {
 	userID := c.GetInt("userID")
 	name := c.PostForm("name")
@@ -973,6 +2020,11 @@ func CreateGroupHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and description are required."})
 		return
 	}
+	privacy := c.DefaultPostForm("privacy", groupClosed)
+	if !validGroupPrivacy(privacy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Privacy must be open, closed or secret"})
+		return
+	}
 	file, err := c.FormFile("image")
 	imageURL := ""
 	if err == nil {
@@ -993,8 +2045,8 @@ func CreateGroupHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	query := `INSERT INTO groups (name, description, profile_image_url, created_by_user_id) VALUES (?, ?, ?, ?)`
-	res, err := tx.Exec(query, name, description, imageURL, userID)
+	query := `INSERT INTO groups (name, description, profile_image_url, created_by_user_id, privacy) VALUES (?, ?, ?, ?, ?)`
+	res, err := tx.Exec(query, name, description, imageURL, userID, privacy)
 	if err != nil {
 		tx.Rollback()
 		log.Println("CreateGroup (insert) error:", err)
@@ -1015,7 +2067,7 @@ func CreateGroupHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	c.JSON(http.StatusCreated, gin.H{"id": newGroupID, "name": name})
+	c.JSON(http.StatusCreated, gin.H{"id": newGroupID, "name": name, "privacy": privacy})
 }
 func GetGroupsHandler(c *gin.Context) {
 	userID := c.GetInt("userID")
@@ -1023,13 +2075,15 @@ func GetGroupsHandler(c *gin.Context) {
 	var args []interface{}
 	args = append(args, userID)
 	query := `
-		SELECT g.id, g.name, g.description, g.profile_image_url, g.created_by_user_id,
+		SELECT g.id, g.name, g.description, g.profile_image_url, g.created_by_user_id, g.privacy,
 		       (SELECT COUNT(*) FROM group_members gm WHERE gm.group_id = g.id) as memberCount,
 		       (SELECT 1 FROM group_members gm WHERE gm.group_id = g.id AND gm.user_id = ?) as isMember
 		FROM groups g
+		WHERE (g.privacy != 'secret' OR g.id IN ( SELECT group_id FROM group_members WHERE user_id = ? ))
 	`
+	args = append(args, userID)
 	if searchTerm != "" {
-		query += " WHERE g.name LIKE ?"
+		query += " AND g.name LIKE ?"
 		args = append(args, "%"+searchTerm+"%")
 	}
 	rows, err := db.Query(query, args...)
@@ -1043,7 +2097,7 @@ func GetGroupsHandler(c *gin.Context) {
 	for rows.Next() {
 		var g Group
 		var isMember sql.NullBool
-		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.ProfileImageURL, &g.CreatedByUserID, &g.MemberCount, &isMember); err != nil {
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.ProfileImageURL, &g.CreatedByUserID, &g.Privacy, &g.MemberCount, &isMember); err != nil {
 			log.Println("GetGroups scan error:", err)
 			continue
 		}
@@ -1061,15 +2115,35 @@ func GetGroupDetailsHandler(c *gin.Context) {
 		return
 	}
 	var g GroupDetails
-	query := `SELECT id, name, description, profile_image_url, created_by_user_id FROM groups WHERE id = ?`
-	err = db.QueryRow(query, groupID).Scan(&g.ID, &g.Name, &g.Description, &g.ProfileImageURL, &g.CreatedByUserID)
+	query := `SELECT id, name, description, profile_image_url, created_by_user_id, privacy FROM groups WHERE id = ?`
+	err = db.QueryRow(query, groupID).Scan(&g.ID, &g.Name, &g.Description, &g.ProfileImageURL, &g.CreatedByUserID, &g.Privacy)
 	if err != nil {
 		log.Println("GetGroupDetails (info) error:", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
 		return
 	}
+	var userRole sql.NullString
+	err = db.QueryRow(`SELECT role FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, userID).Scan(&userRole)
+	if err == nil {
+		g.IsMember = true
+		g.IsAdmin = (userRole.String == "admin")
+		g.MyRole = userRole.String
+	}
+	// Secret groups don't exist as far as outsiders can tell. Someone holding an
+	// invitation sees the name and description, but not who is in it.
+	if g.Privacy == groupSecret && !g.IsMember {
+		if !hasPendingGroupInvitation(groupID, userID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+			return
+		}
+		g.Members = []User{}
+		g.MemberRoles = map[int]string{}
+		g.Events = []Event{}
+		c.JSON(http.StatusOK, g)
+		return
+	}
 	memberQuery := `
-		SELECT u.id, u.name, u.email, u.role, u.profile_image_url
+		SELECT u.id, u.name, u.email, u.role, u.profile_image_url, gm.role
 		FROM users u
 		JOIN group_members gm ON u.id = gm.user_id
 		WHERE gm.group_id = ?
@@ -1082,19 +2156,30 @@ func GetGroupDetailsHandler(c *gin.Context) {
 	}
 	defer rows.Close()
 	g.Members = []User{}
+	g.MemberRoles = make(map[int]string)
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.ProfileImageURL); err != nil {
+		var memberRole string
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.ProfileImageURL, &memberRole); err != nil {
 			log.Println("GetGroupDetails (scan member) error:", err)
 			continue
 		}
 		g.Members = append(g.Members, u)
+		g.MemberRoles[u.ID] = memberRole
 	}
-	var userRole sql.NullString
-	err = db.QueryRow(`SELECT role FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, userID).Scan(&userRole)
+	if err := redactUsers(userID, c.GetString("role"), g.Members); err != nil {
+		log.Println("GetGroupDetails (redact) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	g.Events, err = queryGroupEvents(groupID, userID)
 	if err == nil {
-		g.IsMember = true
-		g.IsAdmin = (userRole.String == "admin")
+		err = redactEvents(userID, c.GetString("role"), g.Events)
+	}
+	if err != nil {
+		log.Println("GetGroupDetails (events) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
 	}
 	var requestCount int
 	err = db.QueryRow(`SELECT COUNT(*) FROM group_join_requests WHERE group_id = ? AND user_id = ?`, groupID, userID).Scan(&requestCount)
@@ -1112,33 +2197,95 @@ func LeaveGroupHandler(c *gin.Context) {
 		return
 	}
 	var role string
-	var memberCount int
 	err = db.QueryRow(`SELECT role FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, userID).Scan(&role)
 	if err != nil {
 		log.Println("LeaveGroup (check role) error:", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "You are not a member of this group"})
 		return
 	}
-	if role == "admin" {
-		err = db.QueryRow(`SELECT COUNT(*) FROM group_members WHERE group_id = ? AND role = 'admin'`, groupID).Scan(&memberCount)
-		if err == nil && memberCount <= 1 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "You are the last admin. Cannot leave group. Delete the group instead."})
+	// The last admin has to hand the group over (or delete it) before leaving.
+	var payload struct {
+		SuccessorID int `json:"successorId"`
+	}
+	c.ShouldBindJSON(&payload)
+	var adminCount, ownerID int
+	db.QueryRow(`SELECT COUNT(*) FROM group_members WHERE group_id = ? AND role = 'admin'`, groupID).Scan(&adminCount)
+	db.QueryRow(`SELECT created_by_user_id FROM groups WHERE id = ?`, groupID).Scan(&ownerID)
+	successorID := payload.SuccessorID
+	if role == "admin" && adminCount <= 1 && successorID == 0 {
+		candidates, err := queryUsers(`
+			SELECT u.id, u.name, u.email, u.role, u.profile_image_url
+			FROM users u JOIN group_members gm ON gm.user_id = u.id
+			WHERE gm.group_id = ? AND u.id != ? AND u.deleted_at IS NULL
+			ORDER BY gm.rowid
+		`, groupID, userID)
+		if err != nil {
+			log.Println("LeaveGroup (candidates) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if err := redactUsers(userID, c.GetString("role"), candidates); err != nil {
+			log.Println("LeaveGroup (privacy) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if len(candidates) == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "You are the only member. Delete the group instead.", "handoverRequired": true, "candidates": candidates})
 			return
 		}
+		c.JSON(http.StatusConflict, gin.H{"error": "You are the last admin. Choose a member to take over by sending successorId.", "handoverRequired": true, "candidates": candidates})
+		return
 	}
-	query := `DELETE FROM group_members WHERE group_id = ? AND user_id = ?`
-	_, err = db.Exec(query, groupID, userID)
+	if successorID == 0 && ownerID == userID {
+		// Ownership passes to another admin, earliest to join first.
+		db.QueryRow(`SELECT user_id FROM group_members WHERE group_id = ? AND role = 'admin' AND user_id != ? ORDER BY rowid LIMIT 1`, groupID, userID).Scan(&successorID)
+	}
+	if successorID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Choose another member to take over"})
+		return
+	}
+	tx, err := db.Begin()
 	if err != nil {
+		log.Println("LeaveGroup (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if successorID != 0 && role == "admin" {
+		if err := handOverGroup(tx, groupID, userID, successorID); err != nil {
+			tx.Rollback()
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "The successor must be a member of the group"})
+				return
+			}
+			log.Println("LeaveGroup (handover) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if err := logGroupAction(tx, groupID, userID, auditAdminHandover, successorID, ""); err != nil {
+			tx.Rollback()
+			log.Println("LeaveGroup (audit) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	query := `DELETE FROM group_members WHERE group_id = ? AND user_id = ?`
+	if _, err := tx.Exec(query, groupID, userID); err != nil {
+		tx.Rollback()
 		log.Println("LeaveGroup error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		log.Println("LeaveGroup (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Left group successfully"})
 }
 func GetMyGroupsHandler(c *gin.Context) {
 	userID := c.GetInt("userID")
 	query := `
-		SELECT g.id, g.name, g.description, g.profile_image_url, g.created_by_user_id,
+		SELECT g.id, g.name, g.description, g.profile_image_url, g.created_by_user_id, g.privacy,
 		       (SELECT COUNT(*) FROM group_members gm WHERE gm.group_id = g.id) as memberCount
 		FROM groups g
 		JOIN group_members gm ON g.id = gm.group_id
@@ -1155,7 +2302,7 @@ func GetMyGroupsHandler(c *gin.Context) {
 	for rows.Next() {
 		var g Group
 		g.IsMember = true
-		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.ProfileImageURL, &g.CreatedByUserID, &g.MemberCount); err != nil {
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.ProfileImageURL, &g.CreatedByUserID, &g.Privacy, &g.MemberCount); err != nil {
 			log.Println("GetMyGroups scan error:", err)
 			continue
 		}
@@ -1164,7 +2311,7 @@ func GetMyGroupsHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"groups": groups})
 }
 
-// --- Group Join Request Handlers (Unchanged) ---
+// --- Group Join Request Handlers ---
 func RequestJoinGroupHandler(c *gin.Context) {
 	userID := c.GetInt("userID")
 	groupIDStr := c.Param("id")
@@ -1173,13 +2320,73 @@ func RequestJoinGroupHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
 		return
 	}
-	query := `INSERT OR IGNORE INTO group_join_requests (group_id, user_id) VALUES (?, ?)`
-	_, err = db.Exec(query, groupID, userID)
+	var privacy string
+	err = db.QueryRow(`SELECT privacy FROM groups WHERE id = ?`, groupID).Scan(&privacy)
+	if err != nil || (privacy == groupSecret && !hasPendingGroupInvitation(groupID, userID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+	if isBannedFromGroup(groupID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You have been banned from this group"})
+		return
+	}
+	switch privacy {
+	case groupSecret:
+		c.JSON(http.StatusForbidden, gin.H{"error": "This group is invite-only. Accept your invitation to join."})
+		return
+	case groupOpen:
+		_, err = db.Exec(`INSERT OR IGNORE INTO group_members (group_id, user_id, role) VALUES (?, ?, ?)`, groupID, userID, "member")
+		if err != nil {
+			log.Println("RequestJoinGroup (join) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Joined group", "joined": true})
+		return
+	}
+	// Closed groups may ask screening questions; the body is optional otherwise.
+	var payload struct {
+		Answers []JoinAnswer `json:"answers"`
+	}
+	c.ShouldBindJSON(&payload)
+	answers, err := screenApplicant(groupID, userID, payload.Answers)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
 	if err != nil {
+		log.Println("RequestJoinGroup (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	query := `INSERT OR IGNORE INTO group_join_requests (group_id, user_id) VALUES (?, ?)`
+	if _, err := tx.Exec(query, groupID, userID); err != nil {
+		tx.Rollback()
 		log.Println("RequestJoinGroup error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	if _, err := tx.Exec(clearJoinAnswersSQL, groupID, userID); err != nil {
+		tx.Rollback()
+		log.Println("RequestJoinGroup (clear answers) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for _, a := range answers {
+		_, err := tx.Exec(`INSERT INTO group_join_answers (group_id, user_id, question_id, answer) VALUES (?, ?, ?, ?)`, groupID, userID, a.QuestionID, a.Answer)
+		if err != nil {
+			tx.Rollback()
+			log.Println("RequestJoinGroup (answers) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("RequestJoinGroup (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Join request sent"})
 }
 func CancelJoinRequestHandler(c *gin.Context) {
@@ -1192,6 +2399,9 @@ func CancelJoinRequestHandler(c *gin.Context) {
 	}
 	query := `DELETE FROM group_join_requests WHERE group_id = ? AND user_id = ?`
 	_, err = db.Exec(query, groupID, userID)
+	if err == nil {
+		_, err = db.Exec(clearJoinAnswersSQL, groupID, userID)
+	}
 	if err != nil {
 		log.Println("CancelJoinRequest error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -1209,8 +2419,8 @@ func GetJoinRequestsHandler(c *gin.Context) {
 	}
 	var userRole string
 	err = db.QueryRow(`SELECT role FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, userID).Scan(&userRole)
-	if err != nil || userRole != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not an admin of this group"})
+	if err != nil || !canModerateGroup(userRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not an admin or moderator of this group"})
 		return
 	}
 	query := `
@@ -1226,14 +2436,32 @@ func GetJoinRequestsHandler(c *gin.Context) {
 		return
 	}
 	defer rows.Close()
-	requests := []User{}
+	applicants := []User{}
 	for rows.Next() {
 		var u User
 		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.ProfileImageURL); err != nil {
 			log.Println("GetJoinRequests scan error:", err)
 			continue
 		}
-		requests = append(requests, u)
+		applicants = append(applicants, u)
+	}
+	if err := redactUsers(userID, c.GetString("role"), applicants); err != nil {
+		log.Println("GetJoinRequests redact error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	answers, err := loadJoinAnswers(groupID)
+	if err != nil {
+		log.Println("GetJoinRequests (answers) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	requests := make([]JoinRequest, len(applicants))
+	for i, u := range applicants {
+		requests[i] = JoinRequest{User: u, Answers: answers[u.ID]}
+		if requests[i].Answers == nil {
+			requests[i].Answers = []JoinAnswer{}
+		}
 	}
 	c.JSON(http.StatusOK, gin.H{"requests": requests})
 }
@@ -1254,8 +2482,12 @@ func ApproveJoinRequestHandler(c *gin.Context) {
 	}
 	var myRole string
 	err = db.QueryRow(`SELECT role FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, myUserID).Scan(&myRole)
-	if err != nil || myRole != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not an admin of this group"})
+	if err != nil || !canModerateGroup(myRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not an admin or moderator of this group"})
+		return
+	}
+	if isBannedFromGroup(groupID, payload.UserID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This user is banned from the group"})
 		return
 	}
 	tx, err := db.Begin()
@@ -1264,13 +2496,24 @@ func ApproveJoinRequestHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	_, err = tx.Exec(`DELETE FROM group_join_requests WHERE group_id = ? AND user_id = ?`, groupID, payload.UserID)
+	res, err := tx.Exec(`DELETE FROM group_join_requests WHERE group_id = ? AND user_id = ?`, groupID, payload.UserID)
 	if err != nil {
 		tx.Rollback()
 		log.Println("ApproveJoin (delete) error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Join request not found"})
+		return
+	}
+	if _, err := tx.Exec(clearJoinAnswersSQL, groupID, payload.UserID); err != nil {
+		tx.Rollback()
+		log.Println("ApproveJoin (clear answers) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	_, err = tx.Exec(`INSERT OR IGNORE INTO group_members (group_id, user_id, role) VALUES (?, ?, ?)`, groupID, payload.UserID, "member")
 	if err != nil {
 		tx.Rollback()
@@ -1278,6 +2521,16 @@ func ApproveJoinRequestHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	err = logGroupAction(tx, groupID, myUserID, auditJoinApproved, payload.UserID, "")
+	if err == nil {
+		err = notify(tx, payload.UserID, notifJoinApproved, myUserID, gin.H{"groupId": groupID})
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("ApproveJoin (audit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	if err := tx.Commit(); err != nil {
 		log.Println("ApproveJoin (commit) error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -1294,31 +2547,72 @@ func DenyJoinRequestHandler(c *gin.Context) {
 		return
 	}
 	var payload struct {
-		UserID int `json:"userId"`
+		UserID  int    `json:"userId"`
+		Message string `json:"message"` // optional, shown to the applicant
 	}
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID in request"})
 		return
 	}
+	payload.Message = strings.TrimSpace(payload.Message)
 	var myRole string
 	err = db.QueryRow(`SELECT role FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, myUserID).Scan(&myRole)
-	if err != nil || myRole != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not an admin of this group"})
+	if err != nil || !canModerateGroup(myRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not an admin or moderator of this group"})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("DenyJoin (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 	query := `DELETE FROM group_join_requests WHERE group_id = ? AND user_id = ?`
-	_, err = db.Exec(query, groupID, payload.UserID)
+	res, err := tx.Exec(query, groupID, payload.UserID)
 	if err != nil {
+		tx.Rollback()
 		log.Println("DenyJoin error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Join request not found"})
+		return
+	}
+	_, err = tx.Exec(clearJoinAnswersSQL, groupID, payload.UserID)
+	if err == nil {
+		// The applicant hears back through their notifications.
+		err = notify(tx, payload.UserID, notifJoinDenied, myUserID, gin.H{"groupId": groupID, "message": payload.Message})
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("DenyJoin (notify) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := logGroupAction(tx, groupID, myUserID, auditJoinDenied, payload.UserID, payload.Message); err != nil {
+		tx.Rollback()
+		log.Println("DenyJoin (audit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("DenyJoin (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "User request denied"})
 }
 
-// --- Invitation Handlers (Unchanged) ---
+// --- Invitation Handlers ---
+// GetInvitableFollowersHandler lists the people I follow who could be invited to
+// the group, leaving out undiscoverable users. Each comes with their
+// availability where their privacy settings allow; ?eventId= also rates it
+// against an event I can see.
 func GetInvitableFollowersHandler(c *gin.Context) {
 	myID := c.GetInt("userID")
+	role := c.GetString("role")
 	groupIDStr := c.Param("id")
 	groupID, err := strconv.Atoi(groupIDStr)
 	if err != nil {
@@ -1329,12 +2623,14 @@ func GetInvitableFollowersHandler(c *gin.Context) {
 		SELECT u.id, u.name, u.email, u.profile_image_url
 		FROM users u
 		JOIN follows f ON u.id = f.following_id
-		WHERE f.follower_id = ?
+		LEFT JOIN user_privacy p ON p.user_id = u.id
+		WHERE f.follower_id = ? AND COALESCE(p.discoverable, 1) = 1
 		AND u.id NOT IN ( SELECT user_id FROM group_members WHERE group_id = ? )
 		AND u.id NOT IN ( SELECT user_id FROM group_join_requests WHERE group_id = ? )
-		AND u.id NOT IN ( SELECT receiver_id FROM invitations WHERE reference_id = ? AND status = 'pending' )
+		AND u.id NOT IN ( SELECT receiver_id FROM invitations WHERE invite_type = 'group' AND reference_id = ? AND status = 'pending' AND ` + invitationLiveClause("invitations") + ` )
+		AND ` + notBlockedClause("u.id") + `
 	`
-	rows, err := db.Query(query, myID, groupID, groupID, groupID)
+	rows, err := db.Query(query, myID, groupID, groupID, groupID, myID, myID)
 	if err != nil {
 		log.Println("GetInvitableFollowers error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -1350,7 +2646,45 @@ func GetInvitableFollowersHandler(c *gin.Context) {
 		}
 		users = append(users, u)
 	}
-	c.JSON(http.StatusOK, gin.H{"users": users})
+	var eventDate, eventStart, eventEnd string
+	if eventID, err := strconv.Atoi(c.Query("eventId")); err == nil {
+		err = db.QueryRow(`SELECT e.date, e.start_time, e.end_time FROM events e WHERE e.id = ? AND `+eventVisibleClause("e"), eventID, myID, myID, myID).
+			Scan(&eventDate, &eventStart, &eventEnd)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+			return
+		}
+	}
+	ids := make([]int, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	privacy, err := newPrivacyContext(myID, role, ids)
+	if err != nil {
+		log.Println("GetInvitableFollowers privacy error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	availability, err := loadAvailability(ids, false)
+	if err != nil {
+		log.Println("GetInvitableFollowers availability error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	followers := make([]AvailableUser, len(users))
+	for i, u := range users {
+		privacy.redactUser(&u)
+		followers[i] = AvailableUser{User: u}
+		if !privacy.canSeeAvailability(u.ID) {
+			continue
+		}
+		a := availability[u.ID]
+		if eventDate != "" {
+			a.Status = a.statusAt(eventDate, eventStart, eventEnd)
+		}
+		followers[i].Availability = a
+	}
+	c.JSON(http.StatusOK, gin.H{"users": followers})
 }
 func CreateGroupInvitationHandler(c *gin.Context) {
 	myID := c.GetInt("userID")
@@ -1361,7 +2695,8 @@ func CreateGroupInvitationHandler(c *gin.Context) {
 		return
 	}
 	var payload struct {
-		ReceiverID int `json:"receiverId"`
+		ReceiverID    int `json:"receiverId"`
+		ExpiresInDays int `json:"expiresInDays"` // optional, defaults to INVITATION_TTL
 	}
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid receiver ID"})
@@ -1373,71 +2708,50 @@ func CreateGroupInvitationHandler(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this group"})
 		return
 	}
-	query := `
-		INSERT INTO invitations (sender_id, receiver_id, invite_type, reference_id, status) 
-		VALUES (?, ?, 'group', ?, 'pending')
-	`
-	_, err = db.Exec(query, myID, payload.ReceiverID, groupID)
-	if err != nil {
-		log.Println("CreateGroupInvitation error:", err)
+	var exists int
+	if db.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ? AND deleted_at IS NULL`, payload.ReceiverID).Scan(&exists); exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if isBlockedEitherWay(myID, payload.ReceiverID) || isBannedFromGroup(groupID, payload.ReceiverID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot invite this user"})
+		return
+	}
+	if role, _ := groupRole(groupID, payload.ReceiverID); role != "" || hasPendingGroupInvitation(groupID, payload.ReceiverID) {
 		c.JSON(http.StatusConflict, gin.H{"error": "Invitation already sent or user is already a member"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Invitation sent"})
-}
-func GetNotificationsHandler(c *gin.Context) {
-	myID := c.GetInt("userID")
 	query := `
-		SELECT i.id, i.invite_type, i.status, i.created_at, i.reference_id,
-		       s.id, s.name, s.email, s.profile_image_url
-		FROM invitations i
-		JOIN users s ON i.sender_id = s.id
-		WHERE i.receiver_id = ? AND i.status = 'pending'
-		ORDER BY i.created_at DESC
+		INSERT INTO invitations (sender_id, receiver_id, invite_type, reference_id, status, expires_at) 
+		VALUES (?, ?, 'group', ?, 'pending', ?)
 	`
-	rows, err := db.Query(query, myID)
+	res, err := db.Exec(query, myID, payload.ReceiverID, groupID, invitationExpiry(payload.ExpiresInDays))
 	if err != nil {
-		log.Println("GetNotifications error:", err)
+		log.Println("CreateGroupInvitation error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	defer rows.Close()
-	notifications := []Invitation{}
-	for rows.Next() {
-		var inv Invitation
-		var groupID sql.NullInt64
-		if err := rows.Scan(&inv.ID, &inv.InviteType, &inv.Status, &inv.CreatedAt, &groupID, &inv.Sender.ID, &inv.Sender.Name, &inv.Sender.Email, &inv.Sender.ProfileImageURL); err != nil {
-			log.Println("GetNotifications scan error:", err)
-			continue
-		}
-		if inv.InviteType == "group" && groupID.Valid {
-			var g Group
-			gQuery := `
-				SELECT id, name, description, profile_image_url 
-				FROM groups WHERE id = ?
-			`
-			err = db.QueryRow(gQuery, groupID.Int64).Scan(&g.ID, &g.Name, &g.Description, &g.ProfileImageURL)
-			if err == nil {
-				inv.Group = &g
-			}
-		}
-		notifications = append(notifications, inv)
+	invitationID, _ := res.LastInsertId()
+	if err := notifyInvitations(db, `i.id = ?`, invitationID); err != nil {
+		log.Println("CreateGroupInvitation (notify) error:", err)
 	}
-	c.JSON(http.StatusOK, gin.H{"notifications": notifications})
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation sent"})
 }
 func AcceptInvitationHandler(c *gin.Context) {
 	myID := c.GetInt("userID")
-	notifIDStr := c.Param("id")
-	notifID, err := strconv.Atoi(notifIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+	notifID, ok := invitationParam(c)
+	if !ok {
 		return
 	}
 	var inviteType string
 	var refID int
-	var receiverID int
-	query := `SELECT invite_type, reference_id, receiver_id FROM invitations WHERE id = ? AND status = 'pending'`
-	err = db.QueryRow(query, notifID).Scan(&inviteType, &refID, &receiverID)
+	var senderID, receiverID int
+	var expired bool
+	query := `
+		SELECT invite_type, reference_id, sender_id, receiver_id, NOT ` + invitationLiveClause("invitations") + `
+		FROM invitations WHERE id = ? AND status = 'pending'
+	`
+	err := db.QueryRow(query, notifID).Scan(&inviteType, &refID, &senderID, &receiverID, &expired)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found or already handled"})
 		return
@@ -1446,6 +2760,21 @@ func AcceptInvitationHandler(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "This is not your invitation"})
 		return
 	}
+	if expired {
+		c.JSON(http.StatusGone, gin.H{"error": "This invitation has expired"})
+		return
+	}
+	if inviteType == "group" && isBannedFromGroup(refID, myID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You have been banned from this group"})
+		return
+	}
+	// Accepting an event invitation registers me, so the usual checks apply.
+	if inviteType == "event" {
+		if status, body := registrationBlocked(myID, refID); status != 0 {
+			c.JSON(status, body)
+			return
+		}
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		log.Println("AcceptInvite (tx begin) error:", err)
@@ -1453,6 +2782,9 @@ func AcceptInvitationHandler(c *gin.Context) {
 		return
 	}
 	_, err = tx.Exec(`UPDATE invitations SET status = 'accepted' WHERE id = ?`, notifID)
+	if err == nil {
+		err = markInvitationNotificationRead(tx, notifID)
+	}
 	if err != nil {
 		tx.Rollback()
 		log.Println("AcceptInvite (update) error:", err)
@@ -1468,6 +2800,27 @@ func AcceptInvitationHandler(c *gin.Context) {
 			return
 		}
 	}
+	if inviteType == "follow" {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO follows (follower_id, following_id) VALUES (?, ?)`, senderID, myID)
+		if err == nil {
+			err = notify(tx, senderID, notifFollowAccepted, myID, nil)
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Println("AcceptInvite (insert follow) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if inviteType == "event" {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO registrations (user_id, event_id) VALUES (?, ?)`, myID, refID)
+		if err != nil {
+			tx.Rollback()
+			log.Println("AcceptInvite (register) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
 	if err := tx.Commit(); err != nil {
 		log.Println("AcceptInvite (commit) error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -1477,10 +2830,8 @@ func AcceptInvitationHandler(c *gin.Context) {
 }
 func DeclineInvitationHandler(c *gin.Context) {
 	myID := c.GetInt("userID")
-	notifIDStr := c.Param("id")
-	notifID, err := strconv.Atoi(notifIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+	notifID, ok := invitationParam(c)
+	if !ok {
 		return
 	}
 	query := `UPDATE invitations SET status = 'declined' WHERE id = ? AND receiver_id = ? AND status = 'pending'`
@@ -1495,6 +2846,9 @@ func DeclineInvitationHandler(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found or already handled"})
 		return
 	}
+	if err := markInvitationNotificationRead(db, notifID); err != nil {
+		log.Println("DeclineInvite (mark read) error:", err)
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Invitation declined"})
 }
 
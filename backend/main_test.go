@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupTestDB gives the test a fresh database in its own directory.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Chdir(t.TempDir())
+	initDB()
+	t.Cleanup(func() { db.Close() })
+}
+
+// createTestUser inserts a user with no password and returns its id.
+func createTestUser(t *testing.T, name, email, role string) int {
+	t.Helper()
+	res, err := db.Exec(`INSERT INTO users (name, email, password_hash, role, profile_image_url) VALUES (?, ?, '', ?, '')`, name, email, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return int(id)
+}
@@ -0,0 +1,743 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Direct messages. A conversation is either direct, between exactly two people
+// and unique per pair, or a small group started by one user. Who may start a
+// conversation with someone follows their messagesFrom privacy setting; blocks
+// apply everywhere.
+
+// maxConversationSize caps group conversations, their starter included.
+const maxConversationSize = 10
+
+const maxMessageLength = 4000
+
+// Conversation is one thread as seen by a participant.
+type Conversation struct {
+	ID           int           `json:"id"`
+	Kind         string        `json:"kind"` // direct or group
+	Title        string        `json:"title,omitempty"`
+	Participants []Participant `json:"participants"` // everyone else still in it
+	LastMessage  *Message      `json:"lastMessage,omitempty"`
+	UnreadCount  int           `json:"unreadCount"`
+	CreatedAt    string        `json:"createdAt"`
+}
+
+// Participant carries how far each person has read, for read receipts.
+type Participant struct {
+	User
+	LastReadMessageID int `json:"lastReadMessageId"`
+}
+
+type Message struct {
+	ID             int    `json:"id"`
+	ConversationID int    `json:"conversationId"`
+	Sender         User   `json:"sender"`
+	Body           string `json:"body"`
+	EventID        int    `json:"eventId,omitempty"` // set when sent to an event's registrants
+	CreatedAt      string `json:"createdAt"`
+	ReadBy         []int  `json:"readBy"` // other participants who have read it
+}
+
+// directKey identifies the direct conversation between two users.
+func directKey(a, b int) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d:%d", a, b)
+}
+
+// leftParticipants returns the people other than userID who have left the
+// conversation.
+func leftParticipants(conversationID, userID int) ([]int, error) {
+	rows, err := db.Query(`SELECT user_id FROM conversation_participants WHERE conversation_id = ? AND user_id != ? AND left_at IS NOT NULL`, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// unreachableRecipients returns those of ids the sender may not start a
+// conversation with: deleted accounts, blocks either way, and anyone whose
+// messagesFrom setting leaves the sender out.
+func unreachableRecipients(senderID int, senderRole string, ids []int) ([]int, error) {
+	placeholders := "?" + strings.Repeat(",?", len(ids)-1)
+	args := make([]interface{}, 0, len(ids)+2)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := db.Query(`
+		SELECT id FROM users WHERE id IN (`+placeholders+`) AND deleted_at IS NULL AND `+notBlockedClause("id"),
+		append(args, senderID, senderID)...)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			found[id] = true
+		}
+	}
+	rows.Close()
+	p, err := newPrivacyContext(senderID, senderRole, ids)
+	if err != nil {
+		return nil, err
+	}
+	var unreachable []int
+	for _, id := range ids {
+		if !found[id] || !p.canSee(id, p.settingsFor(id).MessagesFrom) {
+			unreachable = append(unreachable, id)
+		}
+	}
+	return unreachable, nil
+}
+
+// findOrCreateDirect returns the direct conversation between two users,
+// starting it if need be. Someone who had left it is brought back.
+func findOrCreateDirect(tx *sql.Tx, myID, otherID int) (int, bool, error) {
+	key := directKey(myID, otherID)
+	var id int
+	err := tx.QueryRow(`SELECT id FROM conversations WHERE direct_key = ?`, key).Scan(&id)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE conversation_participants SET left_at = NULL WHERE conversation_id = ?`, id)
+		return id, false, err
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	now := sqlTime(time.Now())
+	res, err := tx.Exec(`INSERT INTO conversations (direct_key, created_by, created_at) VALUES (?, ?, ?)`, key, myID, now)
+	if err != nil {
+		return 0, false, err
+	}
+	newID, _ := res.LastInsertId()
+	_, err = tx.Exec(`INSERT INTO conversation_participants (conversation_id, user_id, joined_at) VALUES (?, ?, ?), (?, ?, ?)`,
+		newID, myID, now, newID, otherID, now)
+	return int(newID), true, err
+}
+
+// postMessage adds a message and moves the conversation and the sender's read
+// marker along with it.
+func postMessage(tx *sql.Tx, conversationID, senderID int, body string, eventID int) (int, error) {
+	now := sqlTime(time.Now())
+	var event interface{}
+	if eventID != 0 {
+		event = eventID
+	}
+	res, err := tx.Exec(`INSERT INTO messages (conversation_id, sender_id, body, event_id, created_at) VALUES (?, ?, ?, ?, ?)`,
+		conversationID, senderID, body, event, now)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	if _, err := tx.Exec(`UPDATE conversations SET last_message_at = ? WHERE id = ?`, now, conversationID); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`UPDATE conversation_participants SET last_read_message_id = ? WHERE conversation_id = ? AND user_id = ?`,
+		id, conversationID, senderID)
+	return int(id), err
+}
+
+// messageBody trims and checks a message body, writing the error response itself.
+func messageBody(c *gin.Context, body string) (string, bool) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A message needs a body"})
+		return "", false
+	}
+	if len(body) > maxMessageLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Messages are limited to %d characters", maxMessageLength)})
+		return "", false
+	}
+	return body, true
+}
+
+// visibleConversationClause limits conversations c to those viewerID is still
+// in, dropping direct ones with someone blocked either way. It takes the
+// viewer's id four times.
+const visibleConversationClause = `c.id IN ( SELECT conversation_id FROM conversation_participants WHERE user_id = ? AND left_at IS NULL )
+	AND NOT ( c.direct_key IS NOT NULL AND EXISTS (
+		SELECT 1 FROM conversation_participants o JOIN user_blocks b
+		  ON (b.blocker_id = ? AND b.blocked_id = o.user_id) OR (b.blocked_id = ? AND b.blocker_id = o.user_id)
+		WHERE o.conversation_id = c.id AND o.user_id != ?
+	) )`
+
+// queryConversations loads the viewer's conversations matching where (over
+// conversations c), most recently active first.
+func queryConversations(viewerID int, where string, args ...interface{}) ([]Conversation, error) {
+	params := []interface{}{viewerID, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID}
+	rows, err := db.Query(`
+		SELECT c.id, c.direct_key IS NOT NULL, COALESCE(c.title, ''), c.created_at,
+		       ( SELECT COUNT(*) FROM messages m JOIN conversation_participants me ON me.conversation_id = m.conversation_id AND me.user_id = ?
+		         WHERE m.conversation_id = c.id AND m.id > me.last_read_message_id AND m.sender_id != ? AND `+notBlockedClause("m.sender_id")+` )
+		FROM conversations c
+		WHERE `+visibleConversationClause+` AND `+where+`
+		ORDER BY COALESCE(c.last_message_at, c.created_at) DESC, c.id DESC
+	`, append(params, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	conversations := []Conversation{}
+	index := make(map[int]int)
+	var ids []interface{}
+	for rows.Next() {
+		var conv Conversation
+		var direct bool
+		if err := rows.Scan(&conv.ID, &direct, &conv.Title, &conv.CreatedAt, &conv.UnreadCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		conv.Kind = "group"
+		if direct {
+			conv.Kind = "direct"
+		}
+		conv.Participants = []Participant{}
+		index[conv.ID] = len(conversations)
+		conversations = append(conversations, conv)
+		ids = append(ids, conv.ID)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return conversations, nil
+	}
+	placeholders := "?" + strings.Repeat(",?", len(ids)-1)
+
+	rows, err = db.Query(`
+		SELECT cp.conversation_id, u.id, u.name, u.profile_image_url, cp.last_read_message_id
+		FROM conversation_participants cp JOIN users u ON u.id = cp.user_id
+		WHERE cp.conversation_id IN (`+placeholders+`) AND cp.left_at IS NULL AND cp.user_id != ?
+		ORDER BY cp.joined_at, u.id
+	`, append(ids, viewerID)...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var convID int
+		var p Participant
+		if err := rows.Scan(&convID, &p.ID, &p.Name, &p.ProfileImageURL, &p.LastReadMessageID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		conv := &conversations[index[convID]]
+		conv.Participants = append(conv.Participants, p)
+	}
+	rows.Close()
+
+	last, err := queryMessages(viewerID, `m.id IN (
+		SELECT MAX(id) FROM messages WHERE conversation_id IN (`+placeholders+`) AND `+notBlockedClause("sender_id")+` GROUP BY conversation_id
+	)`, 0, append(ids, viewerID, viewerID)...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range last {
+		conv := &conversations[index[last[i].ConversationID]]
+		conv.LastMessage = &last[i]
+		fillReadBy(conv.LastMessage, conv.Participants)
+	}
+	return conversations, nil
+}
+
+// queryMessages loads messages matching where (over messages m), newest first,
+// leaving out senders blocked either way. limit 0 means no limit.
+func queryMessages(viewerID int, where string, limit int, args ...interface{}) ([]Message, error) {
+	query := `
+		SELECT m.id, m.conversation_id, u.id, u.name, u.profile_image_url, m.body, COALESCE(m.event_id, 0), m.created_at
+		FROM messages m JOIN users u ON u.id = m.sender_id
+		WHERE ` + where + ` AND ` + notBlockedClause("m.sender_id") + `
+		ORDER BY m.id DESC`
+	args = append(args, viewerID, viewerID)
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	messages := []Message{}
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Sender.ID, &m.Sender.Name, &m.Sender.ProfileImageURL, &m.Body, &m.EventID, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.ReadBy = []int{}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// fillReadBy works out the read receipts of a message from the read markers of
+// the other participants.
+func fillReadBy(m *Message, participants []Participant) {
+	m.ReadBy = []int{}
+	for _, p := range participants {
+		if p.ID != m.Sender.ID && p.LastReadMessageID >= m.ID {
+			m.ReadBy = append(m.ReadBy, p.ID)
+		}
+	}
+}
+
+// myConversation loads :id for the caller, answering 404 when they aren't in it.
+func myConversation(c *gin.Context) (Conversation, bool) {
+	convID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return Conversation{}, false
+	}
+	list, err := queryConversations(c.GetInt("userID"), `c.id = ?`, convID)
+	if err != nil {
+		log.Println("myConversation error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return Conversation{}, false
+	}
+	if len(list) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return Conversation{}, false
+	}
+	return list[0], true
+}
+
+// unreadMessagesQuery counts a user's unread messages. It takes their id
+// eight times.
+const unreadMessagesQuery = `
+	SELECT COUNT(*) FROM messages m
+	JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = ? AND cp.left_at IS NULL
+	JOIN conversations c ON c.id = m.conversation_id
+	WHERE m.id > cp.last_read_message_id AND m.sender_id != ? AND m.sender_id NOT IN (
+		SELECT blocked_id FROM user_blocks WHERE blocker_id = ?
+		UNION SELECT blocker_id FROM user_blocks WHERE blocked_id = ?
+	) AND ` + visibleConversationClause
+
+func unreadMessageCount(userID int) (int, error) {
+	var n int
+	err := db.QueryRow(unreadMessagesQuery, userID, userID, userID, userID, userID, userID, userID, userID).Scan(&n)
+	return n, err
+}
+
+// streamReadReceipt tells everyone in the conversation how far userID has read,
+// and userID their new unread total.
+func streamReadReceipt(ex sqlExecer, conversationID, userID, lastRead int) error {
+	_, err := ex.Exec(`
+		INSERT INTO stream_events (user_id, type, data)
+		SELECT user_id, 'message_read', json_object('conversationId', ?, 'userId', ?, 'lastReadMessageId', ?)
+		FROM conversation_participants WHERE conversation_id = ? AND user_id != ? AND left_at IS NULL
+	`, conversationID, userID, lastRead, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	_, err = ex.Exec(`INSERT INTO stream_events (user_id, type, data) VALUES (?, 'message_unread_count', json_object('unreadCount', ( `+unreadMessagesQuery+` )))`,
+		userID, userID, userID, userID, userID, userID, userID, userID, userID)
+	return err
+}
+
+// --- Message Handlers ---
+
+func GetConversationsHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	// Conversations someone else started stay hidden until they say something.
+	conversations, err := queryConversations(myID, `(c.last_message_at IS NOT NULL OR c.created_by = ?)`, myID)
+	if err != nil {
+		log.Println("GetConversations error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	unread, err := unreadMessageCount(myID)
+	if err != nil {
+		log.Println("GetConversations (unread) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversations": conversations, "unreadCount": unread})
+}
+
+// CreateConversationHandler starts a conversation, optionally with a first
+// message. With one other participant it returns the existing direct
+// conversation if there is one; with more it always starts a new group.
+func CreateConversationHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	var payload struct {
+		ParticipantIDs []int  `json:"participantIds"`
+		Title          string `json:"title"`
+		Body           string `json:"body"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	seen := map[int]bool{myID: true}
+	var others []int
+	for _, id := range payload.ParticipantIDs {
+		if !seen[id] {
+			seen[id] = true
+			others = append(others, id)
+		}
+	}
+	if len(others) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pick someone to message"})
+		return
+	}
+	if len(others)+1 > maxConversationSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Group conversations are limited to %d people", maxConversationSize)})
+		return
+	}
+	body := strings.TrimSpace(payload.Body)
+	if body != "" {
+		var ok bool
+		if body, ok = messageBody(c, body); !ok {
+			return
+		}
+	}
+	unreachable, err := unreachableRecipients(myID, c.GetString("role"), others)
+	if err != nil {
+		log.Println("CreateConversation (privacy) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(unreachable) > 0 {
+		// Deliberately the same answer for blocks, privacy and missing accounts.
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't message some of these people", "userIds": unreachable})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("CreateConversation (begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var convID int
+	created := true
+	if len(others) == 1 {
+		convID, created, err = findOrCreateDirect(tx, myID, others[0])
+	} else {
+		now := sqlTime(time.Now())
+		var res sql.Result
+		res, err = tx.Exec(`INSERT INTO conversations (title, created_by, created_at) VALUES (?, ?, ?)`, strings.TrimSpace(payload.Title), myID, now)
+		if err == nil {
+			id, _ := res.LastInsertId()
+			convID = int(id)
+			for _, userID := range append([]int{myID}, others...) {
+				if _, err = tx.Exec(`INSERT INTO conversation_participants (conversation_id, user_id, joined_at) VALUES (?, ?, ?)`, convID, userID, now); err != nil {
+					break
+				}
+			}
+		}
+	}
+	if err == nil && body != "" {
+		_, err = postMessage(tx, convID, myID, body, 0)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("CreateConversation error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("CreateConversation (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	list, err := queryConversations(myID, `c.id = ?`, convID)
+	if err != nil || len(list) == 0 {
+		log.Println("CreateConversation (reload) error:", err)
+		c.JSON(http.StatusCreated, gin.H{"id": convID})
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, list[0])
+}
+
+func GetConversationHandler(c *gin.Context) {
+	if conv, ok := myConversation(c); ok {
+		c.JSON(http.StatusOK, conv)
+	}
+}
+
+// GetMessagesHandler pages through a conversation, newest first. It takes
+// ?before=<message id> and ?limit=.
+func GetMessagesHandler(c *gin.Context) {
+	conv, ok := myConversation(c)
+	if !ok {
+		return
+	}
+	where := `m.conversation_id = ?`
+	args := []interface{}{conv.ID}
+	if before, err := strconv.Atoi(c.Query("before")); err == nil {
+		where += ` AND m.id < ?`
+		args = append(args, before)
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		limit = 50
+	}
+	messages, err := queryMessages(c.GetInt("userID"), where, limit, args...)
+	if err != nil {
+		log.Println("GetMessages error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for i := range messages {
+		fillReadBy(&messages[i], conv.Participants)
+	}
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
+// SendMessageHandler posts to a conversation. Replies need no privacy check,
+// but nobody can write to a direct conversation across a block.
+func SendMessageHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	conv, ok := myConversation(c)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Body string `json:"body"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	body, ok := messageBody(c, payload.Body)
+	if !ok {
+		return
+	}
+	var returning []int
+	if conv.Kind == "direct" {
+		// The other side may have left; writing again brings them back, as
+		// long as they could be messaged afresh.
+		var err error
+		returning, err = leftParticipants(conv.ID, myID)
+		if err == nil && len(returning) > 0 {
+			var unreachable []int
+			unreachable, err = unreachableRecipients(myID, c.GetString("role"), returning)
+			if err == nil && len(unreachable) > 0 {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You can't message this person"})
+				return
+			}
+		}
+		if err != nil {
+			log.Println("SendMessage (participants) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	} else if len(conv.Participants) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Everyone else has left this conversation"})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("SendMessage (begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(returning) > 0 {
+		_, err = tx.Exec(`UPDATE conversation_participants SET left_at = NULL WHERE conversation_id = ?`, conv.ID)
+	}
+	var messageID int
+	if err == nil {
+		messageID, err = postMessage(tx, conv.ID, myID, body, 0)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("SendMessage error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("SendMessage (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	messages, err := queryMessages(myID, `m.id = ?`, 0, messageID)
+	if err != nil || len(messages) == 0 {
+		log.Println("SendMessage (reload) error:", err)
+		c.JSON(http.StatusCreated, gin.H{"id": messageID})
+		return
+	}
+	c.JSON(http.StatusCreated, messages[0])
+}
+
+// MarkConversationReadHandler moves the caller's read marker to the given
+// message, or the latest one. The marker never moves backwards.
+func MarkConversationReadHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	conv, ok := myConversation(c)
+	if !ok {
+		return
+	}
+	var payload struct {
+		MessageID int `json:"messageId"`
+	}
+	c.ShouldBindJSON(&payload)
+	var latest int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM messages WHERE conversation_id = ?`, conv.ID).Scan(&latest); err != nil {
+		log.Println("MarkConversationRead error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	upTo := latest
+	if payload.MessageID > 0 && payload.MessageID < latest {
+		upTo = payload.MessageID
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("MarkConversationRead (begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	res, err := tx.Exec(`
+		UPDATE conversation_participants SET last_read_message_id = ?
+		WHERE conversation_id = ? AND user_id = ? AND last_read_message_id < ?
+	`, upTo, conv.ID, myID, upTo)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n > 0 {
+			err = streamReadReceipt(tx, conv.ID, myID, upTo)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("MarkConversationRead error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("MarkConversationRead (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	unread, err := unreadMessageCount(myID)
+	if err != nil {
+		log.Println("MarkConversationRead (unread) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"lastReadMessageId": upTo, "unreadCount": unread})
+}
+
+// LeaveConversationHandler takes the caller out of a conversation. Leaving a
+// direct one only hides it until the other person writes again.
+func LeaveConversationHandler(c *gin.Context) {
+	conv, ok := myConversation(c)
+	if !ok {
+		return
+	}
+	_, err := db.Exec(`UPDATE conversation_participants SET left_at = ? WHERE conversation_id = ? AND user_id = ?`,
+		sqlTime(time.Now()), conv.ID, c.GetInt("userID"))
+	if err != nil {
+		log.Println("LeaveConversation error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Left conversation"})
+}
+
+func GetUnreadMessageCountHandler(c *gin.Context) {
+	unread, err := unreadMessageCount(c.GetInt("userID"))
+	if err != nil {
+		log.Println("GetUnreadMessageCount error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unreadCount": unread})
+}
+
+// MessageEventRegistrantsHandler sends the same message to everyone registered
+// for an event, each in their own direct conversation with the sender so
+// replies stay private. Having registered counts as consent to hear from the
+// event's managers, so only blocks and messagesFrom "only_me" are skipped.
+func MessageEventRegistrantsHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	eventID, ok := requireEventManager(c)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Body string `json:"body"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	body, ok := messageBody(c, payload.Body)
+	if !ok {
+		return
+	}
+	rows, err := db.Query(`
+		SELECT r.user_id,
+		       u.deleted_at IS NULL AND `+notBlockedClause("r.user_id")+`
+		       AND COALESCE(( SELECT messages_from FROM user_privacy WHERE user_id = r.user_id ), ?) != ?
+		FROM registrations r JOIN users u ON u.id = r.user_id
+		WHERE r.event_id = ? AND r.user_id != ?
+	`, myID, myID, defaultPrivacySettings.MessagesFrom, audienceOnlyMe, eventID, myID)
+	if err != nil {
+		log.Println("MessageEventRegistrants error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var recipients []int
+	skipped := 0
+	for rows.Next() {
+		var userID int
+		var reachable bool
+		if err := rows.Scan(&userID, &reachable); err != nil {
+			continue
+		}
+		if reachable {
+			recipients = append(recipients, userID)
+		} else {
+			skipped++
+		}
+	}
+	rows.Close()
+	sort.Ints(recipients)
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("MessageEventRegistrants (begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for _, userID := range recipients {
+		convID, _, err := findOrCreateDirect(tx, myID, userID)
+		if err == nil {
+			_, err = postMessage(tx, convID, myID, body, eventID)
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Println("MessageEventRegistrants (send) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("MessageEventRegistrants (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sent": len(recipients), "skipped": skipped})
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// digestLimit is how many notifications a digest lists before summing up the rest.
+const digestLimit = 20
+
+// unsubscribeDigest is the unsubscribe scope of digest emails; every other
+// email is unsubscribed by its notification type.
+const unsubscribeDigest = "digest"
+
+// emailedNotification is a notification together with who it goes to.
+type emailedNotification struct {
+	Notification
+	userID      int
+	name, email string
+	actor       string
+}
+
+// queryEmailNotifications loads unread notifications matching where (over
+// notifications n) for users who still have an account, oldest first, with
+// their payloads expanded.
+func queryEmailNotifications(where string, args ...interface{}) ([]emailedNotification, error) {
+	rows, err := db.Query(`
+		SELECT n.id, n.type, n.payload, n.created_at, n.user_id, u.name, u.email, COALESCE(a.name, '')
+		FROM notifications n
+		JOIN users u ON u.id = n.user_id
+		LEFT JOIN users a ON a.id = n.actor_id
+		WHERE `+where+` AND n.read_at IS NULL AND u.deleted_at IS NULL
+		ORDER BY n.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []emailedNotification
+	for rows.Next() {
+		var e emailedNotification
+		var payload string
+		if err := rows.Scan(&e.ID, &e.Type, &payload, &e.CreatedAt, &e.userID, &e.name, &e.email, &e.actor); err != nil {
+			return nil, err
+		}
+		e.Payload = json.RawMessage(payload)
+		list = append(list, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	expanded := make([]*Notification, len(list))
+	for i := range list {
+		expanded[i] = &list[i].Notification
+	}
+	return list, expandNotifications(expanded)
+}
+
+// notificationEmail words a notification for email: a subject line, which also
+// serves as its line in a digest, and the text under it.
+func notificationEmail(n *Notification, actor string) (subject, text string) {
+	var p struct {
+		Message           string   `json:"message"`
+		Changes           []string `json:"changes"`
+		CertificationName string   `json:"certificationName"`
+		ExpiresAt         string   `json:"expiresAt"`
+		StartsAt          string   `json:"startsAt"`
+		Location          string   `json:"location"`
+		Organizer         struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"organizer"`
+	}
+	json.Unmarshal(n.Payload, &p)
+	if actor == "" {
+		actor = "Someone"
+	}
+	group, event := "a group", "an event"
+	groupLink, eventLink := appBaseURL+"/groups", appBaseURL+"/events"
+	if n.Group != nil {
+		group, groupLink = n.Group.Name, fmt.Sprintf("%s/groups/%d", appBaseURL, n.Group.ID)
+	}
+	if n.Event != nil {
+		event, eventLink = n.Event.Name, fmt.Sprintf("%s/events/%d", appBaseURL, n.Event.ID)
+	}
+	switch n.Type {
+	case notifInvitation:
+		switch {
+		case n.Invitation != nil && n.Invitation.InviteType == "follow":
+			subject = actor + " asked to follow you"
+		case n.Invitation != nil && n.Invitation.InviteType == "event":
+			subject = fmt.Sprintf("%s invited you to %s", actor, event)
+		default:
+			subject = fmt.Sprintf("%s invited you to join %s", actor, group)
+		}
+		text = "Accept or decline here:\n" + appBaseURL + "/notifications"
+	case notifAnnouncement:
+		subject = "New announcement in " + group
+		text = "See the group here:\n" + groupLink
+		if n.Post != nil {
+			if n.Post.Title != "" {
+				subject = fmt.Sprintf("[%s] %s", group, n.Post.Title)
+			}
+			text = n.Post.Body + "\n\n" + text
+		}
+	case notifJoinApproved:
+		subject = "You're now a member of " + group
+		text = "See the group here:\n" + groupLink
+	case notifJoinDenied:
+		subject = fmt.Sprintf("Your request to join %s was declined", group)
+		if p.Message != "" {
+			text = "The organizers said:\n\n" + p.Message
+		}
+	case notifNewFollower:
+		subject = actor + " started following you"
+	case notifFollowAccepted:
+		subject = actor + " accepted your follow request"
+	case notifEventUpdated:
+		subject = event + " has changed"
+		text = fmt.Sprintf("The organizer updated: %s.\n\nSee the event here:\n%s", strings.Join(p.Changes, ", "), eventLink)
+	case notifCertExpiring:
+		subject = fmt.Sprintf("Your %s certificate expires on %s", p.CertificationName, p.ExpiresAt)
+		text = fmt.Sprintf("Events that require it will stop accepting your registration after that date.\n\nUpload a renewed certificate here:\n%s/profile/certifications", appBaseURL)
+	case notifEventReminder:
+		subject = "Reminder: " + event
+		if start, err := time.Parse(time.RFC3339, p.StartsAt); err == nil {
+			subject = fmt.Sprintf("Reminder: %s on %s", event, start.Format("Mon 2 Jan at 15:04"))
+		}
+		var b strings.Builder
+		if p.Location != "" {
+			fmt.Fprintf(&b, "Where: %s\n", p.Location)
+		}
+		if p.Organizer.Email != "" {
+			fmt.Fprintf(&b, "Organizer: %s <%s>\n", p.Organizer.Name, p.Organizer.Email)
+		} else if p.Organizer.Name != "" {
+			fmt.Fprintf(&b, "Organizer: %s\n", p.Organizer.Name)
+		}
+		fmt.Fprintf(&b, "\nSee the event here:\n%s", eventLink)
+		text = b.String()
+	default:
+		subject = "You have a new notification"
+	}
+	if text == "" {
+		text = "See your notifications here:\n" + appBaseURL + "/notifications"
+	}
+	return subject, text
+}
+
+// dropReadEmails stops emailing notifications that were read in the app first.
+func dropReadEmails() error {
+	_, err := db.Exec(`UPDATE notifications SET email_status = NULL WHERE email_status IN ('pending', 'digest') AND read_at IS NOT NULL`)
+	return err
+}
+
+// claimEmail moves a notification from one email status to 'sending' and
+// reports whether this call did it, so instances sharing the database never
+// send the same email twice. One that dies mid-send leaves the row in
+// 'sending': a lost email rather than a duplicate.
+func claimEmail(id int, from string) (bool, error) {
+	res, err := db.Exec(`UPDATE notifications SET email_status = 'sending' WHERE id = ? AND email_status = ?`, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// sendNotificationEmails emails notifications on the email channel, holding
+// back those whose recipient is in quiet hours.
+func sendNotificationEmails() error {
+	if err := dropReadEmails(); err != nil {
+		return err
+	}
+	pending, err := queryEmailNotifications(`n.email_status = 'pending'`)
+	if err != nil {
+		return err
+	}
+	schedules := map[int]emailSchedule{}
+	for _, e := range pending {
+		s, ok := schedules[e.userID]
+		if !ok {
+			if s, err = loadEmailSchedule(e.userID); err != nil {
+				return err
+			}
+			schedules[e.userID] = s
+		}
+		if s.quietNow() {
+			continue
+		}
+		claimed, err := claimEmail(e.ID, "pending")
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		subject, text := notificationEmail(&e.Notification, e.actor)
+		err = mailer.Send(withUnsubscribe(Email{
+			To:      e.email,
+			Subject: subject,
+			Body:    fmt.Sprintf("Hi %s,\n\n%s\n", e.name, text),
+		}, e.userID, e.Type))
+		if err != nil {
+			log.Printf("Notification email to %s failed: %v", e.email, err)
+			db.Exec(`UPDATE notifications SET email_status = 'pending' WHERE id = ? AND email_status = 'sending'`, e.ID)
+			continue
+		}
+		db.Exec(`UPDATE notifications SET email_status = 'sent' WHERE id = ?`, e.ID)
+	}
+	return nil
+}
+
+// sendNotificationDigests batches each user's unread digest notifications into
+// one email once their daily or weekly digest is due.
+func sendNotificationDigests() error {
+	if err := dropReadEmails(); err != nil {
+		return err
+	}
+	queued, err := queryEmailNotifications(`n.email_status = 'digest'`)
+	if err != nil {
+		return err
+	}
+	var users []int
+	byUser := map[int][]emailedNotification{}
+	for _, e := range queued {
+		if _, ok := byUser[e.userID]; !ok {
+			users = append(users, e.userID)
+		}
+		byUser[e.userID] = append(byUser[e.userID], e)
+	}
+	for _, userID := range users {
+		s, err := loadEmailSchedule(userID)
+		if err != nil {
+			return err
+		}
+		if !s.digestDue() || s.quietNow() {
+			continue
+		}
+		var items []emailedNotification
+		var ids []interface{}
+		for _, e := range byUser[userID] {
+			claimed, err := claimEmail(e.ID, "digest")
+			if err != nil {
+				return err
+			}
+			if claimed {
+				items = append(items, e)
+				ids = append(ids, e.ID)
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "Hi %s,\n\nHere's what happened since your last digest:\n\n", items[0].name)
+		for i := range items {
+			if i == digestLimit {
+				fmt.Fprintf(&b, "...and %d more.\n", len(items)-digestLimit)
+				break
+			}
+			subject, _ := notificationEmail(&items[i].Notification, items[i].actor)
+			fmt.Fprintf(&b, "- %s\n", subject)
+		}
+		fmt.Fprintf(&b, "\nSee them all here:\n%s/notifications\n", appBaseURL)
+		subject := fmt.Sprintf("Your %s digest: %d new notifications", s.digestFrequency, len(items))
+		if len(items) == 1 {
+			subject = fmt.Sprintf("Your %s digest: 1 new notification", s.digestFrequency)
+		}
+		err = mailer.Send(withUnsubscribe(Email{To: items[0].email, Subject: subject, Body: b.String()}, userID, unsubscribeDigest))
+		if err != nil {
+			log.Printf("Digest to %s failed: %v", items[0].email, err)
+			db.Exec(`UPDATE notifications SET email_status = 'digest' WHERE email_status = 'sending' AND id IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, ids...)
+			continue
+		}
+		db.Exec(`UPDATE notifications SET email_status = 'sent' WHERE id IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, ids...)
+		db.Exec(`
+			INSERT INTO notification_settings (user_id, last_digest_at) VALUES (?, ?)
+			ON CONFLICT(user_id) DO UPDATE SET last_digest_at = excluded.last_digest_at
+		`, userID, sqlTime(time.Now()))
+	}
+	return nil
+}
+
+// --- Unsubscribe ---
+
+// unsubscribeToken signs "<userID>:<scope>" so the link works without logging in.
+func unsubscribeToken(userID int, scope string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(userID) + ":" + scope))
+	return payload + "." + unsubscribeSignature(payload)
+}
+
+func unsubscribeSignature(payload string) string {
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte("unsubscribe:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func parseUnsubscribeToken(token string) (int, string, bool) {
+	payload, sig, found := strings.Cut(token, ".")
+	if !found || subtle.ConstantTimeCompare([]byte(sig), []byte(unsubscribeSignature(payload))) != 1 {
+		return 0, "", false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return 0, "", false
+	}
+	id, scope, found := strings.Cut(string(raw), ":")
+	userID, err := strconv.Atoi(id)
+	if !found || err != nil {
+		return 0, "", false
+	}
+	return userID, scope, true
+}
+
+// withUnsubscribe adds the unsubscribe link to the footer and the headers mail
+// clients use for their one-click unsubscribe button (RFC 8058).
+func withUnsubscribe(msg Email, userID int, scope string) Email {
+	link := appBaseURL + "/unsubscribe?token=" + url.QueryEscape(unsubscribeToken(userID, scope))
+	msg.Body += "\n--\nDon't want these emails? Unsubscribe here:\n" + link + "\n"
+	if msg.Headers == nil {
+		msg.Headers = map[string]string{}
+	}
+	msg.Headers["List-Unsubscribe"] = "<" + link + ">"
+	msg.Headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+	return msg
+}
+
+// unsubscribePage is what the footer link opens. Following a link must not
+// change anything (mail scanners prefetch them), so the page asks first and
+// posts back to the same URL.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Unsubscribe</title></head>
+<body style="font-family: sans-serif; max-width: 32rem; margin: 4rem auto; padding: 0 1rem">
+{{if .Error}}<p>{{.Error}}</p>
+{{else if .Done}}<p>{{.Message}}.</p>
+{{else}}<p>Stop getting {{.What}}? You'll still see them in the app.</p>
+<form method="post" action="{{.Action}}"><button type="submit">Unsubscribe</button></form>
+{{end}}</body></html>
+`))
+
+// unsubscribeTarget checks the link's token and that the account still exists.
+func unsubscribeTarget(c *gin.Context) (userID int, scope string, status int, message string) {
+	userID, scope, ok := parseUnsubscribeToken(c.Query("token"))
+	if !ok || (scope != unsubscribeDigest && !validNotificationType(scope)) {
+		return 0, "", http.StatusBadRequest, "This unsubscribe link is invalid"
+	}
+	var exists bool
+	db.QueryRow(`SELECT EXISTS ( SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL )`, userID).Scan(&exists)
+	if !exists {
+		return 0, "", http.StatusNotFound, "Account not found"
+	}
+	return userID, scope, 0, ""
+}
+
+// renderUnsubscribe answers browsers with the page and anything else with JSON.
+func renderUnsubscribe(c *gin.Context, status int, page gin.H) {
+	if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		c.Status(status)
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		if err := unsubscribePage.Execute(c.Writer, page); err != nil {
+			log.Println("Unsubscribe (render) error:", err)
+		}
+		return
+	}
+	if page["Error"] != nil {
+		c.JSON(status, gin.H{"error": page["Error"]})
+	} else {
+		c.JSON(status, gin.H{"message": page["Message"]})
+	}
+}
+
+// UnsubscribeConfirmHandler serves the link in the footer. It changes nothing;
+// the page's button posts to UnsubscribeHandler.
+func UnsubscribeConfirmHandler(c *gin.Context) {
+	_, scope, status, message := unsubscribeTarget(c)
+	if status != 0 {
+		renderUnsubscribe(c, status, gin.H{"Error": message})
+		return
+	}
+	what := "digest emails"
+	if scope != unsubscribeDigest {
+		what = "emails about " + strings.ReplaceAll(scope, "_", " ") + " notifications"
+	}
+	renderUnsubscribe(c, http.StatusOK, gin.H{
+		"What":    what,
+		"Action":  "/unsubscribe?token=" + url.QueryEscape(c.Query("token")),
+		"Message": "Confirm by sending a POST to this URL",
+	})
+}
+
+// UnsubscribeHandler serves the confirmation page's button and the mail
+// client's one-click POST (RFC 8058). Notifications stay in the app; only the
+// email stops.
+func UnsubscribeHandler(c *gin.Context) {
+	userID, scope, status, message := unsubscribeTarget(c)
+	if status != 0 {
+		renderUnsubscribe(c, status, gin.H{"Error": message})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Unsubscribe (begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if scope == unsubscribeDigest {
+		_, err = tx.Exec(`UPDATE notification_preferences SET channel = ? WHERE user_id = ? AND channel = ?`, channelInApp, userID, channelDigest)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE notifications SET email_status = NULL WHERE user_id = ? AND email_status = 'digest'`, userID)
+		}
+	} else {
+		// Whatever the channel was, unless it was already off, it becomes in_app.
+		_, err = tx.Exec(`
+			INSERT INTO notification_preferences (user_id, type, channel) VALUES (?, ?, ?)
+			ON CONFLICT(user_id, type) DO UPDATE SET channel = excluded.channel WHERE channel != ?
+		`, userID, scope, channelInApp, channelOff)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE notifications SET email_status = NULL WHERE user_id = ? AND type = ? AND email_status IN ('pending', 'digest')`, userID, scope)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Unsubscribe error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Unsubscribe (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	message = "You won't get emails about this any more"
+	if scope == unsubscribeDigest {
+		message = "You won't get digest emails any more"
+	}
+	renderUnsubscribe(c, http.StatusOK, gin.H{"Done": true, "Message": message})
+}
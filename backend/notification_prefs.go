@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Delivery channels a user can pick per notification type. Every channel but
+// off also shows the notification in the app.
+const (
+	channelInApp  = "in_app"
+	channelEmail  = "email"  // emailed straight away, quiet hours permitting
+	channelDigest = "digest" // batched into the daily or weekly digest
+	channelOff    = "off"
+)
+
+// notificationTypes are the types a preference can be set for.
+var notificationTypes = []string{
+	notifInvitation, notifAnnouncement, notifJoinApproved, notifJoinDenied,
+	notifNewFollower, notifFollowAccepted, notifEventUpdated, notifCertExpiring,
+	notifEventReminder,
+}
+
+// defaultChannels holds the types that aren't in_app until the user says
+// otherwise. Announcements are also emailed when the author asks, unless the
+// member has chosen a channel for them.
+var defaultChannels = map[string]string{
+	notifCertExpiring:  channelEmail,
+	notifEventReminder: channelEmail,
+}
+
+func defaultChannel(kind string) string {
+	if ch, ok := defaultChannels[kind]; ok {
+		return ch
+	}
+	return channelInApp
+}
+
+func validChannel(ch string) bool {
+	return ch == channelInApp || ch == channelEmail || ch == channelDigest || ch == channelOff
+}
+
+func validNotificationType(kind string) bool {
+	for _, t := range notificationTypes {
+		if t == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// channelFor is the SQL for the channel userExpr picked for a type. It takes
+// two arguments: the type and the channel to fall back on.
+func channelFor(userExpr string) string {
+	return `COALESCE(( SELECT channel FROM notification_preferences WHERE user_id = ` + userExpr + ` AND type = ? ), ?)`
+}
+
+// emailStatusFor maps a channel expression onto notifications.email_status.
+func emailStatusFor(channel string) string {
+	return `CASE ` + channel + ` WHEN 'email' THEN 'pending' WHEN 'digest' THEN 'digest' END`
+}
+
+// NotificationSettings is everything on the preferences page.
+type NotificationSettings struct {
+	Preferences     map[string]string `json:"preferences"`     // type -> channel
+	DigestFrequency string            `json:"digestFrequency"` // daily or weekly
+	QuietHours      *QuietHours       `json:"quietHours"`      // nil when not set
+	Timezone        string            `json:"timezone"`
+}
+
+// QuietHours hold back email between Start and End, given as HH:MM in the
+// user's timezone. End may be before Start for a span over midnight.
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// contains reports whether t falls inside the quiet hours.
+func (q QuietHours) contains(t time.Time) bool {
+	now := t.Format(clockLayout)
+	if q.Start <= q.End {
+		return now >= q.Start && now < q.End
+	}
+	return now >= q.Start || now < q.End
+}
+
+// emailSchedule is what the mail jobs need to know about a recipient.
+type emailSchedule struct {
+	digestFrequency string
+	quiet           *QuietHours
+	location        *time.Location
+	lastDigestAt    sql.NullString
+}
+
+// loadEmailSchedule reads a user's settings, falling back to the defaults.
+func loadEmailSchedule(userID int) (emailSchedule, error) {
+	s := emailSchedule{digestFrequency: "daily", location: time.UTC}
+	var start, end, tz string
+	err := db.QueryRow(`
+		SELECT digest_frequency, quiet_start, quiet_end, timezone, last_digest_at
+		FROM notification_settings WHERE user_id = ?
+	`, userID).Scan(&s.digestFrequency, &start, &end, &tz, &s.lastDigestAt)
+	if err == sql.ErrNoRows {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if loc, err := time.LoadLocation(tz); err == nil {
+		s.location = loc
+	}
+	if start != "" && end != "" {
+		s.quiet = &QuietHours{Start: start, End: end}
+	}
+	return s, nil
+}
+
+// quietNow reports whether email to this user should wait.
+func (s emailSchedule) quietNow() bool {
+	return s.quiet != nil && s.quiet.contains(time.Now().In(s.location))
+}
+
+// digestDue reports whether the user's next digest may go out.
+func (s emailSchedule) digestDue() bool {
+	if !s.lastDigestAt.Valid {
+		return true
+	}
+	last, err := time.Parse(time.RFC3339, s.lastDigestAt.String)
+	if err != nil {
+		return true
+	}
+	period := 24 * time.Hour
+	if s.digestFrequency == "weekly" {
+		period = 7 * 24 * time.Hour
+	}
+	return time.Since(last) >= period
+}
+
+func loadNotificationSettings(userID int) (NotificationSettings, error) {
+	settings := NotificationSettings{Preferences: map[string]string{}, DigestFrequency: "daily", Timezone: "UTC"}
+	for _, t := range notificationTypes {
+		settings.Preferences[t] = defaultChannel(t)
+	}
+	rows, err := db.Query(`SELECT type, channel FROM notification_preferences WHERE user_id = ?`, userID)
+	if err != nil {
+		return settings, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, channel string
+		if err := rows.Scan(&kind, &channel); err != nil {
+			return settings, err
+		}
+		settings.Preferences[kind] = channel
+	}
+	if err := rows.Err(); err != nil {
+		return settings, err
+	}
+	var start, end string
+	err = db.QueryRow(`SELECT digest_frequency, quiet_start, quiet_end, timezone FROM notification_settings WHERE user_id = ?`, userID).
+		Scan(&settings.DigestFrequency, &start, &end, &settings.Timezone)
+	if err != nil && err != sql.ErrNoRows {
+		return settings, err
+	}
+	if start != "" && end != "" {
+		settings.QuietHours = &QuietHours{Start: start, End: end}
+	}
+	return settings, nil
+}
+
+// setNotificationChannel records a user's choice of channel for a type.
+func setNotificationChannel(ex sqlExecer, userID int, kind, channel string) error {
+	_, err := ex.Exec(`
+		INSERT INTO notification_preferences (user_id, type, channel) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, type) DO UPDATE SET channel = excluded.channel
+	`, userID, kind, channel)
+	return err
+}
+
+func validClock(s string) bool {
+	_, err := time.Parse(clockLayout, s)
+	return err == nil && len(s) == 5
+}
+
+// --- Notification Preference Handlers ---
+
+func GetNotificationSettingsHandler(c *gin.Context) {
+	settings, err := loadNotificationSettings(c.GetInt("userID"))
+	if err != nil {
+		log.Println("GetNotificationSettings error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateNotificationSettingsHandler changes only what the body mentions. An
+// empty quietHours object ({"start": "", "end": ""}) turns quiet hours off.
+func UpdateNotificationSettingsHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	var payload struct {
+		Preferences     map[string]string `json:"preferences"`
+		DigestFrequency *string           `json:"digestFrequency"`
+		QuietHours      *QuietHours       `json:"quietHours"`
+		Timezone        *string           `json:"timezone"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	for kind, channel := range payload.Preferences {
+		if !validNotificationType(kind) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown notification type: " + kind})
+			return
+		}
+		if !validChannel(channel) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Channel must be one of in_app, email, digest or off"})
+			return
+		}
+		if kind == notifInvitation && channel == channelOff {
+			// Invitations wait for an answer, so they always show up somewhere.
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invitations can't be turned off"})
+			return
+		}
+	}
+	if payload.DigestFrequency != nil && *payload.DigestFrequency != "daily" && *payload.DigestFrequency != "weekly" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Digest frequency must be daily or weekly"})
+		return
+	}
+	if q := payload.QuietHours; q != nil && (q.Start != "" || q.End != "") {
+		if !validClock(q.Start) || !validClock(q.End) || q.Start == q.End {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quiet hours need a different start and end, as HH:MM"})
+			return
+		}
+	}
+	if payload.Timezone != nil {
+		if _, err := time.LoadLocation(*payload.Timezone); err != nil || *payload.Timezone == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone"})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("UpdateNotificationSettings (begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for kind, channel := range payload.Preferences {
+		if err := setNotificationChannel(tx, userID, kind, channel); err != nil {
+			tx.Rollback()
+			log.Println("UpdateNotificationSettings (preference) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO notification_settings (user_id) VALUES (?)`, userID)
+	if err == nil && payload.DigestFrequency != nil {
+		_, err = tx.Exec(`UPDATE notification_settings SET digest_frequency = ? WHERE user_id = ?`, *payload.DigestFrequency, userID)
+	}
+	if err == nil && payload.QuietHours != nil {
+		_, err = tx.Exec(`UPDATE notification_settings SET quiet_start = ?, quiet_end = ? WHERE user_id = ?`,
+			payload.QuietHours.Start, payload.QuietHours.End, userID)
+	}
+	if err == nil && payload.Timezone != nil {
+		_, err = tx.Exec(`UPDATE notification_settings SET timezone = ? WHERE user_id = ?`, *payload.Timezone, userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("UpdateNotificationSettings error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("UpdateNotificationSettings (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	GetNotificationSettingsHandler(c)
+}
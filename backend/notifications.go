@@ -0,0 +1,421 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Notification types. Invitations keep their own table so they can be accepted
+// or declined; an "invitation" notification points at one.
+const (
+	notifInvitation     = "invitation"             // {invitationId, inviteType, groupId | eventId | userId}
+	notifAnnouncement   = "announcement"           // {groupId, postId}
+	notifJoinApproved   = "join_approved"          // {groupId}
+	notifJoinDenied     = "join_denied"            // {groupId, message}
+	notifNewFollower    = "new_follower"           // {}
+	notifFollowAccepted = "follow_accepted"        // {}
+	notifEventUpdated   = "event_updated"          // {eventId, changes}
+	notifCertExpiring   = "certification_expiring" // {certificationId, certificationName, expiresAt}
+	notifEventReminder  = "event_reminder"         // {eventId, startsAt, location, organizer: {name, email}}
+)
+
+// Notification is one entry in a user's feed. Ids in the payload are expanded
+// into group, event and post where they still exist.
+type Notification struct {
+	ID         int             `json:"id"`
+	Type       string          `json:"type"`
+	Actor      *User           `json:"actor,omitempty"` // nil for system notices
+	Payload    json.RawMessage `json:"payload"`
+	Read       bool            `json:"read"`
+	CreatedAt  string          `json:"createdAt"`
+	Group      *Group          `json:"group,omitempty"`
+	Event      *Event          `json:"event,omitempty"`
+	Post       *GroupPost      `json:"post,omitempty"`
+	Invitation *Invitation     `json:"invitation,omitempty"`
+}
+
+// notificationLiveClause hides notifications for invitations that were revoked
+// or have since been deleted.
+const notificationLiveClause = `(n.invitation_id IS NULL OR n.invitation_id IN ( SELECT id FROM invitations WHERE status != 'revoked' ))`
+
+func nullableActor(actorID int) interface{} {
+	if actorID == 0 {
+		return nil
+	}
+	return actorID
+}
+
+// notify records a notification for one user. actorID is 0 for system notices.
+func notify(ex sqlExecer, userID int, kind string, actorID int, payload gin.H) error {
+	return insertNotifications(ex, `SELECT ? AS user_id`, []interface{}{userID}, kind, actorID, payload, channelInApp)
+}
+
+// notifyAll sends the same notification to every user picked by recipients, a
+// query selecting a user_id column. The actor is never notified.
+func notifyAll(ex sqlExecer, recipients string, args []interface{}, kind string, actorID int, payload gin.H) error {
+	return insertNotifications(ex, recipients, args, kind, actorID, payload, channelInApp)
+}
+
+// insertNotifications writes the notifications for notify and notifyAll. Each
+// recipient's preference for kind, or fallback when they have none, decides
+// whether it is stored at all and how it is emailed.
+func insertNotifications(ex sqlExecer, recipients string, args []interface{}, kind string, actorID int, payload gin.H, fallback string) error {
+	if payload == nil {
+		payload = gin.H{}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	params := append([]interface{}{kind, nullableActor(actorID), string(body), kind, fallback}, args...)
+	_, err = ex.Exec(`
+		INSERT INTO notifications (user_id, type, actor_id, payload, email_status)
+		SELECT user_id, ?, ?, ?, `+emailStatusFor("channel")+`
+		FROM ( SELECT DISTINCT r.user_id, `+channelFor("r.user_id")+` AS channel FROM ( `+recipients+` ) r )
+		WHERE channel != 'off' AND user_id != ?
+	`, append(params, actorID)...)
+	return err
+}
+
+// notifyInvitations creates the notification for each invitation matching where
+// (over invitations i) that doesn't have one yet.
+func notifyInvitations(ex sqlExecer, where string, args ...interface{}) error {
+	_, err := ex.Exec(`
+		INSERT INTO notifications (user_id, type, actor_id, payload, invitation_id, created_at, email_status)
+		SELECT i.receiver_id, ?, i.sender_id,
+		       json_object('invitationId', i.id, 'inviteType', i.invite_type,
+		                   CASE i.invite_type WHEN 'group' THEN 'groupId' WHEN 'event' THEN 'eventId' ELSE 'userId' END, i.reference_id),
+		       i.id, i.created_at, `+emailStatusFor(channelFor("i.receiver_id"))+`
+		FROM invitations i
+		WHERE `+where+` AND NOT EXISTS ( SELECT 1 FROM notifications n WHERE n.invitation_id = i.id )
+	`, append([]interface{}{notifInvitation, notifInvitation, channelInApp}, args...)...)
+	return err
+}
+
+// migrateNotifications moves what used to live in invitations over to the
+// notifications table. It is safe to run on every start.
+func migrateNotifications() {
+	if err := notifyInvitations(db, `i.invite_type IN ('group', 'event', 'follow') AND i.status = 'pending'`); err != nil {
+		log.Fatal("Failed to migrate invitation notifications: ", err)
+	}
+	_, err := db.Exec(`
+		INSERT INTO notifications (user_id, type, actor_id, payload, created_at)
+		SELECT i.receiver_id, i.invite_type, i.sender_id,
+		       CASE i.invite_type
+		           WHEN 'announcement' THEN json_object('groupId', p.group_id, 'postId', i.reference_id)
+		           ELSE json_object('groupId', i.reference_id, 'message', i.message)
+		       END,
+		       i.created_at
+		FROM invitations i LEFT JOIN group_posts p ON p.id = i.reference_id AND i.invite_type = 'announcement'
+		WHERE i.invite_type IN ('announcement', 'join_denied')
+	`)
+	if err == nil {
+		_, err = db.Exec(`DELETE FROM invitations WHERE invite_type IN ('announcement', 'join_denied')`)
+	}
+	if err != nil {
+		log.Fatal("Failed to migrate notices: ", err)
+	}
+}
+
+// invitationParam reads the invitation id from :id. Under /notifications the
+// id is that of the invitation's notification instead.
+func invitationParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return 0, false
+	}
+	if !strings.HasPrefix(c.FullPath(), "/notifications/") {
+		return id, true
+	}
+	var invitationID sql.NullInt64
+	err = db.QueryRow(`SELECT invitation_id FROM notifications WHERE id = ? AND user_id = ?`, id, c.GetInt("userID")).Scan(&invitationID)
+	if err != nil || !invitationID.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found or already handled"})
+		return 0, false
+	}
+	return int(invitationID.Int64), true
+}
+
+// markInvitationNotificationRead is called once an invitation has been answered.
+func markInvitationNotificationRead(ex sqlExecer, invitationID int) error {
+	_, err := ex.Exec(`UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE invitation_id = ? AND read_at IS NULL`, invitationID)
+	if err != nil {
+		return err
+	}
+	return streamUnreadCounts(ex, `SELECT user_id FROM notifications WHERE invitation_id = ?`, invitationID)
+}
+
+func unreadNotificationCount(userID int) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM notifications n WHERE n.user_id = ? AND n.read_at IS NULL AND `+notificationLiveClause, userID).Scan(&n)
+	return n, err
+}
+
+// notificationRef is what a payload can point at.
+type notificationRef struct {
+	InvitationID int `json:"invitationId"`
+	GroupID      int `json:"groupId"`
+	EventID      int `json:"eventId"`
+	PostID       int `json:"postId"`
+}
+
+// queryByIDs runs query, which must end in "IN (", for ids and hands each row
+// to scan. No ids means no query.
+func queryByIDs(query string, ids []interface{}, scan func(*sql.Rows) error) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := db.Query(query+`?`+strings.Repeat(",?", len(ids)-1)+`)`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// expandNotifications fills in what the payloads refer to, with one query per
+// kind of reference for the whole batch.
+func expandNotifications(ns []*Notification) error {
+	refs := make([]notificationRef, len(ns))
+	var groupIDs, eventIDs, postIDs, invitationIDs []interface{}
+	for i, n := range ns {
+		json.Unmarshal(n.Payload, &refs[i])
+		if refs[i].GroupID > 0 {
+			groupIDs = append(groupIDs, refs[i].GroupID)
+		}
+		if refs[i].EventID > 0 {
+			eventIDs = append(eventIDs, refs[i].EventID)
+		}
+		if refs[i].PostID > 0 {
+			postIDs = append(postIDs, refs[i].PostID)
+		}
+		if refs[i].InvitationID > 0 {
+			invitationIDs = append(invitationIDs, refs[i].InvitationID)
+		}
+	}
+
+	groups := map[int]Group{}
+	err := queryByIDs(`SELECT id, name, description, profile_image_url FROM groups WHERE id IN (`, groupIDs, func(rows *sql.Rows) error {
+		var g Group
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.ProfileImageURL); err != nil {
+			return err
+		}
+		groups[g.ID] = g
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	events := map[int]Event{}
+	err = queryByIDs(`SELECT id, name, date, start_time, end_time, location_address, image_url FROM events WHERE id IN (`, eventIDs, func(rows *sql.Rows) error {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.Name, &e.Date, &e.StartTime, &e.EndTime, &e.LocationAddress, &e.ImageURL); err != nil {
+			return err
+		}
+		events[e.ID] = e
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	posts := map[int]GroupPost{}
+	err = queryByIDs(`SELECT id, group_id, kind, title, body, created_at FROM group_posts WHERE id IN (`, postIDs, func(rows *sql.Rows) error {
+		var post GroupPost
+		if err := rows.Scan(&post.ID, &post.GroupID, &post.Kind, &post.Title, &post.Body, &post.CreatedAt); err != nil {
+			return err
+		}
+		posts[post.ID] = post
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	invitations := map[int]Invitation{}
+	err = queryByIDs(`
+		SELECT id, invite_type, status, created_at, expires_at, NOT `+invitationLiveClause("invitations")+`
+		FROM invitations WHERE id IN (`, invitationIDs, func(rows *sql.Rows) error {
+		var inv Invitation
+		var expiresAt sql.NullString
+		var expired bool
+		if err := rows.Scan(&inv.ID, &inv.InviteType, &inv.Status, &inv.CreatedAt, &expiresAt, &expired); err != nil {
+			return err
+		}
+		inv.ExpiresAt = expiresAt.String
+		if expired && inv.Status == "pending" {
+			inv.Status = "expired"
+		}
+		invitations[inv.ID] = inv
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Each notification gets its own copy, since callers fill in per-notification
+	// details such as the post's author.
+	for i, n := range ns {
+		if g, ok := groups[refs[i].GroupID]; ok {
+			n.Group = &g
+		}
+		if e, ok := events[refs[i].EventID]; ok {
+			n.Event = &e
+		}
+		if post, ok := posts[refs[i].PostID]; ok {
+			n.Post = &post
+		}
+		if inv, ok := invitations[refs[i].InvitationID]; ok {
+			n.Invitation = &inv
+		}
+	}
+	return nil
+}
+
+// --- Notification Handlers ---
+
+// GetNotificationsHandler lists my notifications, newest first. ?unread=true
+// limits it to unread ones; page back with ?before=<id>.
+func GetNotificationsHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	limit := 50
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	where := `n.user_id = ? AND ` + notificationLiveClause
+	args := []interface{}{myID}
+	if c.Query("unread") == "true" {
+		where += ` AND n.read_at IS NULL`
+	}
+	if before, err := strconv.Atoi(c.Query("before")); err == nil {
+		where += ` AND n.id < ?`
+		args = append(args, before)
+	}
+	rows, err := db.Query(`
+		SELECT n.id, n.type, n.payload, n.read_at IS NOT NULL, n.created_at,
+		       a.id, a.name, a.email, a.profile_image_url
+		FROM notifications n LEFT JOIN users a ON a.id = n.actor_id
+		WHERE `+where+`
+		ORDER BY n.id DESC
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		log.Println("GetNotifications error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	notifications := []Notification{}
+	actorIDs := []int{}
+	for rows.Next() {
+		var n Notification
+		var payload string
+		var actorID sql.NullInt64
+		var actorName, actorEmail, actorImage sql.NullString
+		if err := rows.Scan(&n.ID, &n.Type, &payload, &n.Read, &n.CreatedAt, &actorID, &actorName, &actorEmail, &actorImage); err != nil {
+			log.Println("GetNotifications scan error:", err)
+			continue
+		}
+		n.Payload = json.RawMessage(payload)
+		if actorID.Valid {
+			n.Actor = &User{ID: int(actorID.Int64), Name: actorName.String, Email: actorEmail.String, ProfileImageURL: actorImage.String}
+			actorIDs = append(actorIDs, n.Actor.ID)
+		}
+		notifications = append(notifications, n)
+	}
+	rows.Close()
+	privacy, err := newPrivacyContext(myID, c.GetString("role"), actorIDs)
+	if err != nil {
+		log.Println("GetNotifications privacy error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	expanded := make([]*Notification, len(notifications))
+	for i := range notifications {
+		expanded[i] = &notifications[i]
+	}
+	if err := expandNotifications(expanded); err != nil {
+		log.Println("GetNotifications (expand) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for i := range notifications {
+		n := &notifications[i]
+		if n.Actor == nil {
+			continue
+		}
+		privacy.redactUser(n.Actor)
+		if n.Post != nil {
+			n.Post.Author = *n.Actor
+		}
+		if n.Invitation != nil {
+			n.Invitation.Sender = *n.Actor
+		}
+	}
+	unread, err := unreadNotificationCount(myID)
+	if err != nil {
+		log.Println("GetNotifications (unread) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications, "unreadCount": unread})
+}
+
+func GetUnreadNotificationCountHandler(c *gin.Context) {
+	unread, err := unreadNotificationCount(c.GetInt("userID"))
+	if err != nil {
+		log.Println("GetUnreadNotificationCount error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unreadCount": unread})
+}
+
+func MarkNotificationReadHandler(c *gin.Context) {
+	notificationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+	res, err := db.Exec(`
+		UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP)
+		WHERE id = ? AND user_id = ?
+	`, notificationID, c.GetInt("userID"))
+	if err != nil {
+		log.Println("MarkNotificationRead error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+	if err := streamUnreadCounts(db, `SELECT ? AS user_id`, c.GetInt("userID")); err != nil {
+		log.Println("MarkNotificationRead (stream) error:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+func MarkAllNotificationsReadHandler(c *gin.Context) {
+	res, err := db.Exec(`UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL`, c.GetInt("userID"))
+	if err != nil {
+		log.Println("MarkAllNotificationsRead error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	n, _ := res.RowsAffected()
+	if err := streamUnreadCounts(db, `SELECT ? AS user_id`, c.GetInt("userID")); err != nil {
+		log.Println("MarkAllNotificationsRead (stream) error:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "All notifications marked as read", "marked": n})
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// OIDC single sign-on is enabled by setting OIDC_ISSUER. Every other setting has a
+// sensible default, and plain http issuers are accepted so a local mock provider works.
+//
+//	OIDC_ISSUER          issuer URL, e.g. https://login.partner.org
+//	OIDC_CLIENT_ID       client registered with the provider
+//	OIDC_CLIENT_SECRET   optional; omitted for public clients relying on PKCE alone
+//	OIDC_REDIRECT_URL    defaults to http://localhost:8080/auth/oidc/callback
+//	OIDC_FRONTEND_URL    where to send the browser with the session token; JSON is returned if unset
+//	OIDC_ROLE_CLAIM      claim holding the user's roles or groups (default "roles")
+//	OIDC_ROLE_MAP        comma separated claimValue=Role pairs, e.g. "vms-organizers=Organizer";
+//	                     only applied when SSO creates the account
+//	OIDC_DEFAULT_ROLE    role for new users when no mapping matches (default "Volunteer")
+//	OIDC_MFA_ACR         comma separated acr values the provider only issues after MFA
+//
+// A sign-in counts as multi-factor when the ID token's amr includes "mfa" or its
+// acr is listed in OIDC_MFA_ACR. Otherwise accounts that need a second factor
+// go through the local TOTP challenge, just as after a password.
+const oidcStateTTL = 10 * time.Minute
+
+// oidcPlaceholderName names accounts whose identity carries neither a name nor
+// an email local part. Users can change it on their profile.
+const oidcPlaceholderName = "New user"
+
+// oidcStateCookie ties a login to the browser that started it, so nobody can
+// hand a victim the callback URL of their own login. It is Lax rather than
+// Strict because the identity provider redirects back from another site.
+const oidcStateCookie = "oidc_state"
+
+func setOIDCStateCookie(c *gin.Context, p *oidcProvider, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, "/", "", strings.HasPrefix(p.cfg.RedirectURL, "https://"), true)
+}
+
+type oidcConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	FrontendURL  string
+	RoleClaim    string
+	RoleMap      map[string]string
+	DefaultRole  string
+	MFAACRs      []string
+}
+
+type oidcProvider struct {
+	cfg        oidcConfig
+	httpClient *http.Client
+
+	mu            sync.Mutex
+	authEndpoint  string
+	tokenEndpoint string
+	jwksURI       string
+	keys          map[string]*rsa.PublicKey
+}
+
+// oidcIdentity is what we take from a verified ID token.
+type oidcIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Roles         []string
+	MFA           bool // the provider vouches for a second factor
+}
+
+var (
+	oidcOnce   sync.Once
+	oidcClient *oidcProvider
+)
+
+func loadOIDCConfig() (oidcConfig, bool) {
+	cfg := oidcConfig{
+		Issuer:       strings.TrimSuffix(getEnv("OIDC_ISSUER", ""), "/"),
+		ClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/auth/oidc/callback"),
+		FrontendURL:  strings.TrimSuffix(getEnv("OIDC_FRONTEND_URL", ""), "/"),
+		RoleClaim:    getEnv("OIDC_ROLE_CLAIM", "roles"),
+		RoleMap:      map[string]string{},
+		DefaultRole:  getEnv("OIDC_DEFAULT_ROLE", "Volunteer"),
+	}
+	for _, pair := range strings.Split(getEnv("OIDC_ROLE_MAP", ""), ",") {
+		claimValue, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && claimValue != "" && role != "" {
+			cfg.RoleMap[claimValue] = role
+		}
+	}
+	for _, acr := range strings.Split(getEnv("OIDC_MFA_ACR", ""), ",") {
+		if acr = strings.TrimSpace(acr); acr != "" {
+			cfg.MFAACRs = append(cfg.MFAACRs, acr)
+		}
+	}
+	return cfg, cfg.Issuer != "" && cfg.ClientID != ""
+}
+
+// getOIDCProvider returns nil when SSO is not configured.
+func getOIDCProvider() *oidcProvider {
+	oidcOnce.Do(func() {
+		if cfg, ok := loadOIDCConfig(); ok {
+			oidcClient = &oidcProvider{cfg: cfg, httpClient: &http.Client{Timeout: 10 * time.Second}}
+		}
+	})
+	return oidcClient
+}
+
+func (p *oidcProvider) getJSON(target string, out interface{}) error {
+	resp, err := p.httpClient.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// discover loads the provider metadata on first use, so the server still starts
+// when the identity provider is unreachable.
+func (p *oidcProvider) discover() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tokenEndpoint != "" {
+		return nil
+	}
+	var meta struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JwksURI               string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(p.cfg.Issuer+"/.well-known/openid-configuration", &meta); err != nil {
+		return err
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != p.cfg.Issuer {
+		return fmt.Errorf("issuer mismatch: discovery document says %q", meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JwksURI == "" {
+		return fmt.Errorf("discovery document is missing endpoints")
+	}
+	p.authEndpoint = meta.AuthorizationEndpoint
+	p.tokenEndpoint = meta.TokenEndpoint
+	p.jwksURI = meta.JwksURI
+	return nil
+}
+
+func (p *oidcProvider) refreshKeys() error {
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(p.jwksURI, &set); err != nil {
+		return err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+// signingKey looks up a key by id, refetching the key set once in case the provider rotated keys.
+func (p *oidcProvider) signingKey(kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if err := p.refreshKeys(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	// Providers with a single key sometimes omit kid entirely.
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (p *oidcProvider) exchangeCode(code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequest(http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+func (p *oidcProvider) verifyIDToken(raw, nonce string) (*oidcIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(p.cfg.Issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer")
+	}
+	if !claims.VerifyAudience(p.cfg.ClientID, true) {
+		return nil, fmt.Errorf("token was not issued for this client")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+	identity := &oidcIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+	switch v := claims[p.cfg.RoleClaim].(type) {
+	case string:
+		identity.Roles = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				identity.Roles = append(identity.Roles, s)
+			}
+		}
+	}
+	if amr, ok := claims["amr"].([]interface{}); ok {
+		for _, item := range amr {
+			if item == "mfa" {
+				identity.MFA = true
+			}
+		}
+	}
+	if acr, ok := claims["acr"].(string); ok && containsString(p.cfg.MFAACRs, acr) {
+		identity.MFA = true
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("token has no subject")
+	}
+	return identity, nil
+}
+
+// mapRole picks the most privileged local role granted by the identity's claims.
+func (p *oidcProvider) mapRole(claimValues []string) (string, bool) {
+	rank := map[string]int{"Volunteer": 1, "Organizer": 2, "Admin": 3}
+	best := ""
+	for _, value := range claimValues {
+		if role, ok := p.cfg.RoleMap[value]; ok && rank[role] > rank[best] {
+			best = role
+		}
+	}
+	return best, best != ""
+}
+
+func randomURLToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// resolveOIDCUser finds the local account for an identity, linking it to an existing
+// email-matched user or creating a new one. Mapped roles only seed new accounts;
+// after that roles are managed here, so a wrong claim can't demote an admin or
+// promote anyone.
+func resolveOIDCUser(p *oidcProvider, identity *oidcIdentity) (int, string, bool, error) {
+	var userID int
+	var role string
+	var totpEnabled bool
+	err := db.QueryRow(`
+		SELECT u.id, u.role, u.totp_enabled FROM users u
+		JOIN user_identities ui ON ui.user_id = u.id
+		WHERE ui.issuer = ? AND ui.subject = ?
+	`, p.cfg.Issuer, identity.Subject).Scan(&userID, &role, &totpEnabled)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, "", false, err
+	}
+	if err == sql.ErrNoRows {
+		if identity.Email == "" {
+			return 0, "", false, fmt.Errorf("identity provider did not share an email address")
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return 0, "", false, err
+		}
+		err = tx.QueryRow(`SELECT id, role, totp_enabled FROM users WHERE email = ?`, identity.Email).Scan(&userID, &role, &totpEnabled)
+		if err != nil && err != sql.ErrNoRows {
+			tx.Rollback()
+			return 0, "", false, err
+		}
+		if err == nil && !identity.EmailVerified {
+			// Linking on an unverified address would let anyone take over the local account.
+			tx.Rollback()
+			return 0, "", false, fmt.Errorf("email address is not verified by the identity provider")
+		}
+		if err == sql.ErrNoRows {
+			role = p.cfg.DefaultRole
+			if mappedRole, ok := p.mapRole(identity.Roles); ok {
+				role = mappedRole
+			}
+			name := strings.TrimSpace(identity.Name)
+			if name == "" {
+				name = strings.Split(identity.Email, "@")[0]
+			}
+			if name == "" {
+				name = oidcPlaceholderName
+			}
+			defaultPFP := fmt.Sprintf("https://placehold.co/100x100/E8F5FF/1D9BF0?text=%s", string(name[0]))
+			// An empty hash never matches, so SSO-only accounts cannot use the password login.
+			res, err := tx.Exec(`INSERT INTO users (name, email, password_hash, role, profile_image_url) VALUES (?, ?, '', ?, ?)`, name, identity.Email, role, defaultPFP)
+			if err != nil {
+				tx.Rollback()
+				return 0, "", false, err
+			}
+			newID, _ := res.LastInsertId()
+			userID = int(newID)
+			log.Printf("New user registered via SSO with ID: %d", userID)
+		}
+		_, err = tx.Exec(`INSERT INTO user_identities (issuer, subject, user_id, email) VALUES (?, ?, ?, ?)`, p.cfg.Issuer, identity.Subject, userID, identity.Email)
+		if err != nil {
+			tx.Rollback()
+			return 0, "", false, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, "", false, err
+		}
+	}
+	return userID, role, totpEnabled, nil
+}
+
+// --- SSO Handlers ---
+func OIDCLoginHandler(c *gin.Context) {
+	p := getOIDCProvider()
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+	if err := p.discover(); err != nil {
+		log.Println("OIDCLogin (discovery) error:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+	state, errState := randomURLToken(24)
+	nonce, errNonce := randomURLToken(24)
+	verifier, errVerifier := randomURLToken(32)
+	if errState != nil || errNonce != nil || errVerifier != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	db.Exec(`DELETE FROM oidc_login_states WHERE created_at < ?`, sqlTime(time.Now().Add(-oidcStateTTL)))
+	_, err := db.Exec(`INSERT INTO oidc_login_states (state, nonce, code_verifier) VALUES (?, ?, ?)`, state, nonce, verifier)
+	if err != nil {
+		log.Println("OIDCLogin error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	setOIDCStateCookie(c, p, state, int(oidcStateTTL.Seconds()))
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.cfg.ClientID)
+	params.Set("redirect_uri", p.cfg.RedirectURL)
+	params.Set("scope", "openid email profile")
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+	separator := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, p.authEndpoint+separator+params.Encode())
+}
+func OIDCCallbackHandler(c *gin.Context) {
+	p := getOIDCProvider()
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+	if errCode := c.Query("error"); errCode != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in was cancelled or refused: " + errCode})
+		return
+	}
+	state := c.Query("state")
+	code := c.Query("code")
+	if state == "" || code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing state or code"})
+		return
+	}
+	cookie, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, p, "", -1)
+	if subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This sign-in was started in another browser"})
+		return
+	}
+	var nonce, verifier string
+	var createdAt time.Time
+	err := db.QueryRow(`SELECT nonce, code_verifier, created_at FROM oidc_login_states WHERE state = ?`, state).Scan(&nonce, &verifier, &createdAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown or expired login attempt"})
+		return
+	}
+	// Each state is single use.
+	db.Exec(`DELETE FROM oidc_login_states WHERE state = ?`, state)
+	if time.Since(createdAt) > oidcStateTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown or expired login attempt"})
+		return
+	}
+	if err := p.discover(); err != nil {
+		log.Println("OIDCCallback (discovery) error:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+	rawIDToken, err := p.exchangeCode(code, verifier)
+	if err != nil {
+		log.Println("OIDCCallback (exchange) error:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to complete sign-in with the identity provider"})
+		return
+	}
+	identity, err := p.verifyIDToken(rawIDToken, nonce)
+	if err != nil {
+		log.Println("OIDCCallback (verify) error:", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid identity token"})
+		return
+	}
+	userID, role, totpEnabled, err := resolveOIDCUser(p, identity)
+	if err != nil {
+		log.Println("OIDCCallback (link) error:", err)
+		c.JSON(http.StatusConflict, gin.H{"error": "Could not link this sign-in to an account: " + err.Error()})
+		return
+	}
+	// MFA done at the provider stands in for ours; otherwise the sign-in only
+	// counts as a first factor.
+	var result gin.H
+	if identity.MFA {
+		var token string
+		token, err = issueToken(userID, role, "", sessionTTL)
+		result = gin.H{"token": token, "role": role}
+	} else {
+		result, err = loginResult(userID, role, totpEnabled)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	if p.cfg.FrontendURL != "" {
+		fragment := url.Values{}
+		for key, value := range result {
+			fragment.Set(key, fmt.Sprint(value))
+		}
+		c.Redirect(http.StatusFound, p.cfg.FrontendURL+"/login/sso#"+fragment.Encode())
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
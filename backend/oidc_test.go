@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// mockOIDCProvider is a minimal identity provider: discovery, a key set and a
+// token endpoint that checks PKCE and signs whatever claims the test sets.
+type mockOIDCProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims jwt.MapClaims // added to the next ID token
+	codes  map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	nonce, challenge string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDCProvider{t: t, key: key, codes: map[string]mockAuthorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "test", "kty": "RSA", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	m.mu.Lock()
+	auth, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	claims := jwt.MapClaims{}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	m.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	claims["iss"] = m.server.URL
+	claims["aud"] = "vms"
+	claims["nonce"] = auth.nonce
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+}
+
+// authorize runs the whole flow and returns the callback's status and JSON.
+// Unless browser is false, the callback carries the cookies the login set, as
+// it does when one browser goes through both.
+func (m *mockOIDCProvider) authorize(t *testing.T, r *gin.Engine, claims jwt.MapClaims, browser bool) (int, gin.H) {
+	t.Helper()
+	m.mu.Lock()
+	m.claims = claims
+	m.mu.Unlock()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body)
+	}
+	redirect, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := redirect.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("login did not use PKCE: %s", redirect)
+	}
+	code, _ := randomURLToken(16)
+	m.mu.Lock()
+	m.codes[code] = mockAuthorization{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	m.mu.Unlock()
+
+	callback := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+url.Values{"state": {q.Get("state")}, "code": {code}}.Encode(), nil)
+	if browser {
+		for _, cookie := range w.Result().Cookies() {
+			callback.AddCookie(cookie)
+		}
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, callback)
+	var body gin.H
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w.Code, body
+}
+
+// signIn is authorize for sign-ins that must succeed.
+func (m *mockOIDCProvider) signIn(t *testing.T, r *gin.Engine, claims jwt.MapClaims) gin.H {
+	t.Helper()
+	status, body := m.authorize(t, r, claims, true)
+	if status != http.StatusOK {
+		t.Fatalf("callback: status %d: %v", status, body)
+	}
+	return body
+}
+
+func setupOIDCTest(t *testing.T) (*mockOIDCProvider, *gin.Engine) {
+	setupTestDB(t)
+	m := newMockOIDCProvider(t)
+	t.Setenv("OIDC_ISSUER", m.server.URL)
+	t.Setenv("OIDC_CLIENT_ID", "vms")
+	t.Setenv("OIDC_ROLE_MAP", "staff=Organizer,ops=Admin")
+	t.Setenv("OIDC_MFA_ACR", "urn:test:mfa")
+	oidcOnce, oidcClient = sync.Once{}, nil
+	t.Cleanup(func() { oidcOnce, oidcClient = sync.Once{}, nil })
+
+	r := gin.New()
+	r.GET("/auth/oidc/login", OIDCLoginHandler)
+	r.GET("/auth/oidc/callback", OIDCCallbackHandler)
+	return m, r
+}
+
+func roleOf(t *testing.T, userID int) string {
+	var role string
+	if err := db.QueryRow(`SELECT role FROM users WHERE id = ?`, userID).Scan(&role); err != nil {
+		t.Fatal(err)
+	}
+	return role
+}
+
+func TestOIDCProvisionsNewUserWithMappedRole(t *testing.T) {
+	m, r := setupOIDCTest(t)
+	body := m.signIn(t, r, jwt.MapClaims{"sub": "new-1", "email": "new@example.org", "email_verified": true, "name": "New", "roles": []string{"staff"}})
+	if body["role"] != "Organizer" || body["token"] == nil {
+		t.Fatalf("expected an Organizer session, got %v", body)
+	}
+	claims, err := parseToken(body["token"].(string))
+	if err != nil || claims.Scope != "" {
+		t.Fatalf("expected a full session token, got %v %v", claims, err)
+	}
+}
+
+func TestOIDCDoesNotRemapExistingAccounts(t *testing.T) {
+	m, r := setupOIDCTest(t)
+	adminID := createTestUser(t, "Admin", "admin@example.org", "Admin")
+	volunteerID := createTestUser(t, "Vol", "vol@example.org", "Volunteer")
+
+	body := m.signIn(t, r, jwt.MapClaims{"sub": "admin", "email": "admin@example.org", "email_verified": true, "roles": []string{"nobody"}})
+	if body["role"] != "Admin" || roleOf(t, adminID) != "Admin" {
+		t.Fatalf("admin was remapped: %v", body)
+	}
+	m.signIn(t, r, jwt.MapClaims{"sub": "vol", "email": "vol@example.org", "email_verified": true, "roles": []string{"ops"}})
+	if got := roleOf(t, volunteerID); got != "Volunteer" {
+		t.Fatalf("existing volunteer was promoted to %s", got)
+	}
+}
+
+func TestOIDCRefusesUnverifiedEmailLink(t *testing.T) {
+	m, r := setupOIDCTest(t)
+	createTestUser(t, "Vol", "vol@example.org", "Volunteer")
+	status, body := m.authorize(t, r, jwt.MapClaims{"sub": "x", "email": "vol@example.org", "email_verified": false}, true)
+	if status != http.StatusConflict {
+		t.Fatalf("expected 409 for an unverified email, got %d: %v", status, body)
+	}
+}
+
+func TestOIDCRequiresLocalSecondFactor(t *testing.T) {
+	m, r := setupOIDCTest(t)
+	userID := createTestUser(t, "Vol", "vol@example.org", "Volunteer")
+	db.Exec(`UPDATE users SET totp_enabled = 1 WHERE id = ?`, userID)
+	identity := func(extra jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{"sub": "vol", "email": "vol@example.org", "email_verified": true}
+		for k, v := range extra {
+			claims[k] = v
+		}
+		return claims
+	}
+
+	body := m.signIn(t, r, identity(nil))
+	if body["twoFactorRequired"] != true || body["token"] != nil {
+		t.Fatalf("expected a TOTP challenge, got %v", body)
+	}
+	body = m.signIn(t, r, identity(jwt.MapClaims{"amr": []string{"pwd", "mfa"}}))
+	if body["token"] == nil {
+		t.Fatalf("amr mfa should satisfy the second factor, got %v", body)
+	}
+	body = m.signIn(t, r, identity(jwt.MapClaims{"acr": "urn:test:mfa"}))
+	if body["token"] == nil {
+		t.Fatalf("a configured acr should satisfy the second factor, got %v", body)
+	}
+}
+
+func TestOIDCEnforcesOrganizerTwoFactor(t *testing.T) {
+	m, r := setupOIDCTest(t)
+	createTestUser(t, "Org", "org@example.org", "Organizer")
+	if err := setSetting(settingRequireOrganizer2FA, "true"); err != nil {
+		t.Fatal(err)
+	}
+	body := m.signIn(t, r, jwt.MapClaims{"sub": "org", "email": "org@example.org", "email_verified": true})
+	if body["twoFactorSetupRequired"] != true {
+		t.Fatalf("expected enrolment to be required, got %v", body)
+	}
+	claims, err := parseToken(body["token"].(string))
+	if err != nil || claims.Scope != scopeTwoFactorEnroll {
+		t.Fatalf("expected an enrolment-scoped token, got %v %v", claims, err)
+	}
+}
+
+func TestOIDCCallbackNeedsTheBrowserThatStartedLogin(t *testing.T) {
+	m, r := setupOIDCTest(t)
+	status, body := m.authorize(t, r, jwt.MapClaims{"sub": "attacker", "email": "attacker@example.org", "email_verified": true}, false)
+	if status != http.StatusBadRequest || body["token"] != nil {
+		t.Fatalf("expected a callback without the state cookie to be refused, got %d: %v", status, body)
+	}
+}
+
+func TestOIDCProvisionsUserWithoutAName(t *testing.T) {
+	m, r := setupOIDCTest(t)
+	m.signIn(t, r, jwt.MapClaims{"sub": "anon", "email": "@example.org", "email_verified": true})
+	var name string
+	if err := db.QueryRow(`SELECT name FROM users WHERE email = '@example.org'`).Scan(&name); err != nil || name != oidcPlaceholderName {
+		t.Fatalf("expected the placeholder name, got %q (%v)", name, err)
+	}
+}
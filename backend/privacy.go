@@ -0,0 +1,445 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Audiences a piece of profile data can be shown to.
+const (
+	audienceEveryone  = "everyone"
+	audienceFollowers = "followers" // people who follow me, plus organizers of events I signed up for
+	audienceOnlyMe    = "only_me"
+)
+
+type PrivacySettings struct {
+	EmailVisibility         string `json:"emailVisibility"`
+	PhoneVisibility         string `json:"phoneVisibility"`
+	RegistrationsVisibility string `json:"registrationsVisibility"`
+	FollowersVisibility     string `json:"followersVisibility"`
+	SkillsVisibility        string `json:"skillsVisibility"`
+	AvailabilityVisibility  string `json:"availabilityVisibility"` // weekly windows and blackout dates
+	MessagesFrom            string `json:"messagesFrom"`           // who may start a conversation with me
+	Discoverable            bool   `json:"discoverable"`           // shown in GET /users suggestions
+	PrivateAccount          bool   `json:"privateAccount"`         // follows need approval; "everyone" means approved followers
+}
+
+// defaultPrivacySettings applies to users who never saved their own.
+var defaultPrivacySettings = PrivacySettings{
+	EmailVisibility:         audienceFollowers,
+	PhoneVisibility:         audienceOnlyMe,
+	RegistrationsVisibility: audienceEveryone,
+	FollowersVisibility:     audienceEveryone,
+	SkillsVisibility:        audienceEveryone,
+	AvailabilityVisibility:  audienceEveryone,
+	MessagesFrom:            audienceEveryone,
+	Discoverable:            true,
+}
+
+func validAudience(a string) bool {
+	return a == audienceEveryone || a == audienceFollowers || a == audienceOnlyMe
+}
+
+// privacyContext answers "may this viewer see that field of that user" for a batch of
+// users, loading the settings and relationships it needs up front.
+type privacyContext struct {
+	viewerID   int
+	viewerRole string
+	settings   map[int]PrivacySettings
+	connected  map[int]bool
+}
+
+func newPrivacyContext(viewerID int, viewerRole string, ownerIDs []int) (*privacyContext, error) {
+	p := &privacyContext{viewerID: viewerID, viewerRole: viewerRole, settings: make(map[int]PrivacySettings), connected: make(map[int]bool)}
+	if len(ownerIDs) == 0 {
+		return p, nil
+	}
+	args := make([]interface{}, 0, len(ownerIDs))
+	seen := make(map[int]bool)
+	for _, id := range ownerIDs {
+		if !seen[id] {
+			seen[id] = true
+			args = append(args, id)
+		}
+	}
+	placeholders := "?" + strings.Repeat(",?", len(args)-1)
+	rows, err := db.Query(`
+		SELECT user_id, email_visibility, phone_visibility, registrations_visibility, followers_visibility, skills_visibility, availability_visibility, messages_from, discoverable, private_account
+		FROM user_privacy WHERE user_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		var s PrivacySettings
+		if err := rows.Scan(&id, &s.EmailVisibility, &s.PhoneVisibility, &s.RegistrationsVisibility, &s.FollowersVisibility, &s.SkillsVisibility, &s.AvailabilityVisibility, &s.MessagesFrom, &s.Discoverable, &s.PrivateAccount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		p.settings[id] = s
+	}
+	rows.Close()
+
+	connArgs := []interface{}{viewerID}
+	connArgs = append(connArgs, args...)
+	connArgs = append(connArgs, viewerID)
+	connArgs = append(connArgs, args...)
+	connRows, err := db.Query(`
+		SELECT following_id FROM follows WHERE follower_id = ? AND following_id IN (`+placeholders+`)
+		UNION
+		SELECT r.user_id FROM registrations r JOIN events e ON e.id = r.event_id
+		WHERE e.created_by_user_id = ? AND r.user_id IN (`+placeholders+`)
+	`, connArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer connRows.Close()
+	for connRows.Next() {
+		var id int
+		if err := connRows.Scan(&id); err == nil {
+			p.connected[id] = true
+		}
+	}
+	return p, nil
+}
+
+func (p *privacyContext) settingsFor(ownerID int) PrivacySettings {
+	if s, ok := p.settings[ownerID]; ok {
+		return s
+	}
+	return defaultPrivacySettings
+}
+
+func (p *privacyContext) canSee(ownerID int, audience string) bool {
+	if ownerID == p.viewerID || p.viewerRole == "Admin" {
+		return true
+	}
+	if audience == audienceEveryone && p.settingsFor(ownerID).PrivateAccount {
+		audience = audienceFollowers
+	}
+	switch audience {
+	case audienceEveryone:
+		return true
+	case audienceFollowers:
+		return p.connected[ownerID]
+	}
+	return false
+}
+
+func (p *privacyContext) canSeeEmail(ownerID int) bool {
+	return p.canSee(ownerID, p.settingsFor(ownerID).EmailVisibility)
+}
+func (p *privacyContext) canSeeRegistrations(ownerID int) bool {
+	return p.canSee(ownerID, p.settingsFor(ownerID).RegistrationsVisibility)
+}
+func (p *privacyContext) canSeeFollowers(ownerID int) bool {
+	return p.canSee(ownerID, p.settingsFor(ownerID).FollowersVisibility)
+}
+func (p *privacyContext) canSeeSkills(ownerID int) bool {
+	return p.canSee(ownerID, p.settingsFor(ownerID).SkillsVisibility)
+}
+
+func (p *privacyContext) canSeeAvailability(ownerID int) bool {
+	return p.canSee(ownerID, p.settingsFor(ownerID).AvailabilityVisibility)
+}
+
+func (p *privacyContext) redactUser(u *User) {
+	if !p.canSeeEmail(u.ID) {
+		u.Email = ""
+	}
+	if !p.canSee(u.ID, p.settingsFor(u.ID).PhoneVisibility) {
+		u.Phone = ""
+	}
+}
+
+func (p *privacyContext) redactVolunteer(v *VolunteerInfo) {
+	if !p.canSeeEmail(v.ID) {
+		v.Email = ""
+	}
+	if !p.canSee(v.ID, p.settingsFor(v.ID).PhoneVisibility) {
+		v.Phone = ""
+	}
+	if !p.canSeeSkills(v.ID) {
+		v.Skills = nil
+		v.SkillDetails = nil
+	}
+}
+
+func (p *privacyContext) redactEventOrganizer(e *Event) {
+	if !p.canSeeEmail(e.CreatedBy) {
+		e.CreatedByEmail = ""
+	}
+}
+
+// redactUsers applies the owners' privacy settings to a list of users shown to viewerID.
+func redactUsers(viewerID int, viewerRole string, users []User) error {
+	ids := make([]int, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	p, err := newPrivacyContext(viewerID, viewerRole, ids)
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		p.redactUser(&users[i])
+	}
+	return nil
+}
+
+// redactEvents hides organizer contact details the viewer is not allowed to see.
+func redactEvents(viewerID int, viewerRole string, events []Event) error {
+	ids := make([]int, len(events))
+	for i, e := range events {
+		ids[i] = e.CreatedBy
+	}
+	p, err := newPrivacyContext(viewerID, viewerRole, ids)
+	if err != nil {
+		return err
+	}
+	for i := range events {
+		p.redactEventOrganizer(&events[i])
+	}
+	return nil
+}
+
+// --- Privacy Handlers ---
+func GetPrivacySettingsHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	p, err := newPrivacyContext(userID, "", []int{userID})
+	if err != nil {
+		log.Println("GetPrivacySettings error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, p.settingsFor(userID))
+}
+
+// UpdatePrivacySettingsHandler changes the settings that are sent and keeps the
+// rest as they were.
+func UpdatePrivacySettingsHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	p, err := newPrivacyContext(userID, "", []int{userID})
+	if err != nil {
+		log.Println("UpdatePrivacySettings (load) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	current := p.settingsFor(userID)
+	payload := current
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	for _, a := range []string{payload.EmailVisibility, payload.PhoneVisibility, payload.RegistrationsVisibility, payload.FollowersVisibility, payload.SkillsVisibility, payload.AvailabilityVisibility, payload.MessagesFrom} {
+		if !validAudience(a) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Visibility must be one of everyone, followers or only_me"})
+			return
+		}
+	}
+	_, err = db.Exec(`
+		INSERT INTO user_privacy (user_id, email_visibility, phone_visibility, registrations_visibility, followers_visibility, skills_visibility, availability_visibility, messages_from, discoverable, private_account)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			email_visibility = excluded.email_visibility,
+			phone_visibility = excluded.phone_visibility,
+			registrations_visibility = excluded.registrations_visibility,
+			followers_visibility = excluded.followers_visibility,
+			skills_visibility = excluded.skills_visibility,
+			availability_visibility = excluded.availability_visibility,
+			messages_from = excluded.messages_from,
+			discoverable = excluded.discoverable,
+			private_account = excluded.private_account
+	`, userID, payload.EmailVisibility, payload.PhoneVisibility, payload.RegistrationsVisibility, payload.FollowersVisibility, payload.SkillsVisibility, payload.AvailabilityVisibility, payload.MessagesFrom, payload.Discoverable, payload.PrivateAccount)
+	if err != nil {
+		log.Println("UpdatePrivacySettings error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if current.PrivateAccount && !payload.PrivateAccount {
+		// Going public approves everyone who was waiting.
+		if err := approveAllFollowRequests(userID); err != nil {
+			log.Println("UpdatePrivacySettings (approve requests) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, payload)
+}
+
+// queryUsers runs a query selecting id, name, email, role and profile_image_url.
+func queryUsers(query string, args ...interface{}) ([]User, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.ProfileImageURL); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// listConnectionsHandler serves another user's follower or following list,
+// honouring that user's followers visibility.
+func listConnectionsHandler(c *gin.Context, followers bool) {
+	myID := c.GetInt("userID")
+	role := c.GetString("role")
+	ownerID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	p, err := newPrivacyContext(myID, role, []int{ownerID})
+	if err != nil {
+		log.Println("ListConnections (privacy) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if isBlockedEitherWay(myID, ownerID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !p.canSeeFollowers(ownerID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This user's connections are private"})
+		return
+	}
+	joinColumn, whereColumn := "f.follower_id", "f.following_id"
+	if !followers {
+		joinColumn, whereColumn = "f.following_id", "f.follower_id"
+	}
+	users, err := queryUsers(`
+		SELECT u.id, u.name, u.email, u.role, u.profile_image_url
+		FROM users u
+		JOIN follows f ON u.id = `+joinColumn+`
+		WHERE `+whereColumn+` = ?
+		AND `+notBlockedClause("u.id")+`
+		ORDER BY u.name
+	`, ownerID, myID, myID)
+	if err != nil {
+		log.Println("ListConnections error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := redactUsers(myID, role, users); err != nil {
+		log.Println("ListConnections (redact) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+func GetUserFollowersHandler(c *gin.Context) { listConnectionsHandler(c, true) }
+func GetUserFollowingHandler(c *gin.Context) { listConnectionsHandler(c, false) }
+
+// --- Follow Requests ---
+
+func isPrivateAccount(userID int) bool {
+	var private bool
+	db.QueryRow(`SELECT private_account FROM user_privacy WHERE user_id = ?`, userID).Scan(&private)
+	return private
+}
+
+// createFollowRequest files a request to follow a private account. Requests are
+// invitations of type "follow", so they show up in the target's notifications.
+func createFollowRequest(requesterID, targetID int) error {
+	var pending int
+	err := db.QueryRow(`SELECT COUNT(*) FROM invitations WHERE sender_id = ? AND receiver_id = ? AND invite_type = 'follow' AND status = 'pending'`, requesterID, targetID).Scan(&pending)
+	if err != nil || pending > 0 {
+		return err
+	}
+	res, err := db.Exec(`
+		INSERT INTO invitations (sender_id, receiver_id, invite_type, reference_id, status)
+		VALUES (?, ?, 'follow', ?, 'pending')
+	`, requesterID, targetID, targetID)
+	if err != nil {
+		return err
+	}
+	invitationID, _ := res.LastInsertId()
+	return notifyInvitations(db, `i.id = ?`, invitationID)
+}
+
+func approveAllFollowRequests(userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO follows (follower_id, following_id)
+		SELECT sender_id, receiver_id FROM invitations
+		WHERE receiver_id = ? AND invite_type = 'follow' AND status = 'pending'
+	`, userID)
+	if err == nil {
+		err = notifyAll(tx, `SELECT sender_id AS user_id FROM invitations WHERE receiver_id = ? AND invite_type = 'follow' AND status = 'pending'`,
+			[]interface{}{userID}, notifFollowAccepted, userID, nil)
+	}
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+			WHERE read_at IS NULL AND invitation_id IN ( SELECT id FROM invitations WHERE receiver_id = ? AND invite_type = 'follow' AND status = 'pending' )
+		`, userID)
+	}
+	if err == nil {
+		err = streamUnreadCounts(tx, `SELECT ? AS user_id`, userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`UPDATE invitations SET status = 'accepted' WHERE receiver_id = ? AND invite_type = 'follow' AND status = 'pending'`, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetFollowRequestsHandler lists pending requests to follow me. The id of each entry
+// is the request id used by the approve and deny endpoints.
+func GetFollowRequestsHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	rows, err := db.Query(`
+		SELECT i.id, i.created_at, u.id, u.name, u.email, u.role, u.profile_image_url
+		FROM invitations i
+		JOIN users u ON u.id = i.sender_id
+		WHERE i.receiver_id = ? AND i.invite_type = 'follow' AND i.status = 'pending'
+		ORDER BY i.created_at DESC
+	`, myID)
+	if err != nil {
+		log.Println("GetFollowRequests error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	requests := []Invitation{}
+	for rows.Next() {
+		inv := Invitation{InviteType: "follow", Status: "pending"}
+		if err := rows.Scan(&inv.ID, &inv.CreatedAt, &inv.Sender.ID, &inv.Sender.Name, &inv.Sender.Email, &inv.Sender.Role, &inv.Sender.ProfileImageURL); err != nil {
+			log.Println("GetFollowRequests scan error:", err)
+			continue
+		}
+		requests = append(requests, inv)
+	}
+	senderIDs := make([]int, len(requests))
+	for i, inv := range requests {
+		senderIDs[i] = inv.Sender.ID
+	}
+	privacy, err := newPrivacyContext(myID, c.GetString("role"), senderIDs)
+	if err != nil {
+		log.Println("GetFollowRequests privacy error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for i := range requests {
+		privacy.redactUser(&requests[i].Sender)
+	}
+	c.JSON(http.StatusOK, gin.H{"requests": requests})
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxBioLength       = 1000
+	maxProfileLinks    = 5
+	maxProfileLanguage = 10
+	emailVerifyTTL     = 24 * time.Hour
+)
+
+// ProfileDetails holds the optional, user-editable parts of a profile. It is
+// embedded in User and VolunteerInfo so the fields sit at the top level in JSON.
+type ProfileDetails struct {
+	Bio       string        `json:"bio,omitempty"`
+	Pronouns  string        `json:"pronouns,omitempty"`
+	City      string        `json:"city,omitempty"`
+	Phone     string        `json:"phone,omitempty"`
+	Links     []ProfileLink `json:"links,omitempty"`
+	Languages []string      `json:"languages,omitempty"`
+}
+type ProfileLink struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// UpdateProfilePayload uses pointers so clients can send only the fields they change.
+type UpdateProfilePayload struct {
+	Name      *string        `json:"name"`
+	Email     *string        `json:"email"`
+	Bio       *string        `json:"bio"`
+	Pronouns  *string        `json:"pronouns"`
+	City      *string        `json:"city"`
+	Phone     *string        `json:"phone"`
+	Links     *[]ProfileLink `json:"links"`
+	Languages *[]string      `json:"languages"`
+}
+
+// loadProfileDetails fetches profile details for many users in three queries.
+func loadProfileDetails(userIDs []int) (map[int]*ProfileDetails, error) {
+	details := make(map[int]*ProfileDetails)
+	if len(userIDs) == 0 {
+		return details, nil
+	}
+	args := make([]interface{}, len(userIDs))
+	for i, id := range userIDs {
+		args[i] = id
+		details[id] = &ProfileDetails{}
+	}
+	placeholders := "?" + strings.Repeat(",?", len(args)-1)
+
+	rows, err := db.Query(`SELECT id, bio, pronouns, city, phone FROM users WHERE id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		var d ProfileDetails
+		if err := rows.Scan(&id, &d.Bio, &d.Pronouns, &d.City, &d.Phone); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		*details[id] = d
+	}
+	rows.Close()
+
+	linkRows, err := db.Query(`SELECT user_id, label, url FROM user_links WHERE user_id IN (`+placeholders+`) ORDER BY user_id, position`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for linkRows.Next() {
+		var id int
+		var link ProfileLink
+		if err := linkRows.Scan(&id, &link.Label, &link.URL); err != nil {
+			linkRows.Close()
+			return nil, err
+		}
+		details[id].Links = append(details[id].Links, link)
+	}
+	linkRows.Close()
+
+	langRows, err := db.Query(`SELECT user_id, language FROM user_languages WHERE user_id IN (`+placeholders+`) ORDER BY user_id, language`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer langRows.Close()
+	for langRows.Next() {
+		var id int
+		var language string
+		if err := langRows.Scan(&id, &language); err != nil {
+			return nil, err
+		}
+		details[id].Languages = append(details[id].Languages, language)
+	}
+	return details, nil
+}
+
+func validateProfilePayload(p *UpdateProfilePayload) string {
+	if p.Name != nil && strings.TrimSpace(*p.Name) == "" {
+		return "Name cannot be empty."
+	}
+	if p.Email != nil && !strings.Contains(strings.TrimSpace(*p.Email), "@") {
+		return "Please enter a valid email address."
+	}
+	if p.Bio != nil && len(*p.Bio) > maxBioLength {
+		return fmt.Sprintf("Bio must be at most %d characters.", maxBioLength)
+	}
+	if p.Links != nil {
+		if len(*p.Links) > maxProfileLinks {
+			return fmt.Sprintf("You can add at most %d links.", maxProfileLinks)
+		}
+		for _, link := range *p.Links {
+			u, err := url.Parse(link.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return "Links must be full http or https URLs."
+			}
+		}
+	}
+	if p.Languages != nil && len(*p.Languages) > maxProfileLanguage {
+		return fmt.Sprintf("You can list at most %d languages.", maxProfileLanguage)
+	}
+	return ""
+}
+
+// startEmailChange stores a verification token for the new address and mails a link to it.
+// The account keeps its current email until the link is opened.
+func startEmailChange(userID int, newEmail string) error {
+	token, err := randomURLToken(32)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM email_verifications WHERE user_id = ?`, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO email_verifications (token, user_id, email, expires_at) VALUES (?, ?, ?, ?)`, token, userID, newEmail, sqlTime(time.Now().Add(emailVerifyTTL)))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	link := appBaseURL + "/profile/verify-email?token=" + url.QueryEscape(token)
+	sendEmailAsync(Email{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body:    "Open this link within 24 hours to confirm your new email address:\n\n" + link + "\n\nIf you did not ask for this change you can ignore this message.",
+	})
+	return nil
+}
+
+// --- Profile Editing Handlers ---
+func UpdateMyProfileHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	var payload UpdateProfilePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	if msg := validateProfilePayload(&payload); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	var currentEmail string
+	if err := db.QueryRow(`SELECT email FROM users WHERE id = ?`, userID).Scan(&currentEmail); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	pendingEmail := ""
+	if payload.Email != nil {
+		newEmail := strings.TrimSpace(*payload.Email)
+		if !strings.EqualFold(newEmail, currentEmail) {
+			var taken int
+			db.QueryRow(`SELECT COUNT(*) FROM users WHERE email = ?`, newEmail).Scan(&taken)
+			if taken > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "That email address is already in use"})
+				return
+			}
+			pendingEmail = newEmail
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("UpdateProfile (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	columns := map[string]*string{"name": payload.Name, "bio": payload.Bio, "pronouns": payload.Pronouns, "city": payload.City, "phone": payload.Phone}
+	for column, value := range columns {
+		if value == nil {
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE users SET `+column+` = ? WHERE id = ?`, strings.TrimSpace(*value), userID); err != nil {
+			tx.Rollback()
+			log.Println("UpdateProfile (update) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if payload.Links != nil {
+		if _, err := tx.Exec(`DELETE FROM user_links WHERE user_id = ?`, userID); err != nil {
+			tx.Rollback()
+			log.Println("UpdateProfile (delete links) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		for i, link := range *payload.Links {
+			if _, err := tx.Exec(`INSERT INTO user_links (user_id, label, url, position) VALUES (?, ?, ?, ?)`, userID, strings.TrimSpace(link.Label), link.URL, i); err != nil {
+				tx.Rollback()
+				log.Println("UpdateProfile (insert link) error:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+		}
+	}
+	if payload.Languages != nil {
+		if _, err := tx.Exec(`DELETE FROM user_languages WHERE user_id = ?`, userID); err != nil {
+			tx.Rollback()
+			log.Println("UpdateProfile (delete languages) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		for _, language := range *payload.Languages {
+			language = strings.TrimSpace(language)
+			if language == "" {
+				continue
+			}
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO user_languages (user_id, language) VALUES (?, ?)`, userID, language); err != nil {
+				tx.Rollback()
+				log.Println("UpdateProfile (insert language) error:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("UpdateProfile (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	response := gin.H{"message": "Profile updated successfully"}
+	if pendingEmail != "" {
+		if err := startEmailChange(userID, pendingEmail); err != nil {
+			log.Println("UpdateProfile (email change) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start email verification"})
+			return
+		}
+		response["pendingEmail"] = pendingEmail
+		response["message"] = "Profile updated. Check your new inbox to confirm the email change."
+	}
+	c.JSON(http.StatusOK, response)
+}
+func VerifyEmailHandler(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+	var userID int
+	var newEmail string
+	var expiresAt time.Time
+	err := db.QueryRow(`SELECT user_id, email, expires_at FROM email_verifications WHERE token = ?`, token).Scan(&userID, &newEmail, &expiresAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("VerifyEmail error:", err)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "This link is invalid or has already been used"})
+		return
+	}
+	db.Exec(`DELETE FROM email_verifications WHERE token = ?`, token)
+	if time.Now().After(expiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "This link has expired. Please request the change again."})
+		return
+	}
+	if _, err := db.Exec(`UPDATE users SET email = ? WHERE id = ?`, newEmail, userID); err != nil {
+		// The UNIQUE constraint fires if someone registered the address in the meantime.
+		log.Println("VerifyEmail (update) error:", err)
+		c.JSON(http.StatusConflict, gin.H{"error": "That email address is already in use"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email address updated", "email": newEmail})
+}
+
+// PublicProfile is what one user sees when opening another user's profile.
+type PublicProfile struct {
+	User
+	Skills         []string    `json:"skills"` // nil when hidden by privacy settings
+	SkillDetails   []UserSkill `json:"skillDetails,omitempty"`
+	FollowerCount  *int        `json:"followerCount"`  // nil when hidden by privacy settings
+	FollowingCount *int        `json:"followingCount"` // nil when hidden by privacy settings
+	SharedGroups   []Group     `json:"sharedGroups"`
+	UpcomingEvents []Event     `json:"upcomingEvents"` // nil when hidden by privacy settings
+	PastEvents     []Event     `json:"pastEvents"`
+	HostedEvents   []Event     `json:"hostedEvents,omitempty"`
+}
+
+const profilePastEventsLimit = 20
+
+// queryEvents runs a query selecting the standard event columns joined with the organizer.
+func queryEvents(query string, args ...interface{}) ([]Event, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.Name, &e.Date, &e.Description, &e.LocationAddress, &e.ImageURL, &e.StartTime, &e.EndTime, &e.CreatedBy, &e.CreatedByEmail, &e.CreatedByName, &e.OrganizerProfilePicture); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func GetUserProfileHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	role := c.GetString("role")
+	profileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if isBlockedEitherWay(myID, profileID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	var p PublicProfile
+	err = db.QueryRow(`SELECT id, name, email, role, profile_image_url FROM users WHERE id = ?`, profileID).Scan(&p.ID, &p.Name, &p.Email, &p.Role, &p.ProfileImageURL)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Println("GetUserProfile error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	details, err := loadProfileDetails([]int{profileID})
+	if err != nil {
+		log.Println("GetUserProfile (details) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	p.ProfileDetails = *details[profileID]
+	privacy, err := newPrivacyContext(myID, role, []int{profileID})
+	if err != nil {
+		log.Println("GetUserProfile (privacy) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	privacy.redactUser(&p.User)
+
+	var isFollowed, requested int
+	db.QueryRow(`SELECT COUNT(*) FROM follows WHERE follower_id = ? AND following_id = ?`, myID, profileID).Scan(&isFollowed)
+	db.QueryRow(`SELECT COUNT(*) FROM invitations WHERE sender_id = ? AND receiver_id = ? AND invite_type = 'follow' AND status = 'pending'`, myID, profileID).Scan(&requested)
+	p.IsFollowed = isFollowed > 0
+	p.FollowRequested = requested > 0
+	if privacy.canSeeFollowers(profileID) {
+		p.FollowerCount = new(int)
+		p.FollowingCount = new(int)
+		db.QueryRow(`SELECT COUNT(*) FROM follows WHERE following_id = ?`, profileID).Scan(p.FollowerCount)
+		db.QueryRow(`SELECT COUNT(*) FROM follows WHERE follower_id = ?`, profileID).Scan(p.FollowingCount)
+	}
+
+	if privacy.canSeeSkills(profileID) {
+		skills, err := loadUserSkills([]int{profileID})
+		if err != nil {
+			log.Println("GetUserProfile (skills) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		p.Skills = []string{}
+		for _, us := range skills[profileID] {
+			p.Skills = append(p.Skills, us.Name)
+		}
+		p.SkillDetails = skills[profileID]
+	}
+
+	p.SharedGroups = []Group{}
+	groupRows, err := db.Query(`
+		SELECT g.id, g.name, g.description, g.profile_image_url, g.created_by_user_id, g.privacy,
+		       (SELECT COUNT(*) FROM group_members gm WHERE gm.group_id = g.id) as memberCount
+		FROM groups g
+		JOIN group_members theirs ON theirs.group_id = g.id AND theirs.user_id = ?
+		JOIN group_members mine ON mine.group_id = g.id AND mine.user_id = ?
+		ORDER BY g.name
+	`, profileID, myID)
+	if err != nil {
+		log.Println("GetUserProfile (groups) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for groupRows.Next() {
+		var g Group
+		g.IsMember = true
+		if err := groupRows.Scan(&g.ID, &g.Name, &g.Description, &g.ProfileImageURL, &g.CreatedByUserID, &g.Privacy, &g.MemberCount); err == nil {
+			p.SharedGroups = append(p.SharedGroups, g)
+		}
+	}
+	groupRows.Close()
+
+	today := time.Now().Format("2006-01-02")
+	registeredQuery := `
+		SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, e.start_time, e.end_time,
+		       e.created_by_user_id, u.email, u.name, u.profile_image_url
+		FROM events e
+		JOIN users u ON e.created_by_user_id = u.id
+		JOIN registrations r ON e.id = r.event_id
+		WHERE r.user_id = ? AND e.date %s ? AND ` + eventVisibleClause("e") + `
+		ORDER BY e.date %s
+		LIMIT %d
+	`
+	if privacy.canSeeRegistrations(profileID) {
+		p.UpcomingEvents, err = queryEvents(fmt.Sprintf(registeredQuery, ">=", "ASC", -1), profileID, today, myID, myID, myID)
+		if err == nil {
+			p.PastEvents, err = queryEvents(fmt.Sprintf(registeredQuery, "<", "DESC", profilePastEventsLimit), profileID, today, myID, myID, myID)
+		}
+	}
+	if err == nil && p.Role == "Organizer" {
+		p.HostedEvents, err = queryEvents(`
+			SELECT e.id, e.name, e.date, e.description, e.location_address, e.image_url, e.start_time, e.end_time,
+			       e.created_by_user_id, u.email, u.name, u.profile_image_url
+			FROM events e
+			JOIN users u ON e.created_by_user_id = u.id
+			WHERE e.created_by_user_id = ? AND `+eventVisibleClause("e")+`
+			ORDER BY e.date DESC
+		`, profileID, myID, myID, myID)
+	}
+	if err == nil {
+		for _, events := range [][]Event{p.UpcomingEvents, p.PastEvents, p.HostedEvents} {
+			if err = attachHostGroups(events); err != nil {
+				break
+			}
+			if err = redactEvents(myID, role, events); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Println("GetUserProfile (events) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
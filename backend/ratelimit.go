@@ -0,0 +1,248 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimiter is a fixed-window counter keyed by client IP or user. It lives in
+// memory, so each server instance enforces its own limits.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+	calls   int
+}
+
+type rateBucket struct {
+	count   int
+	resetAt time.Time
+}
+
+// rateDecision describes one request's standing against a limiter.
+type rateDecision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+	Window    time.Duration
+}
+
+// Login throttling settings, read once at startup.
+type loginThrottleConfig struct {
+	BackoffAfter     int
+	BackoffBase      time.Duration
+	BackoffMax       time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+var (
+	loginLimiter    *rateLimiter
+	registerLimiter *rateLimiter
+	writeLimiter    *rateLimiter
+	loginThrottle   loginThrottleConfig
+)
+
+// initRateLimits reads limits from the environment. Windows use Go duration syntax.
+func initRateLimits() {
+	loginLimiter = newRateLimiter(getEnvInt("RATE_LIMIT_LOGIN_PER_IP", 20), getEnvDuration("RATE_LIMIT_LOGIN_WINDOW", 15*time.Minute))
+	registerLimiter = newRateLimiter(getEnvInt("RATE_LIMIT_REGISTER_PER_IP", 5), getEnvDuration("RATE_LIMIT_REGISTER_WINDOW", time.Hour))
+	writeLimiter = newRateLimiter(getEnvInt("RATE_LIMIT_WRITES_PER_USER", 60), getEnvDuration("RATE_LIMIT_WRITES_WINDOW", time.Minute))
+	loginThrottle = loginThrottleConfig{
+		BackoffAfter:     getEnvInt("LOGIN_BACKOFF_AFTER_FAILURES", 3),
+		BackoffBase:      getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		BackoffMax:       getEnvDuration("LOGIN_BACKOFF_MAX", 5*time.Minute),
+		LockoutThreshold: getEnvInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+		LockoutDuration:  getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+	}
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, buckets: make(map[string]*rateBucket)}
+}
+
+func (l *rateLimiter) take(key string) rateDecision {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	if l.calls%1000 == 0 {
+		for k, b := range l.buckets {
+			if now.After(b.resetAt) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	b, ok := l.buckets[key]
+	if !ok || now.After(b.resetAt) {
+		b = &rateBucket{resetAt: now.Add(l.window)}
+		l.buckets[key] = b
+	}
+	decision := rateDecision{Limit: l.limit, Reset: b.resetAt.Sub(now), Window: l.window}
+	if b.count >= l.limit {
+		return decision
+	}
+	b.count++
+	decision.Allowed = true
+	decision.Remaining = l.limit - b.count
+	return decision
+}
+
+func setRateLimitHeaders(c *gin.Context, d rateDecision) {
+	c.Header("RateLimit-Limit", strconv.Itoa(d.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", d.Limit, ceilSeconds(d.Window)))
+}
+
+func abortTooManyRequests(c *gin.Context, retryAfter time.Duration, message string) {
+	c.Header("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": message, "retryAfter": ceilSeconds(retryAfter)})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// --- Middleware ---
+
+// RateLimitByIP guards public endpoints such as login and sign-up.
+func RateLimitByIP(limiter *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := limiter.take(c.FullPath() + "|" + c.ClientIP())
+		setRateLimitHeaders(c, d)
+		if !d.Allowed {
+			abortTooManyRequests(c, d.Reset, "Too many requests. Please slow down.")
+			return
+		}
+		c.Next()
+	}
+}
+
+// RateLimitWrites limits state-changing requests per signed-in user. Reads pass through.
+func RateLimitWrites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		d := writeLimiter.take(fmt.Sprintf("user:%d", c.GetInt("userID")))
+		setRateLimitHeaders(c, d)
+		if !d.Allowed {
+			abortTooManyRequests(c, d.Reset, "Too many requests. Please slow down.")
+			return
+		}
+		c.Next()
+	}
+}
+
+// --- Account Lockout ---
+
+// loginAccountKey normalises the email so "Bob@x.com" and "bob@x.com " share a counter.
+func loginAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLoginThrottle reports how long the account must wait before another attempt.
+// Backoff doubles with each failure past the free allowance; crossing the lockout
+// threshold locks the account outright.
+func checkLoginThrottle(account string) (time.Duration, bool, error) {
+	var failures int
+	var lastFailure time.Time
+	var lockedUntil sql.NullTime
+	err := db.QueryRow(`SELECT failures, last_failure_at, locked_until FROM login_attempts WHERE account = ?`, account).Scan(&failures, &lastFailure, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	now := time.Now()
+	if lockedUntil.Valid && now.Before(lockedUntil.Time) {
+		return lockedUntil.Time.Sub(now), true, nil
+	}
+	if failures < loginThrottle.BackoffAfter {
+		return 0, false, nil
+	}
+	backoff := loginThrottle.BackoffBase * time.Duration(1<<uint(minInt(failures-loginThrottle.BackoffAfter, 20)))
+	if backoff > loginThrottle.BackoffMax {
+		backoff = loginThrottle.BackoffMax
+	}
+	if wait := lastFailure.Add(backoff).Sub(now); wait > 0 {
+		return wait, false, nil
+	}
+	return 0, false, nil
+}
+
+func recordLoginFailure(account string) {
+	now := time.Now()
+	_, err := db.Exec(`
+		INSERT INTO login_attempts (account, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT(account) DO UPDATE SET failures = failures + 1, last_failure_at = excluded.last_failure_at
+	`, account, sqlTime(now))
+	if err != nil {
+		log.Println("recordLoginFailure error:", err)
+		return
+	}
+	_, err = db.Exec(`UPDATE login_attempts SET locked_until = ? WHERE account = ? AND failures >= ? AND failures % ? = 0`,
+		sqlTime(now.Add(loginThrottle.LockoutDuration)), account, loginThrottle.LockoutThreshold, loginThrottle.LockoutThreshold)
+	if err != nil {
+		log.Println("recordLoginFailure (lockout) error:", err)
+	}
+}
+
+func clearLoginFailures(account string) {
+	if _, err := db.Exec(`DELETE FROM login_attempts WHERE account = ?`, account); err != nil {
+		log.Println("clearLoginFailures error:", err)
+	}
+}
+
+// pruneLoginAttempts forgets failures that have gone quiet, including those
+// against emails with no account, which a successful login never clears.
+func pruneLoginAttempts() error {
+	now := time.Now()
+	_, err := db.Exec(`
+		DELETE FROM login_attempts
+		WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)
+	`, sqlTime(now.Add(-getEnvDuration("LOGIN_ATTEMPT_RETENTION", 24*time.Hour))), sqlTime(now))
+	return err
+}
+
+// rejectIfThrottled writes a 429 and returns true when the account may not try yet.
+func rejectIfThrottled(c *gin.Context, account string) bool {
+	wait, locked, err := checkLoginThrottle(account)
+	if err != nil {
+		log.Println("Login throttle error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+	if locked {
+		abortTooManyRequests(c, wait, "Account temporarily locked after too many failed attempts.")
+	} else {
+		abortTooManyRequests(c, wait, "Too many failed attempts. Please wait before trying again.")
+	}
+	return true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
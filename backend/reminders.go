@@ -0,0 +1,152 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventLocation is the timezone event dates and times are given in
+// (EVENT_TIMEZONE, the server's own by default).
+var eventLocation = time.Local
+
+// reminderOffsets are how long before an event its registrants are reminded,
+// largest first (EVENT_REMINDER_OFFSETS, e.g. "48h,2h").
+var reminderOffsets []time.Duration
+
+func initEventReminders() {
+	if tz := getEnv("EVENT_TIMEZONE", ""); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			log.Fatalf("Invalid EVENT_TIMEZONE %q: %v", tz, err)
+		}
+		eventLocation = loc
+	}
+	reminderOffsets = nil
+	for _, s := range strings.Split(getEnv("EVENT_REMINDER_OFFSETS", "48h,2h"), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < time.Minute {
+			log.Fatalf("Invalid reminder offset %q in EVENT_REMINDER_OFFSETS", s)
+		}
+		reminderOffsets = append(reminderOffsets, d)
+	}
+	sort.Slice(reminderOffsets, func(i, j int) bool { return reminderOffsets[i] > reminderOffsets[j] })
+}
+
+// eventStart is when an event begins. Events without a start time count from
+// the start of their day.
+func eventStart(date, startTime string) (time.Time, error) {
+	if startTime == "" {
+		return time.ParseInLocation(dateLayout, date, eventLocation)
+	}
+	return time.ParseInLocation(dateLayout+" "+clockLayout, date+" "+startTime, eventLocation)
+}
+
+// sendEventReminders notifies registrants of events that start within one of
+// the reminder offsets. Each reminder is claimed in event_reminders_sent before
+// it is sent, so restarts and other instances sharing the database never send
+// it twice. Someone who registers late gets a single reminder straight away
+// rather than one for every offset already passed.
+func sendEventReminders() error {
+	if len(reminderOffsets) == 0 {
+		return nil
+	}
+	now := time.Now().In(eventLocation)
+	horizon := now.Add(reminderOffsets[0])
+	// Every registrant gets the same payload, so the organizer's email is only
+	// included when they show it to everyone.
+	rows, err := db.Query(`
+		SELECT e.id, e.date, e.start_time, COALESCE(e.location_address, ''), COALESCE(u.name, ''),
+		       CASE WHEN COALESCE(p.email_visibility, ?) = ? THEN COALESCE(u.email, '') ELSE '' END
+		FROM events e
+		LEFT JOIN users u ON u.id = e.created_by_user_id AND u.deleted_at IS NULL
+		LEFT JOIN user_privacy p ON p.user_id = u.id
+		WHERE e.date >= ? AND e.date <= ?
+	`, defaultPrivacySettings.EmailVisibility, audienceEveryone, now.Format(dateLayout), horizon.Format(dateLayout))
+	if err != nil {
+		return err
+	}
+	type upcoming struct {
+		id                            int
+		date, startTime, location     string
+		organizerName, organizerEmail string
+	}
+	var events []upcoming
+	for rows.Next() {
+		var e upcoming
+		if err := rows.Scan(&e.id, &e.date, &e.startTime, &e.location, &e.organizerName, &e.organizerEmail); err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+
+	for _, e := range events {
+		start, err := eventStart(e.date, e.startTime)
+		if err != nil || !start.After(now) {
+			continue
+		}
+		var due []int
+		for _, offset := range reminderOffsets {
+			if !now.Before(start.Add(-offset)) {
+				due = append(due, int(offset/time.Minute))
+			}
+		}
+		if len(due) == 0 {
+			continue
+		}
+		payload := gin.H{
+			"eventId":  e.id,
+			"startsAt": start.Format(time.RFC3339),
+			"location": e.location,
+			"organizer": gin.H{
+				"name":  e.organizerName,
+				"email": e.organizerEmail,
+			},
+		}
+		if err := remindRegistrants(e.id, start, due, payload); err != nil {
+			log.Printf("Reminders for event %d failed: %v", e.id, err)
+		}
+	}
+	return nil
+}
+
+// remindRegistrants claims the due offsets for everyone registered and notifies
+// whoever had at least one claim that wasn't taken yet.
+func remindRegistrants(eventID int, start time.Time, offsets []int, payload gin.H) error {
+	claim, err := randomURLToken(16)
+	if err != nil {
+		return err
+	}
+	startsAt := sqlTime(start)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, offset := range offsets {
+		_, err := tx.Exec(`
+			INSERT OR IGNORE INTO event_reminders_sent (event_id, user_id, starts_at, offset_minutes, claim, sent_at)
+			SELECT r.event_id, r.user_id, ?, ?, ?, ?
+			FROM registrations r JOIN users u ON u.id = r.user_id
+			WHERE r.event_id = ? AND u.deleted_at IS NULL
+		`, startsAt, offset, claim, sqlTime(time.Now()), eventID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	err = insertNotifications(tx, `SELECT user_id FROM event_reminders_sent WHERE event_id = ? AND starts_at = ? AND claim = ?`,
+		[]interface{}{eventID, startsAt, claim}, notifEventReminder, 0, payload, defaultChannel(notifEventReminder))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
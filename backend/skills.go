@@ -0,0 +1,439 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var proficiencyLevels = []string{"beginner", "intermediate", "advanced", "expert"}
+
+const defaultProficiency = "beginner"
+
+// CatalogSkill is an entry in the canonical skill list.
+type CatalogSkill struct {
+	ID                   int      `json:"id"`
+	Name                 string   `json:"name"`
+	Category             string   `json:"category"`
+	RequiresVerification bool     `json:"requiresVerification"`
+	Synonyms             []string `json:"synonyms,omitempty"`
+}
+
+// UserSkill is a catalog skill as held by one user.
+type UserSkill struct {
+	CatalogSkill
+	Proficiency           string     `json:"proficiency"`
+	Verified              bool       `json:"verified"` // verified and not yet expired
+	VerifiedBy            *int       `json:"verifiedBy,omitempty"`
+	VerifiedAt            *time.Time `json:"verifiedAt,omitempty"`
+	VerificationExpiresAt *time.Time `json:"verificationExpiresAt,omitempty"`
+}
+
+// SkillSelection is one entry of SkillsPayload. A bare string is accepted too, so
+// older clients that send ["First Aid"] keep working.
+type SkillSelection struct {
+	Skill       string `json:"skill"`
+	SkillID     int    `json:"skillId"`
+	Proficiency string `json:"proficiency"`
+}
+
+func (s *SkillSelection) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*s = SkillSelection{Skill: name}
+		return nil
+	}
+	type plain SkillSelection
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+// defaultSkillCatalog seeds the catalog on first run. Synonyms catch the spellings
+// people actually typed into the old free-text field.
+var defaultSkillCatalog = []struct {
+	Name, Category       string
+	RequiresVerification bool
+	Synonyms             []string
+}{
+	{"First Aid", "Medical", true, []string{"firstaid", "first-aid", "CPR/First aid", "first aid/cpr"}},
+	{"CPR", "Medical", true, []string{"cardiopulmonary resuscitation"}},
+	{"Nursing", "Medical", true, []string{"nurse", "rn"}},
+	{"Driving", "Driving", true, []string{"driver", "drivers license", "driver's license", "car"}},
+	{"Commercial Driving", "Driving", true, []string{"cdl", "truck driving", "van driving"}},
+	{"Food Handling", "Food", true, []string{"food safety", "food hygiene", "food handler"}},
+	{"Cooking", "Food", false, []string{"cook", "chef", "baking"}},
+	{"Event Planning", "Events", false, []string{"event management", "planning"}},
+	{"Event Setup", "Events", false, []string{"setup", "set up"}},
+	{"Fundraising", "Outreach", false, []string{"fund raising", "donor outreach"}},
+	{"Public Speaking", "Outreach", false, []string{"speaking", "presenting"}},
+	{"Social Media", "Communications", false, []string{"social media management", "instagram", "facebook"}},
+	{"Photography", "Communications", false, []string{"photo", "photos", "photographer"}},
+	{"Graphic Design", "Communications", false, []string{"design", "graphics"}},
+	{"Translation", "Communications", false, []string{"translating", "interpreting", "interpreter"}},
+	{"Teaching", "Education", false, []string{"teacher", "education"}},
+	{"Tutoring", "Education", false, []string{"tutor", "homework help"}},
+	{"Childcare", "Care", true, []string{"child care", "babysitting"}},
+	{"Elderly Care", "Care", false, []string{"senior care", "eldercare"}},
+	{"Data Entry", "Office", false, []string{"typing"}},
+	{"IT Support", "Office", false, []string{"tech support", "computers"}},
+	{"Manual Labor", "Practical", false, []string{"manual labour", "heavy lifting", "labor"}},
+	{"Carpentry", "Practical", false, []string{"woodwork", "woodworking"}},
+	{"Gardening", "Practical", false, []string{"landscaping", "planting"}},
+	{"Logistics", "Practical", false, []string{"warehouse", "inventory"}},
+}
+
+// seedSkillCatalog fills an empty catalog. Admins maintain it from then on.
+func seedSkillCatalog() {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM skills`).Scan(&count); err != nil || count > 0 {
+		return
+	}
+	for _, s := range defaultSkillCatalog {
+		res, err := db.Exec(`INSERT INTO skills (name, category, requires_verification) VALUES (?, ?, ?)`, s.Name, s.Category, s.RequiresVerification)
+		if err != nil {
+			log.Fatalf("Failed to seed skill %q: %v", s.Name, err)
+		}
+		id, _ := res.LastInsertId()
+		for _, synonym := range s.Synonyms {
+			db.Exec(`INSERT OR IGNORE INTO skill_synonyms (synonym, skill_id) VALUES (?, ?)`, normalizeSkillName(synonym), id)
+		}
+	}
+}
+
+// migrateFreeTextSkills attaches old free-text user skills to catalog entries.
+// Text that matches nothing becomes a new catalog skill under "Other" rather than
+// being dropped; duplicates that collapse onto the same skill are removed.
+func migrateFreeTextSkills() {
+	rows, err := db.Query(`SELECT user_id, skill FROM user_skills WHERE skill_id IS NULL`)
+	if err != nil {
+		log.Fatal("Failed to read skills for migration:", err)
+	}
+	type legacySkill struct {
+		userID int
+		text   string
+	}
+	var legacy []legacySkill
+	for rows.Next() {
+		var l legacySkill
+		if err := rows.Scan(&l.userID, &l.text); err == nil {
+			legacy = append(legacy, l)
+		}
+	}
+	rows.Close()
+	for _, l := range legacy {
+		skill, err := resolveSkill(l.text, 0)
+		if err == sql.ErrNoRows {
+			res, insertErr := db.Exec(`INSERT INTO skills (name, category) VALUES (?, 'Other')`, strings.TrimSpace(l.text))
+			if insertErr != nil {
+				log.Printf("Skill migration: could not add %q: %v", l.text, insertErr)
+				continue
+			}
+			id, _ := res.LastInsertId()
+			skill, err = &CatalogSkill{ID: int(id), Name: strings.TrimSpace(l.text), Category: "Other"}, nil
+		}
+		if err != nil {
+			log.Printf("Skill migration: could not resolve %q: %v", l.text, err)
+			continue
+		}
+		var dup int
+		db.QueryRow(`SELECT COUNT(*) FROM user_skills WHERE user_id = ? AND (skill_id = ? OR skill = ?) AND skill != ?`, l.userID, skill.ID, skill.Name, l.text).Scan(&dup)
+		if dup > 0 {
+			// Another row already holds this skill; it is (or will be) the migrated one.
+			_, err = db.Exec(`DELETE FROM user_skills WHERE user_id = ? AND skill = ?`, l.userID, l.text)
+		} else {
+			_, err = db.Exec(`UPDATE user_skills SET skill = ?, skill_id = ? WHERE user_id = ? AND skill = ?`, skill.Name, skill.ID, l.userID, l.text)
+		}
+		if err != nil {
+			log.Printf("Skill migration: could not migrate %q for user %d: %v", l.text, l.userID, err)
+		}
+	}
+}
+
+// normalizeSkillName folds case and spacing so "  first  AID" matches "First Aid".
+func normalizeSkillName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// resolveSkill finds a catalog skill by id, or by name or synonym.
+func resolveSkill(name string, id int) (*CatalogSkill, error) {
+	var s CatalogSkill
+	var err error
+	if id > 0 {
+		err = db.QueryRow(`SELECT id, name, category, requires_verification FROM skills WHERE id = ?`, id).
+			Scan(&s.ID, &s.Name, &s.Category, &s.RequiresVerification)
+		return &s, err
+	}
+	normalized := normalizeSkillName(name)
+	err = db.QueryRow(`
+		SELECT id, name, category, requires_verification FROM skills WHERE lower(name) = ?
+		UNION ALL
+		SELECT s.id, s.name, s.category, s.requires_verification
+		FROM skill_synonyms ss JOIN skills s ON s.id = ss.skill_id
+		WHERE ss.synonym = ?
+		LIMIT 1
+	`, normalized, normalized).Scan(&s.ID, &s.Name, &s.Category, &s.RequiresVerification)
+	return &s, err
+}
+
+// loadUserSkills returns each user's skills with proficiency and verification.
+func loadUserSkills(ids []int) (map[int][]UserSkill, error) {
+	result := make(map[int][]UserSkill, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		result[id] = []UserSkill{}
+		args[i] = id
+	}
+	rows, err := db.Query(`
+		SELECT us.user_id, s.id, s.name, s.category, s.requires_verification,
+		       us.proficiency, us.verified_by, us.verified_at, us.verification_expires_at
+		FROM user_skills us
+		JOIN skills s ON s.id = us.skill_id
+		WHERE us.user_id IN (?`+strings.Repeat(",?", len(ids)-1)+`)
+		ORDER BY s.name
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := time.Now()
+	for rows.Next() {
+		var userID int
+		var us UserSkill
+		var verifiedBy sql.NullInt64
+		var verifiedAt, expiresAt sql.NullTime
+		if err := rows.Scan(&userID, &us.ID, &us.Name, &us.Category, &us.RequiresVerification,
+			&us.Proficiency, &verifiedBy, &verifiedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		if verifiedAt.Valid {
+			by := int(verifiedBy.Int64)
+			us.VerifiedBy = &by
+			us.VerifiedAt = &verifiedAt.Time
+			us.Verified = true
+		}
+		if expiresAt.Valid {
+			us.VerificationExpiresAt = &expiresAt.Time
+			us.Verified = us.Verified && now.Before(expiresAt.Time)
+		}
+		result[userID] = append(result[userID], us)
+	}
+	return result, rows.Err()
+}
+
+// --- Skill Catalog Handlers ---
+
+// SearchSkillsHandler powers autocomplete. Without ?q= it returns the whole catalog.
+func SearchSkillsHandler(c *gin.Context) {
+	q := normalizeSkillName(c.Query("q"))
+	query := `
+		SELECT id, name, category, requires_verification FROM skills
+		ORDER BY category, name
+	`
+	var args []interface{}
+	if q != "" {
+		// Prefix matches on the name rank first, then anything else matching a name or synonym.
+		query = `
+			SELECT id, name, category, requires_verification FROM skills s
+			WHERE lower(s.name) LIKE ? OR s.id IN (SELECT skill_id FROM skill_synonyms WHERE synonym LIKE ?)
+			ORDER BY CASE WHEN lower(s.name) LIKE ? THEN 0 ELSE 1 END, s.name
+			LIMIT 10
+		`
+		args = append(args, "%"+q+"%", "%"+q+"%", q+"%")
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Println("SearchSkills error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	skills := []CatalogSkill{}
+	for rows.Next() {
+		var s CatalogSkill
+		if err := rows.Scan(&s.ID, &s.Name, &s.Category, &s.RequiresVerification); err != nil {
+			log.Println("SearchSkills scan error:", err)
+			continue
+		}
+		skills = append(skills, s)
+	}
+	c.JSON(http.StatusOK, gin.H{"skills": skills, "proficiencyLevels": proficiencyLevels})
+}
+
+// CreateSkillHandler adds a catalog skill (admins only).
+func CreateSkillHandler(c *gin.Context) {
+	if c.GetString("role") != "Admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+	var payload CatalogSkill
+	if err := c.ShouldBindJSON(&payload); err != nil || strings.TrimSpace(payload.Name) == "" || strings.TrimSpace(payload.Category) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and category are required"})
+		return
+	}
+	if _, err := resolveSkill(payload.Name, 0); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Skill already exists"})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("CreateSkill (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	res, err := tx.Exec(`INSERT INTO skills (name, category, requires_verification) VALUES (?, ?, ?)`,
+		strings.Join(strings.Fields(payload.Name), " "), strings.TrimSpace(payload.Category), payload.RequiresVerification)
+	if err != nil {
+		tx.Rollback()
+		log.Println("CreateSkill error:", err)
+		c.JSON(http.StatusConflict, gin.H{"error": "Skill already exists"})
+		return
+	}
+	id, _ := res.LastInsertId()
+	for _, synonym := range payload.Synonyms {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO skill_synonyms (synonym, skill_id) VALUES (?, ?)`, normalizeSkillName(synonym), id); err != nil {
+			tx.Rollback()
+			log.Println("CreateSkill (synonym) error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("CreateSkill (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	payload.ID = int(id)
+	c.JSON(http.StatusCreated, payload)
+}
+
+// --- Skill Verification Handlers ---
+
+// canVerifyVolunteer lets admins verify anyone, and organizers verify volunteers
+// registered for one of their events.
+func canVerifyVolunteer(verifierID int, role string, userID int) bool {
+	if role == "Admin" {
+		return true
+	}
+	if role != "Organizer" {
+		return false
+	}
+	var n int
+	db.QueryRow(`
+		SELECT COUNT(*) FROM registrations r JOIN events e ON e.id = r.event_id
+		WHERE r.user_id = ? AND e.created_by_user_id = ?
+	`, userID, verifierID).Scan(&n)
+	return n > 0
+}
+
+func parseUserSkillParams(c *gin.Context) (int, int, bool) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, 0, false
+	}
+	skillID, err := strconv.Atoi(c.Param("skillId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid skill ID"})
+		return 0, 0, false
+	}
+	return userID, skillID, true
+}
+
+// VerifySkillHandler records that an organizer or admin checked a user's skill.
+// Verification lapses on expiresAt (YYYY-MM-DD).
+func VerifySkillHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	userID, skillID, ok := parseUserSkillParams(c)
+	if !ok {
+		return
+	}
+	var payload struct {
+		ExpiresAt string `json:"expiresAt"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An expiry date is required"})
+		return
+	}
+	expires, err := time.Parse(dateLayout, payload.ExpiresAt)
+	if err != nil || !expires.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be a future date (YYYY-MM-DD)"})
+		return
+	}
+	if !canVerifyVolunteer(myID, c.GetString("role"), userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot verify this user's skills"})
+		return
+	}
+	res, err := db.Exec(`
+		UPDATE user_skills SET verified_by = ?, verified_at = ?, verification_expires_at = ?
+		WHERE user_id = ? AND skill_id = ?
+	`, myID, sqlTime(time.Now()), sqlTime(expires), userID, skillID)
+	if err != nil {
+		log.Println("VerifySkill error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User does not have this skill"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Skill verified"})
+}
+
+func RevokeSkillVerificationHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	userID, skillID, ok := parseUserSkillParams(c)
+	if !ok {
+		return
+	}
+	if !canVerifyVolunteer(myID, c.GetString("role"), userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot verify this user's skills"})
+		return
+	}
+	_, err := db.Exec(`
+		UPDATE user_skills SET verified_by = NULL, verified_at = NULL, verification_expires_at = NULL
+		WHERE user_id = ? AND skill_id = ?
+	`, userID, skillID)
+	if err != nil {
+		log.Println("RevokeSkillVerification error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Verification removed"})
+}
+
+// validateSkillSelections resolves each selection against the catalog.
+func validateSkillSelections(selections []SkillSelection) ([]UserSkill, error) {
+	seen := make(map[int]bool)
+	var resolved []UserSkill
+	for _, sel := range selections {
+		skill, err := resolveSkill(sel.Skill, sel.SkillID)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("Unknown skill: %s", sel.Skill)
+		}
+		if err != nil {
+			return nil, err
+		}
+		proficiency := strings.ToLower(strings.TrimSpace(sel.Proficiency))
+		if proficiency == "" {
+			proficiency = defaultProficiency
+		}
+		if !containsString(proficiencyLevels, proficiency) {
+			return nil, fmt.Errorf("Proficiency must be one of: %s", strings.Join(proficiencyLevels, ", "))
+		}
+		if seen[skill.ID] {
+			continue
+		}
+		seen[skill.ID] = true
+		resolved = append(resolved, UserSkill{CatalogSkill: *skill, Proficiency: proficiency})
+	}
+	return resolved, nil
+}
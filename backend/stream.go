@@ -0,0 +1,431 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamEvent is one message on the live stream. Events addressed to a user
+// carry UserID; shared ones carry a topic such as "group:12" or "event:7".
+type StreamEvent struct {
+	ID     int64
+	UserID int
+	Topic  string
+	Type   string
+	Data   string // JSON
+}
+
+// streamTriggers write stream events as the underlying rows change, so every
+// code path that adds a notification, post or registration is covered, bulk
+// inserts included. Rows only become visible to the hub once committed. Each
+// trigger is replaced on every start, so changes reach existing databases.
+var streamTriggers = []string{
+	`DROP TRIGGER IF EXISTS stream_notification;
+	CREATE TRIGGER stream_notification AFTER INSERT ON notifications BEGIN
+		INSERT INTO stream_events (user_id, type, data)
+		VALUES (NEW.user_id, 'notification', json_object('id', NEW.id, 'type', NEW.type, 'actorId', NEW.actor_id, 'payload', json(NEW.payload),
+			'unreadCount', ( SELECT COUNT(*) FROM notifications n WHERE n.user_id = NEW.user_id AND n.read_at IS NULL AND ` + notificationLiveClause + ` )));
+	END`,
+	`DROP TRIGGER IF EXISTS stream_post_created;
+	CREATE TRIGGER stream_post_created AFTER INSERT ON group_posts BEGIN
+		INSERT INTO stream_events (topic, type, data)
+		VALUES ('group:' || NEW.group_id, 'post', json_object('groupId', NEW.group_id, 'postId', NEW.id, 'kind', NEW.kind));
+	END`,
+	`DROP TRIGGER IF EXISTS stream_post_updated;
+	CREATE TRIGGER stream_post_updated AFTER UPDATE OF pinned, locked ON group_posts BEGIN
+		INSERT INTO stream_events (topic, type, data)
+		VALUES ('group:' || NEW.group_id, 'post_updated', json_object('groupId', NEW.group_id, 'postId', NEW.id, 'pinned', json(CASE WHEN NEW.pinned THEN 'true' ELSE 'false' END), 'locked', json(CASE WHEN NEW.locked THEN 'true' ELSE 'false' END)));
+	END`,
+	`DROP TRIGGER IF EXISTS stream_post_deleted;
+	CREATE TRIGGER stream_post_deleted AFTER DELETE ON group_posts BEGIN
+		INSERT INTO stream_events (topic, type, data)
+		VALUES ('group:' || OLD.group_id, 'post_deleted', json_object('groupId', OLD.group_id, 'postId', OLD.id));
+	END`,
+	`DROP TRIGGER IF EXISTS stream_comment_created;
+	CREATE TRIGGER stream_comment_created AFTER INSERT ON group_post_comments BEGIN
+		INSERT INTO stream_events (topic, type, data)
+		SELECT 'group:' || p.group_id, 'comment', json_object('groupId', p.group_id, 'postId', p.id, 'commentId', NEW.id)
+		FROM group_posts p WHERE p.id = NEW.post_id;
+	END`,
+	`DROP TRIGGER IF EXISTS stream_comment_deleted;
+	CREATE TRIGGER stream_comment_deleted AFTER DELETE ON group_post_comments BEGIN
+		INSERT INTO stream_events (topic, type, data)
+		SELECT 'group:' || p.group_id, 'comment_deleted', json_object('groupId', p.group_id, 'postId', p.id, 'commentId', OLD.id)
+		FROM group_posts p WHERE p.id = OLD.post_id;
+	END`,
+	`DROP TRIGGER IF EXISTS stream_reaction_added;
+	CREATE TRIGGER stream_reaction_added AFTER INSERT ON group_post_reactions BEGIN
+		INSERT INTO stream_events (topic, type, data)
+		SELECT 'group:' || p.group_id, 'reactions', json_object('groupId', p.group_id, 'postId', p.id,
+		       'counts', ( SELECT json_group_object(reaction, n) FROM ( SELECT reaction, COUNT(*) AS n FROM group_post_reactions WHERE post_id = p.id GROUP BY reaction ) ))
+		FROM group_posts p WHERE p.id = NEW.post_id;
+	END`,
+	`DROP TRIGGER IF EXISTS stream_reaction_removed;
+	CREATE TRIGGER stream_reaction_removed AFTER DELETE ON group_post_reactions BEGIN
+		INSERT INTO stream_events (topic, type, data)
+		SELECT 'group:' || p.group_id, 'reactions', json_object('groupId', p.group_id, 'postId', p.id,
+		       'counts', ( SELECT json_group_object(reaction, n) FROM ( SELECT reaction, COUNT(*) AS n FROM group_post_reactions WHERE post_id = p.id GROUP BY reaction ) ))
+		FROM group_posts p WHERE p.id = OLD.post_id;
+	END`,
+	`DROP TRIGGER IF EXISTS stream_registration_added;
+	CREATE TRIGGER stream_registration_added AFTER INSERT ON registrations BEGIN
+		INSERT INTO stream_events (topic, type, data)
+		VALUES ('event:' || NEW.event_id, 'registrations', json_object('eventId', NEW.event_id, 'count', ( SELECT COUNT(*) FROM registrations WHERE event_id = NEW.event_id )));
+	END`,
+	`DROP TRIGGER IF EXISTS stream_registration_removed;
+	CREATE TRIGGER stream_registration_removed AFTER DELETE ON registrations BEGIN
+		INSERT INTO stream_events (topic, type, data)
+		VALUES ('event:' || OLD.event_id, 'registrations', json_object('eventId', OLD.event_id, 'count', ( SELECT COUNT(*) FROM registrations WHERE event_id = OLD.event_id )));
+	END`,
+	`DROP TRIGGER IF EXISTS stream_message;
+	CREATE TRIGGER stream_message AFTER INSERT ON messages BEGIN
+		INSERT INTO stream_events (user_id, type, data)
+		SELECT cp.user_id, 'message', json_object('conversationId', NEW.conversation_id, 'messageId', NEW.id, 'senderId', NEW.sender_id)
+		FROM conversation_participants cp
+		WHERE cp.conversation_id = NEW.conversation_id AND cp.left_at IS NULL
+		  AND NOT EXISTS ( SELECT 1 FROM user_blocks b WHERE (b.blocker_id = cp.user_id AND b.blocked_id = NEW.sender_id)
+		                   OR (b.blocker_id = NEW.sender_id AND b.blocked_id = cp.user_id) );
+	END`,
+}
+
+// streamUnreadCounts queues an "unread_count" event for each user picked by
+// users, a query selecting a user_id column. Read-state changes go through here
+// rather than a trigger so marking many notifications read sends one event.
+func streamUnreadCounts(ex sqlExecer, users string, args ...interface{}) error {
+	_, err := ex.Exec(`
+		INSERT INTO stream_events (user_id, type, data)
+		SELECT DISTINCT r.user_id, 'unread_count', json_object('unreadCount', (
+			SELECT COUNT(*) FROM notifications n WHERE n.user_id = r.user_id AND n.read_at IS NULL AND `+notificationLiveClause+`
+		))
+		FROM ( `+users+` ) r
+	`, args...)
+	return err
+}
+
+// --- Broker ---
+
+// streamBroker brings events to this instance's hub. Implementations must deliver
+// events in id order and carry on after since.
+type streamBroker interface {
+	Run(since int64, deliver func(StreamEvent))
+}
+
+// pollBroker reads new rows from stream_events. Every instance that shares the
+// database sees every event, so nothing else is needed to run several servers.
+type pollBroker struct {
+	interval time.Duration
+}
+
+func (b *pollBroker) Run(since int64, deliver func(StreamEvent)) {
+	for {
+		events, err := queryStreamEvents(`id > ?`, 1000, since)
+		if err != nil {
+			log.Println("Stream poll error:", err)
+		}
+		for _, ev := range events {
+			deliver(ev)
+			since = ev.ID
+		}
+		if len(events) < 1000 {
+			time.Sleep(b.interval)
+		}
+	}
+}
+
+// newStreamBroker picks the broker named by STREAM_BROKER.
+func newStreamBroker() streamBroker {
+	switch name := getEnv("STREAM_BROKER", "poll"); name {
+	case "poll":
+		return &pollBroker{interval: getEnvDuration("STREAM_POLL_INTERVAL", time.Second)}
+	default:
+		log.Fatalf("Unknown STREAM_BROKER %q", name)
+		return nil
+	}
+}
+
+func queryStreamEvents(where string, limit int, args ...interface{}) ([]StreamEvent, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, topic, type, data FROM stream_events
+		WHERE `+where+`
+		ORDER BY id
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []StreamEvent{}
+	for rows.Next() {
+		var ev StreamEvent
+		var userID sql.NullInt64
+		var topic sql.NullString
+		if err := rows.Scan(&ev.ID, &userID, &topic, &ev.Type, &ev.Data); err != nil {
+			return nil, err
+		}
+		ev.UserID = int(userID.Int64)
+		ev.Topic = topic.String
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// --- Hub ---
+
+type streamSubscriber struct {
+	userID int
+	events map[int]bool // event ids whose registration counts were asked for
+	ch     chan StreamEvent
+	done   chan struct{} // closed when the subscriber goes; ch itself never is
+}
+
+// wants reports whether the event is for this subscriber. members is who is in
+// the group of a group topic, from streamGroupMembers, so leaving a group stops
+// its feed.
+func (s *streamSubscriber) wants(ev StreamEvent, members map[int]bool) bool {
+	if ev.UserID != 0 {
+		return ev.UserID == s.userID
+	}
+	kind, id, _ := strings.Cut(ev.Topic, ":")
+	targetID, _ := strconv.Atoi(id)
+	switch kind {
+	case "group":
+		return members[s.userID]
+	case "event":
+		return s.events[targetID]
+	}
+	return false
+}
+
+// streamGroupMembers looks up the current members of the group an event is
+// about, once per event rather than once per subscriber. Other events get nil.
+func streamGroupMembers(ev StreamEvent) map[int]bool {
+	kind, id, _ := strings.Cut(ev.Topic, ":")
+	if ev.UserID != 0 || kind != "group" {
+		return nil
+	}
+	members := map[int]bool{}
+	rows, err := db.Query(`SELECT user_id FROM group_members WHERE group_id = ?`, id)
+	if err != nil {
+		log.Println("Stream members error:", err)
+		return members
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err == nil {
+			members[userID] = true
+		}
+	}
+	return members
+}
+
+// streamHub fans events out to the clients connected to this instance.
+type streamHub struct {
+	mu   sync.Mutex
+	subs map[*streamSubscriber]struct{}
+}
+
+var hub = &streamHub{subs: map[*streamSubscriber]struct{}{}}
+
+// startStreamHub starts delivering events written from now on.
+func startStreamHub() {
+	var since int64
+	db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM stream_events`).Scan(&since)
+	go newStreamBroker().Run(since, hub.dispatch)
+}
+
+func (h *streamHub) subscribe(userID int, events map[int]bool) *streamSubscriber {
+	s := &streamSubscriber{userID: userID, events: events, ch: make(chan StreamEvent, 64), done: make(chan struct{})}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *streamHub) unsubscribe(s *streamSubscriber) {
+	h.mu.Lock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.done)
+	}
+	h.mu.Unlock()
+}
+
+// dispatch hands the event to every interested subscriber. One that has fallen
+// too far behind is dropped; its client reconnects and replays what it missed.
+// Subscribers can go while this runs, which is why ch is never closed.
+func (h *streamHub) dispatch(ev StreamEvent) {
+	h.mu.Lock()
+	subs := make([]*streamSubscriber, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.mu.Unlock()
+	if len(subs) == 0 {
+		return
+	}
+	members := streamGroupMembers(ev)
+	for _, s := range subs {
+		if !s.wants(ev, members) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		case <-s.done:
+		default:
+			h.unsubscribe(s)
+		}
+	}
+}
+
+// pruneStreamEvents drops events older than STREAM_RETENTION. Clients that
+// were away longer get a "reset" and should reload.
+func pruneStreamEvents() error {
+	cutoff := time.Now().Add(-getEnvDuration("STREAM_RETENTION", 24*time.Hour))
+	_, err := db.Exec(`DELETE FROM stream_events WHERE created_at < ?`, sqlTime(cutoff))
+	return err
+}
+
+// --- Stream Handler ---
+
+// Browsers can't set headers on an EventSource, so the stream also takes a
+// token in the query string. URLs end up in access logs, so that token is a
+// short-lived one that can do nothing but open the stream.
+const (
+	scopeStream    = "stream"
+	streamTokenTTL = time.Minute
+)
+
+// CreateStreamTokenHandler issues a token for ?token= on /stream. Clients fetch
+// a fresh one each time they (re)connect.
+func CreateStreamTokenHandler(c *gin.Context) {
+	token, err := issueToken(c.GetInt("userID"), c.GetString("role"), scopeStream, streamTokenTTL)
+	if err != nil {
+		log.Println("CreateStreamToken error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "expiresIn": int(streamTokenTTL.Seconds())})
+}
+
+// streamTokenFromQuery moves a stream token from ?token= into the
+// Authorization header. Session tokens are refused there.
+func streamTokenFromQuery(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" || c.GetHeader("Authorization") != "" {
+		c.Next()
+		return
+	}
+	if claims, err := parseToken(token); err != nil || claims.Scope != scopeStream {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+	c.Request.Header.Set("Authorization", "Bearer "+token)
+	c.Next()
+}
+
+func writeStreamEvent(w gin.ResponseWriter, ev StreamEvent) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, ev.Data)
+	w.Flush()
+	return err
+}
+
+// StreamHandler is a Server-Sent Events stream of my notifications, activity in
+// my groups, and registration counts for the events listed in ?events=1,2,3.
+// Reconnecting with Last-Event-ID (or ?lastEventId=) replays what was missed.
+func StreamHandler(c *gin.Context) {
+	myID := c.GetInt("userID")
+	events := map[int]bool{}
+	if list := c.Query("events"); list != "" {
+		for _, s := range strings.Split(list, ",") {
+			eventID, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "events must be a comma-separated list of event IDs"})
+				return
+			}
+			var visible int
+			db.QueryRow(`SELECT COUNT(*) FROM events e WHERE e.id = ? AND `+eventVisibleClause("e"), eventID, myID, myID, myID).Scan(&visible)
+			if visible > 0 {
+				events[eventID] = true
+			}
+		}
+	}
+	lastID, _ := strconv.ParseInt(c.GetHeader("Last-Event-ID"), 10, 64)
+	if lastID == 0 {
+		lastID, _ = strconv.ParseInt(c.Query("lastEventId"), 10, 64)
+	}
+
+	// Subscribe before replaying so nothing falls in the gap; duplicates are
+	// skipped by id below.
+	sub := hub.subscribe(myID, events)
+	defer hub.unsubscribe(sub)
+
+	w := c.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	w.Flush()
+
+	if lastID > 0 {
+		var oldest int64
+		db.QueryRow(`SELECT COALESCE(MIN(id), 0) FROM stream_events`).Scan(&oldest)
+		if oldest == 0 || lastID < oldest-1 {
+			// Some of what was missed has been pruned; the client must reload.
+			fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+			w.Flush()
+		}
+		for {
+			missed, err := queryStreamEvents(`id > ? AND (user_id = ? OR topic IS NOT NULL)`, 500, lastID, myID)
+			if err != nil {
+				log.Println("Stream replay error:", err)
+				return
+			}
+			for _, ev := range missed {
+				if sub.wants(ev, streamGroupMembers(ev)) {
+					if err := writeStreamEvent(w, ev); err != nil {
+						return
+					}
+				}
+				lastID = ev.ID
+			}
+			if len(missed) < 500 {
+				break
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(getEnvDuration("STREAM_HEARTBEAT", 25*time.Second))
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-sub.done:
+			return
+		case ev := <-sub.ch:
+			if ev.ID <= lastID {
+				continue
+			}
+			if err := writeStreamEvent(w, ev); err != nil {
+				return
+			}
+			lastID = ev.ID
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOTP parameters follow the RFC 6238 defaults understood by every authenticator app.
+const (
+	totpIssuer            = "VMS"
+	totpPeriod            = 30
+	totpDigits            = 6
+	totpSkew              = 1 // accept one step either side for clock drift
+	recoveryCodeCount     = 10
+	twoFactorChallengeTTL = 5 * time.Minute
+
+	scopeTwoFactorChallenge = "2fa-challenge"
+	scopeTwoFactorEnroll    = "2fa-enroll"
+
+	settingRequireOrganizer2FA = "require_2fa_organizers"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactorCodePayload struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+type TwoFactorLoginPayload struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+type SecuritySettings struct {
+	RequireTwoFactorForOrganizers bool `json:"requireTwoFactorForOrganizers"`
+}
+
+// --- TOTP Helpers ---
+func generateTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// verifyTOTP returns the matching time step so callers can reject replays.
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpProvisioningURI(email, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + email)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// replaceRecoveryCodes discards any existing codes and returns a fresh set in plain text.
+// Only hashes are stored, so this is the one time the user can see them.
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(buf))[:10]
+		code := raw[:5] + "-" + raw[5:]
+		if _, err := tx.Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hashRecoveryCode(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery code.
+// Successful TOTP codes advance the stored counter and recovery codes are burned,
+// so neither can be replayed.
+func checkSecondFactor(userID int, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		res, err := db.Exec(`UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`, userID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			return false, err
+		}
+		rowsAffected, _ := res.RowsAffected()
+		return rowsAffected == 1, nil
+	}
+	var secret sql.NullString
+	var lastCounter int64
+	err := db.QueryRow(`SELECT totp_secret, totp_last_counter FROM users WHERE id = ? AND totp_enabled = 1`, userID).Scan(&secret, &lastCounter)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	step, ok := verifyTOTP(secret.String, code, time.Now())
+	if !ok || step <= lastCounter {
+		return false, nil
+	}
+	res, err := db.Exec(`UPDATE users SET totp_last_counter = ? WHERE id = ? AND totp_last_counter < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := res.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// --- Settings Helpers ---
+func getSetting(key, fallback string) string {
+	var value string
+	if err := db.QueryRow(`SELECT value FROM app_settings WHERE key = ?`, key).Scan(&value); err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("getSetting error:", err)
+		}
+		return fallback
+	}
+	return value
+}
+
+func setSetting(key, value string) error {
+	_, err := db.Exec(`INSERT INTO app_settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+func twoFactorRequiredForOrganizers() bool {
+	return getSetting(settingRequireOrganizer2FA, "false") == "true"
+}
+
+// loginResult is what a user who passed the first factor gets: a session, or a
+// token that only lets them finish two-factor login or enrol in it.
+func loginResult(userID int, role string, totpEnabled bool) (gin.H, error) {
+	if totpEnabled {
+		// Step one of two: the first factor checked out, now the client must present a code.
+		challenge, err := issueToken(userID, role, scopeTwoFactorChallenge, twoFactorChallengeTTL)
+		return gin.H{"twoFactorRequired": true, "challengeToken": challenge}, err
+	}
+	if role == "Organizer" && twoFactorRequiredForOrganizers() {
+		enrollToken, err := issueToken(userID, role, scopeTwoFactorEnroll, twoFactorChallengeTTL)
+		return gin.H{"twoFactorSetupRequired": true, "token": enrollToken, "role": role}, err
+	}
+	token, err := issueToken(userID, role, "", sessionTTL)
+	return gin.H{"token": token, "role": role}, err
+}
+
+// --- Two-Factor Handlers ---
+func LoginTwoFactorHandler(c *gin.Context) {
+	var payload TwoFactorLoginPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.ChallengeToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if payload.Code == "" && payload.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A verification code or recovery code is required"})
+		return
+	}
+	claims, err := parseToken(payload.ChallengeToken)
+	if err != nil || claims.Scope != scopeTwoFactorChallenge {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login session expired. Please sign in again."})
+		return
+	}
+	account := fmt.Sprintf("2fa:%d", claims.UserID)
+	if rejectIfThrottled(c, account) {
+		return
+	}
+	ok, err := checkSecondFactor(claims.UserID, payload.Code, payload.RecoveryCode)
+	if err != nil {
+		log.Println("LoginTwoFactor error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !ok {
+		recordLoginFailure(account)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+		return
+	}
+	clearLoginFailures(account)
+	// Read the role again in case it changed during the challenge window.
+	var role string
+	if err := db.QueryRow(`SELECT role FROM users WHERE id = ?`, claims.UserID).Scan(&role); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+	tokenString, err := issueToken(claims.UserID, role, "", sessionTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": tokenString, "role": role})
+}
+func GetTwoFactorStatusHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	role := c.GetString("role")
+	var enabled bool
+	if err := db.QueryRow(`SELECT totp_enabled FROM users WHERE id = ?`, userID).Scan(&enabled); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	var remaining int
+	db.QueryRow(`SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&remaining)
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                enabled,
+		"required":               role == "Organizer" && twoFactorRequiredForOrganizers(),
+		"recoveryCodesRemaining": remaining,
+	})
+}
+func SetupTwoFactorHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	var email string
+	var enabled bool
+	if err := db.QueryRow(`SELECT email, totp_enabled FROM users WHERE id = ?`, userID).Scan(&email, &enabled); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	if _, err := db.Exec(`UPDATE users SET totp_pending_secret = ? WHERE id = ?`, secret, userID); err != nil {
+		log.Println("SetupTwoFactor error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// The frontend renders otpauthUrl as a QR code; the secret is shown for manual entry.
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauthUrl": totpProvisioningURI(email, secret)})
+}
+func EnableTwoFactorHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	role := c.GetString("role")
+	var payload TwoFactorCodePayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification code is required"})
+		return
+	}
+	var pending sql.NullString
+	if err := db.QueryRow(`SELECT totp_pending_secret FROM users WHERE id = ?`, userID).Scan(&pending); err != nil || !pending.Valid || pending.String == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start two-factor setup first"})
+		return
+	}
+	step, ok := verifyTOTP(pending.String, payload.Code, time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verification code"})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("EnableTwoFactor (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	_, err = tx.Exec(`UPDATE users SET totp_secret = totp_pending_secret, totp_pending_secret = NULL, totp_enabled = 1, totp_last_counter = ? WHERE id = ?`, step, userID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("EnableTwoFactor (update) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("EnableTwoFactor (recovery codes) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("EnableTwoFactor (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	response := gin.H{"message": "Two-factor authentication enabled", "recoveryCodes": codes}
+	// Users who were forced into enrollment get their full session now.
+	if c.GetString("scope") == scopeTwoFactorEnroll {
+		tokenString, err := issueToken(userID, role, "", sessionTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+			return
+		}
+		response["token"] = tokenString
+		response["role"] = role
+	}
+	c.JSON(http.StatusOK, response)
+}
+func DisableTwoFactorHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	role := c.GetString("role")
+	if c.GetString("scope") != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor setup required"})
+		return
+	}
+	var payload struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password and verification code are required"})
+		return
+	}
+	if role == "Organizer" && twoFactorRequiredForOrganizers() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for organizers"})
+		return
+	}
+	var passwordHash string
+	if err := db.QueryRow(`SELECT password_hash FROM users WHERE id = ?`, userID).Scan(&passwordHash); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(payload.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+	ok, err := checkSecondFactor(userID, payload.Code, payload.RecoveryCode)
+	if err != nil {
+		log.Println("DisableTwoFactor error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("DisableTwoFactor (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if _, err := tx.Exec(`UPDATE users SET totp_secret = NULL, totp_pending_secret = NULL, totp_enabled = 0, totp_last_counter = 0 WHERE id = ?`, userID); err != nil {
+		tx.Rollback()
+		log.Println("DisableTwoFactor (update) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		tx.Rollback()
+		log.Println("DisableTwoFactor (delete codes) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("DisableTwoFactor (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	userID := c.GetInt("userID")
+	if c.GetString("scope") != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor setup required"})
+		return
+	}
+	var payload TwoFactorCodePayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification code is required"})
+		return
+	}
+	ok, err := checkSecondFactor(userID, payload.Code, "")
+	if err != nil {
+		log.Println("RegenerateRecoveryCodes error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("RegenerateRecoveryCodes (tx begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("RegenerateRecoveryCodes (insert) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("RegenerateRecoveryCodes (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// --- Admin Security Settings Handlers ---
+func GetSecuritySettingsHandler(c *gin.Context) {
+	if c.GetString("role") != "Admin" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+	c.JSON(http.StatusOK, SecuritySettings{RequireTwoFactorForOrganizers: twoFactorRequiredForOrganizers()})
+}
+func UpdateSecuritySettingsHandler(c *gin.Context) {
+	if c.GetString("role") != "Admin" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+	var payload SecuritySettings
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	if err := setSetting(settingRequireOrganizer2FA, fmt.Sprint(payload.RequireTwoFactorForOrganizers)); err != nil {
+		log.Println("UpdateSecuritySettings error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, payload)
+}
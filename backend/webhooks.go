@@ -0,0 +1,682 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Webhook event types a group can subscribe to. "ping" is only ever sent on
+// request, to check an endpoint.
+const (
+	hookRegistrationCreated   = "registration.created"   // {eventId, user}
+	hookRegistrationCancelled = "registration.cancelled" // {eventId, user}
+	hookEventCreated          = "event.created"          // {event}
+	hookEventUpdated          = "event.updated"          // {event, changes}
+	hookMemberJoined          = "group.member_joined"    // {groupId, role, user}
+	hookPing                  = "ping"
+)
+
+var webhookEventTypes = []string{hookRegistrationCreated, hookRegistrationCancelled, hookEventCreated, hookEventUpdated, hookMemberJoined}
+
+// Delivery states. Pending deliveries are retried with exponential backoff
+// until WEBHOOK_MAX_ATTEMPTS is reached, after which they are failed.
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+)
+
+// Webhook posts a group's events to one of its own systems.
+type Webhook struct {
+	ID        int      `json:"id"`
+	GroupID   int      `json:"groupId"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	Secret    string   `json:"secret,omitempty"` // only shown when created
+	CreatedAt string   `json:"createdAt"`
+}
+
+// WebhookDelivery is one event on its way to one webhook.
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	EventID        int             `json:"eventId"`
+	EventType      string          `json:"eventType"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  string          `json:"nextAttemptAt,omitempty"` // while pending
+	ResponseStatus int             `json:"responseStatus,omitempty"`
+	ResponseBody   string          `json:"responseBody,omitempty"` // first KB of the last response
+	Error          string          `json:"error,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+	CreatedAt      string          `json:"createdAt"`
+	CompletedAt    string          `json:"completedAt,omitempty"`
+}
+
+// webhookSubscribers is the SQL for the active webhooks of groupExpr that want
+// events of type kind.
+func webhookSubscribers(groupExpr, kind string) string {
+	return `SELECT id FROM webhooks WHERE group_id = ` + groupExpr + ` AND active = 1
+		AND EXISTS ( SELECT 1 FROM json_each(webhooks.events) WHERE value = '` + kind + `' )`
+}
+
+// webhookTrigger builds a trigger that records an event of type kind for
+// groupExpr and queues a delivery to each subscriber. Within a trigger,
+// last_insert_rowid() is the event just recorded. The trigger is replaced on
+// every start, so payload changes reach existing databases.
+func webhookTrigger(name, on, kind, groupExpr, data string) string {
+	subscribers := webhookSubscribers(groupExpr, kind)
+	return `DROP TRIGGER IF EXISTS webhook_` + name + `;
+	CREATE TRIGGER webhook_` + name + ` ` + on + ` BEGIN
+		INSERT INTO webhook_events (group_id, type, data)
+		SELECT ` + groupExpr + `, '` + kind + `', ` + data + ` WHERE EXISTS ( ` + subscribers + ` );
+		INSERT INTO webhook_deliveries (webhook_id, event_id) SELECT id, last_insert_rowid() FROM ( ` + subscribers + ` );
+	END`
+}
+
+// webhookUserJSON and webhookEventJSON describe a user and an event in payloads.
+// A webhook receiver is outside the app, so it only gets the email of users
+// who show theirs to everyone.
+func webhookUserJSON(idExpr string) string {
+	return `json(( SELECT json_object('id', u.id, 'name', u.name,
+		'email', CASE WHEN COALESCE(p.email_visibility, '` + defaultPrivacySettings.EmailVisibility + `') = '` + audienceEveryone + `' THEN u.email END)
+		FROM users u LEFT JOIN user_privacy p ON p.user_id = u.id WHERE u.id = ` + idExpr + ` ))`
+}
+
+func webhookEventJSON(row string) string {
+	return `json_object('id', ` + row + `.id, 'name', ` + row + `.name, 'date', ` + row + `.date,
+		'startTime', ` + row + `.start_time, 'endTime', ` + row + `.end_time, 'description', ` + row + `.description,
+		'locationAddress', ` + row + `.location_address, 'membersOnly', json(CASE WHEN ` + row + `.members_only THEN 'true' ELSE 'false' END))`
+}
+
+// webhookEventFields are the event columns event.updated reports changes to,
+// under the names eventChanges uses.
+var webhookEventFields = [][2]string{
+	{"name", "name"}, {"date", "date"}, {"start_time", "startTime"}, {"end_time", "endTime"},
+	{"location_address", "locationAddress"}, {"description", "description"},
+}
+
+func webhookEventUpdatedTrigger() string {
+	var columns, changed, names []string
+	for _, f := range webhookEventFields {
+		columns = append(columns, f[0])
+		changed = append(changed, `OLD.`+f[0]+` IS NOT NEW.`+f[0])
+		names = append(names, `SELECT '`+f[1]+`' AS field WHERE OLD.`+f[0]+` IS NOT NEW.`+f[0])
+	}
+	return webhookTrigger("event_updated",
+		`AFTER UPDATE OF `+strings.Join(columns, ", ")+` ON events WHEN NEW.host_group_id IS NOT NULL AND (`+strings.Join(changed, " OR ")+`)`,
+		hookEventUpdated, `NEW.host_group_id`,
+		`json_object('event', `+webhookEventJSON("NEW")+`, 'changes', ( SELECT json_group_array(field) FROM ( `+strings.Join(names, " UNION ALL ")+` ) ))`)
+}
+
+// webhookTriggers queue deliveries as the underlying rows change, the same way
+// streamTriggers feed the live stream. Events follow the group hosting them.
+var webhookTriggers = []string{
+	webhookTrigger("registration_created", `AFTER INSERT ON registrations`, hookRegistrationCreated,
+		`( SELECT host_group_id FROM events WHERE id = NEW.event_id )`,
+		`json_object('eventId', NEW.event_id, 'user', `+webhookUserJSON("NEW.user_id")+`)`),
+	webhookTrigger("registration_cancelled", `AFTER DELETE ON registrations`, hookRegistrationCancelled,
+		`( SELECT host_group_id FROM events WHERE id = OLD.event_id )`,
+		`json_object('eventId', OLD.event_id, 'user', `+webhookUserJSON("OLD.user_id")+`)`),
+	webhookTrigger("event_created", `AFTER INSERT ON events WHEN NEW.host_group_id IS NOT NULL`, hookEventCreated,
+		`NEW.host_group_id`, `json_object('event', `+webhookEventJSON("NEW")+`)`),
+	webhookEventUpdatedTrigger(),
+	webhookTrigger("member_joined", `AFTER INSERT ON group_members`, hookMemberJoined,
+		`NEW.group_id`, `json_object('groupId', NEW.group_id, 'role', NEW.role, 'user', `+webhookUserJSON("NEW.user_id")+`)`),
+}
+
+// --- Delivery ---
+
+// webhookClient refuses to reach private and loopback addresses unless
+// WEBHOOK_ALLOW_PRIVATE is set, which is what local testing needs. The check
+// runs on the resolved address, so DNS can't be used to get around it. It
+// never goes through HTTP_PROXY, where the check would only see the proxy.
+var webhookClient = newWebhookClient(getEnv("WEBHOOK_ALLOW_PRIVATE", "") == "true")
+
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+				return fmt.Errorf("refusing to deliver to %s", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookSignature signs "<timestamp>.<body>" with the webhook's secret.
+// Receivers should recompute it and reject old timestamps.
+func webhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is how long to wait after the given number of failed attempts.
+func webhookBackoff(attempts int) time.Duration {
+	wait := getEnvDuration("WEBHOOK_RETRY_BASE", 30*time.Second)
+	for i := 1; i < attempts && wait < 24*time.Hour; i++ {
+		wait *= 2
+	}
+	return wait
+}
+
+// deliverWebhooks sends every delivery that is due. Each is first leased by
+// pushing its next attempt out, so instances sharing the database don't send
+// the same one at the same time.
+func deliverWebhooks() error {
+	now := sqlTime(time.Now())
+	rows, err := db.Query(`
+		SELECT d.id FROM webhook_deliveries d
+		WHERE d.status = ? AND d.next_attempt_at <= ? ORDER BY d.id LIMIT 100
+	`, deliveryPending, now)
+	if err != nil {
+		return err
+	}
+	var due []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			due = append(due, id)
+		}
+	}
+	rows.Close()
+	for _, id := range due {
+		lease := sqlTime(time.Now().Add(time.Minute))
+		res, err := db.Exec(`
+			UPDATE webhook_deliveries SET next_attempt_at = ?
+			WHERE id = ? AND status = ? AND next_attempt_at <= ?
+		`, lease, id, deliveryPending, now)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if err := attemptDelivery(id); err != nil {
+			log.Printf("Webhook delivery %d error: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// attemptDelivery makes one attempt at a leased delivery and records the outcome.
+func attemptDelivery(deliveryID int) error {
+	var webhookID, eventID, groupID, attempts int
+	var hookURL, secret, kind, data, createdAt string
+	err := db.QueryRow(`
+		SELECT w.id, w.url, w.secret, e.id, e.group_id, e.type, e.data, e.created_at, d.attempts
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		JOIN webhook_events e ON e.id = d.event_id
+		WHERE d.id = ?
+	`, deliveryID).Scan(&webhookID, &hookURL, &secret, &eventID, &groupID, &kind, &data, &createdAt, &attempts)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(struct {
+		ID        int             `json:"id"`
+		Type      string          `json:"type"`
+		GroupID   int             `json:"groupId"`
+		CreatedAt string          `json:"createdAt"`
+		Data      json.RawMessage `json:"data"`
+	}{eventID, kind, groupID, createdAt, json.RawMessage(data)})
+	if err != nil {
+		return err
+	}
+
+	attempts++
+	status, respBody, sendErr := postWebhook(hookURL, secret, deliveryID, eventID, kind, body)
+	var errText string
+	if sendErr != nil {
+		errText = sendErr.Error()
+	} else if status < 200 || status > 299 {
+		errText = fmt.Sprintf("Receiver answered %d", status)
+	}
+	var responseStatus interface{}
+	if status != 0 {
+		responseStatus = status
+	}
+	switch {
+	case errText == "":
+		_, err = db.Exec(`
+			UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, response_body = ?, error = NULL, completed_at = ?
+			WHERE id = ?
+		`, deliverySucceeded, attempts, responseStatus, respBody, sqlTime(time.Now()), deliveryID)
+	case attempts >= getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8):
+		_, err = db.Exec(`
+			UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, response_body = ?, error = ?, completed_at = ?
+			WHERE id = ?
+		`, deliveryFailed, attempts, responseStatus, respBody, errText, sqlTime(time.Now()), deliveryID)
+	default:
+		_, err = db.Exec(`
+			UPDATE webhook_deliveries SET attempts = ?, response_status = ?, response_body = ?, error = ?, next_attempt_at = ?
+			WHERE id = ?
+		`, attempts, responseStatus, respBody, errText, sqlTime(time.Now().Add(webhookBackoff(attempts))), deliveryID)
+	}
+	return err
+}
+
+// postWebhook sends one signed request. It returns the response status and the
+// start of the response body.
+func postWebhook(hookURL, secret string, deliveryID, eventID int, kind string, body []byte) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hookURL, strings.NewReader(string(body)))
+	if err != nil {
+		return 0, "", err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "VMS-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", kind)
+	req.Header.Set("X-Webhook-Id", strconv.Itoa(eventID))
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(deliveryID))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", "sha256="+webhookSignature(secret, timestamp, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, string(snippet), nil
+}
+
+// --- Webhook Handlers ---
+
+// validWebhookURL accepts absolute http and https URLs.
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" && u.User == nil
+}
+
+// validWebhookEvents checks the list is non-empty and names known types.
+func validWebhookEvents(events []string) error {
+	if len(events) == 0 {
+		return errors.New("Pick at least one event")
+	}
+	for _, e := range events {
+		if !containsString(webhookEventTypes, e) {
+			return fmt.Errorf("Unknown event: %s", e)
+		}
+	}
+	return nil
+}
+
+func queryWebhooks(where string, args ...interface{}) ([]Webhook, error) {
+	rows, err := db.Query(`SELECT id, group_id, url, events, active, created_at FROM webhooks WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		var events string
+		if err := rows.Scan(&w.ID, &w.GroupID, &w.URL, &events, &w.Active, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(events), &w.Events)
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+// groupWebhook loads :webhookId for a group the caller administers.
+func groupWebhook(c *gin.Context) (Webhook, bool) {
+	groupID, ok := requireGroupAdmin(c)
+	if !ok {
+		return Webhook{}, false
+	}
+	webhookID, err := strconv.Atoi(c.Param("webhookId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return Webhook{}, false
+	}
+	hooks, err := queryWebhooks(`id = ? AND group_id = ?`, webhookID, groupID)
+	if err != nil {
+		log.Println("groupWebhook error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return Webhook{}, false
+	}
+	if len(hooks) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return Webhook{}, false
+	}
+	return hooks[0], true
+}
+
+func GetGroupWebhooksHandler(c *gin.Context) {
+	groupID, ok := requireGroupAdmin(c)
+	if !ok {
+		return
+	}
+	hooks, err := queryWebhooks(`group_id = ?`, groupID)
+	if err != nil {
+		log.Println("GetGroupWebhooks error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks, "eventTypes": webhookEventTypes})
+}
+
+// CreateGroupWebhookHandler registers an endpoint. The signing secret is in
+// the response and never shown again.
+func CreateGroupWebhookHandler(c *gin.Context) {
+	groupID, ok := requireGroupAdmin(c)
+	if !ok {
+		return
+	}
+	var payload struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	if !validWebhookURL(payload.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The URL must be an absolute http or https URL"})
+		return
+	}
+	if err := validWebhookEvents(payload.Events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	token, err := randomURLToken(24)
+	if err != nil {
+		log.Println("CreateGroupWebhook (secret) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create webhook"})
+		return
+	}
+	secret := "whsec_" + token
+	events, _ := json.Marshal(payload.Events)
+	myID := c.GetInt("userID")
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("CreateGroupWebhook (begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	res, err := tx.Exec(`INSERT INTO webhooks (group_id, url, secret, events, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		groupID, payload.URL, secret, string(events), myID, sqlTime(time.Now()))
+	if err == nil {
+		err = logGroupAction(tx, groupID, myID, auditWebhookCreated, 0, payload.URL)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("CreateGroupWebhook error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("CreateGroupWebhook (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	id, _ := res.LastInsertId()
+	hooks, err := queryWebhooks(`id = ?`, id)
+	if err != nil || len(hooks) == 0 {
+		log.Println("CreateGroupWebhook (reload) error:", err)
+		c.JSON(http.StatusCreated, gin.H{"id": id, "secret": secret})
+		return
+	}
+	hooks[0].Secret = secret
+	c.JSON(http.StatusCreated, hooks[0])
+}
+
+// UpdateGroupWebhookHandler changes the URL, events or active flag. Pausing a
+// webhook stops new deliveries; those already queued still go out.
+func UpdateGroupWebhookHandler(c *gin.Context) {
+	hook, ok := groupWebhook(c)
+	if !ok {
+		return
+	}
+	var payload struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+	if payload.URL != nil {
+		if !validWebhookURL(*payload.URL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The URL must be an absolute http or https URL"})
+			return
+		}
+		hook.URL = *payload.URL
+	}
+	if payload.Events != nil {
+		if err := validWebhookEvents(payload.Events); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		hook.Events = payload.Events
+	}
+	if payload.Active != nil {
+		hook.Active = *payload.Active
+	}
+	events, _ := json.Marshal(hook.Events)
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("UpdateGroupWebhook (begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	_, err = tx.Exec(`UPDATE webhooks SET url = ?, events = ?, active = ? WHERE id = ?`, hook.URL, string(events), hook.Active, hook.ID)
+	if err == nil {
+		err = logGroupAction(tx, hook.GroupID, c.GetInt("userID"), auditWebhookUpdated, 0, hook.URL)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("UpdateGroupWebhook error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("UpdateGroupWebhook (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, hook)
+}
+
+// DeleteGroupWebhookHandler removes the webhook with its delivery log.
+func DeleteGroupWebhookHandler(c *gin.Context) {
+	hook, ok := groupWebhook(c)
+	if !ok {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("DeleteGroupWebhook (begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, hook.ID)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM webhooks WHERE id = ?`, hook.ID)
+	}
+	if err == nil {
+		err = logGroupAction(tx, hook.GroupID, c.GetInt("userID"), auditWebhookDeleted, 0, hook.URL)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("DeleteGroupWebhook error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("DeleteGroupWebhook (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// PingGroupWebhookHandler queues a "ping" event for just this webhook, whether
+// or not it is active, so an endpoint can be tested before going live.
+func PingGroupWebhookHandler(c *gin.Context) {
+	hook, ok := groupWebhook(c)
+	if !ok {
+		return
+	}
+	data, _ := json.Marshal(gin.H{"webhookId": hook.ID})
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("PingGroupWebhook (begin) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	res, err := tx.Exec(`INSERT INTO webhook_events (group_id, type, data) VALUES (?, ?, ?)`, hook.GroupID, hookPing, string(data))
+	var deliveryID int64
+	if err == nil {
+		eventID, _ := res.LastInsertId()
+		res, err = tx.Exec(`INSERT INTO webhook_deliveries (webhook_id, event_id) VALUES (?, ?)`, hook.ID, eventID)
+		if err == nil {
+			deliveryID, _ = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("PingGroupWebhook error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("PingGroupWebhook (commit) error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"deliveryId": deliveryID})
+}
+
+// GetWebhookDeliveriesHandler pages through the delivery log, newest first.
+// It takes ?status=, ?before=<delivery id> and ?limit=.
+func GetWebhookDeliveriesHandler(c *gin.Context) {
+	hook, ok := groupWebhook(c)
+	if !ok {
+		return
+	}
+	where := []string{`d.webhook_id = ?`}
+	args := []interface{}{hook.ID}
+	if status := c.Query("status"); status != "" {
+		where = append(where, `d.status = ?`)
+		args = append(args, status)
+	}
+	if before, err := strconv.Atoi(c.Query("before")); err == nil {
+		where = append(where, `d.id < ?`)
+		args = append(args, before)
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		limit = 50
+	}
+	rows, err := db.Query(`
+		SELECT d.id, e.id, e.type, d.status, d.attempts, d.next_attempt_at, d.response_status, d.response_body, d.error,
+		       e.data, d.created_at, d.completed_at
+		FROM webhook_deliveries d JOIN webhook_events e ON e.id = d.event_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY d.id DESC LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		log.Println("GetWebhookDeliveries error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var nextAttempt, body, errText, completedAt sql.NullString
+		var status sql.NullInt64
+		var data string
+		if err := rows.Scan(&d.ID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &nextAttempt, &status, &body, &errText,
+			&data, &d.CreatedAt, &completedAt); err != nil {
+			log.Println("GetWebhookDeliveries scan error:", err)
+			continue
+		}
+		if d.Status == deliveryPending {
+			d.NextAttemptAt = nextAttempt.String
+		}
+		d.ResponseStatus = int(status.Int64)
+		d.ResponseBody, d.Error, d.CompletedAt = body.String, errText.String, completedAt.String
+		d.Payload = json.RawMessage(data)
+		deliveries = append(deliveries, d)
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// RedeliverWebhookHandler queues the delivery's event again as a new delivery,
+// keeping the old one in the log. Receivers see the same X-Webhook-Id.
+func RedeliverWebhookHandler(c *gin.Context) {
+	hook, ok := groupWebhook(c)
+	if !ok {
+		return
+	}
+	deliveryID, err := strconv.Atoi(c.Param("deliveryId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+	res, err := db.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, event_id)
+		SELECT webhook_id, event_id FROM webhook_deliveries WHERE id = ? AND webhook_id = ?
+	`, deliveryID, hook.ID)
+	if err != nil {
+		log.Println("RedeliverWebhook error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	newID, _ := res.LastInsertId()
+	c.JSON(http.StatusAccepted, gin.H{"deliveryId": newID})
+}
+
+// pruneWebhookDeliveries drops finished deliveries older than
+// WEBHOOK_RETENTION, and events nothing refers to any more.
+func pruneWebhookDeliveries() error {
+	cutoff := sqlTime(time.Now().Add(-getEnvDuration("WEBHOOK_RETENTION", 30*24*time.Hour)))
+	_, err := db.Exec(`DELETE FROM webhook_deliveries WHERE status != ? AND created_at < ?`, deliveryPending, cutoff)
+	if err == nil {
+		_, err = db.Exec(`DELETE FROM webhook_events WHERE id NOT IN ( SELECT event_id FROM webhook_deliveries )`)
+	}
+	return err
+}
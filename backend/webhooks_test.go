@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookReceiver records what it is sent and answers with the next status
+// in its list, then 200 once the list runs out.
+type webhookReceiver struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	rec := &webhookReceiver{statuses: statuses}
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, receivedWebhook{r.Header.Clone(), body})
+		status := http.StatusOK
+		if len(rec.statuses) > 0 {
+			status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+		}
+		rec.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rec.server.Close)
+	return rec
+}
+
+func (rec *webhookReceiver) received(t *testing.T, n int) []receivedWebhook {
+	t.Helper()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.requests) != n {
+		t.Fatalf("expected %d requests, got %d", n, len(rec.requests))
+	}
+	return rec.requests
+}
+
+// setupWebhookTest gives group 1, administered by the returned user, a
+// webhook for member joins pointing at rec.
+func setupWebhookTest(t *testing.T, rec *webhookReceiver) (adminID int, secret string) {
+	setupTestDB(t)
+	webhookClient = newWebhookClient(true)
+	t.Cleanup(func() { webhookClient = newWebhookClient(false) })
+	t.Setenv("WEBHOOK_RETRY_BASE", "1m")
+
+	adminID = createTestUser(t, "Admin", "admin@example.org", "Organizer")
+	secret = "whsec_test"
+	db.Exec(`INSERT INTO groups (id, name, created_by_user_id) VALUES (1, 'Group', ?)`, adminID)
+	db.Exec(`INSERT INTO group_members (group_id, user_id, role) VALUES (1, ?, 'admin')`, adminID)
+	if _, err := db.Exec(`INSERT INTO webhooks (group_id, url, secret, events) VALUES (1, ?, ?, ?)`,
+		rec.server.URL, secret, `["`+hookMemberJoined+`"]`); err != nil {
+		t.Fatal(err)
+	}
+	return adminID, secret
+}
+
+func deliveryState(t *testing.T, id int) (status string, attempts int, nextAttempt time.Time) {
+	t.Helper()
+	var next string
+	if err := db.QueryRow(`SELECT status, attempts, next_attempt_at FROM webhook_deliveries WHERE id = ?`, id).Scan(&status, &attempts, &next); err != nil {
+		t.Fatal(err)
+	}
+	nextAttempt, _ = time.Parse(time.RFC3339, next)
+	if nextAttempt.IsZero() {
+		nextAttempt, _ = time.Parse("2006-01-02 15:04:05", next)
+	}
+	return status, attempts, nextAttempt
+}
+
+func TestWebhookDeliveryIsSignedAndHidesEmail(t *testing.T) {
+	rec := newWebhookReceiver(t)
+	_, secret := setupWebhookTest(t, rec)
+	userID := createTestUser(t, "Vol", "vol@example.org", "Volunteer")
+	db.Exec(`INSERT INTO group_members (group_id, user_id, role) VALUES (1, ?, 'member')`, userID)
+
+	if err := deliverWebhooks(); err != nil {
+		t.Fatal(err)
+	}
+	req := rec.received(t, 1)[0]
+	timestamp, err := strconv.ParseInt(req.header.Get("X-Webhook-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatalf("bad timestamp header: %v", err)
+	}
+	if got, want := req.header.Get("X-Webhook-Signature"), "sha256="+webhookSignature(secret, timestamp, req.body); got != want {
+		t.Fatalf("signature %q, want %q", got, want)
+	}
+	if req.header.Get("X-Webhook-Event") != hookMemberJoined {
+		t.Fatalf("unexpected event header %q", req.header.Get("X-Webhook-Event"))
+	}
+	var payload struct {
+		Type string
+		Data struct {
+			User map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Data.User["name"] != "Vol" || payload.Data.User["email"] != nil {
+		t.Fatalf("expected the user without their email, got %v", payload.Data.User)
+	}
+
+	// Someone who shows their email to everyone has it included.
+	shownID := createTestUser(t, "Shown", "shown@example.org", "Volunteer")
+	db.Exec(`INSERT INTO user_privacy (user_id, email_visibility, phone_visibility, registrations_visibility, followers_visibility, skills_visibility)
+		VALUES (?, 'everyone', 'everyone', 'everyone', 'everyone', 'everyone')`, shownID)
+	db.Exec(`INSERT INTO group_members (group_id, user_id, role) VALUES (1, ?, 'member')`, shownID)
+	deliverWebhooks()
+	json.Unmarshal(rec.received(t, 2)[1].body, &payload)
+	if payload.Data.User["email"] != "shown@example.org" {
+		t.Fatalf("expected the public email, got %v", payload.Data.User)
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	rec := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	setupWebhookTest(t, rec)
+	userID := createTestUser(t, "Vol", "vol@example.org", "Volunteer")
+	db.Exec(`INSERT INTO group_members (group_id, user_id, role) VALUES (1, ?, 'member')`, userID)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		before := time.Now()
+		deliverWebhooks()
+		status, attempts, next := deliveryState(t, 1)
+		if status != deliveryPending || attempts != attempt {
+			t.Fatalf("attempt %d: status %s after %d attempts", attempt, status, attempts)
+		}
+		wait := webhookBackoff(attempt)
+		if wait != time.Duration(1<<(attempt-1))*time.Minute {
+			t.Fatalf("attempt %d: backoff %s", attempt, wait)
+		}
+		if next.Before(before.Add(wait).Add(-2*time.Second)) || next.After(time.Now().Add(wait).Add(2*time.Second)) {
+			t.Fatalf("attempt %d: next attempt at %s, expected about %s from now", attempt, next, wait)
+		}
+		// Not due yet, so nothing is sent.
+		deliverWebhooks()
+		rec.received(t, attempt)
+		db.Exec(`UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = 1`, sqlTime(time.Now().Add(-time.Second)))
+	}
+	deliverWebhooks()
+	if status, attempts, _ := deliveryState(t, 1); status != deliverySucceeded || attempts != 3 {
+		t.Fatalf("expected success on the third attempt, got %s after %d", status, attempts)
+	}
+}
+
+func TestWebhookGivesUpAfterMaxAttempts(t *testing.T) {
+	rec := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	setupWebhookTest(t, rec)
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "2")
+	userID := createTestUser(t, "Vol", "vol@example.org", "Volunteer")
+	db.Exec(`INSERT INTO group_members (group_id, user_id, role) VALUES (1, ?, 'member')`, userID)
+
+	deliverWebhooks()
+	db.Exec(`UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = 1`, sqlTime(time.Now().Add(-time.Second)))
+	deliverWebhooks()
+	if status, attempts, _ := deliveryState(t, 1); status != deliveryFailed || attempts != 2 {
+		t.Fatalf("expected failure after 2 attempts, got %s after %d", status, attempts)
+	}
+}
+
+func TestWebhookRedelivery(t *testing.T) {
+	rec := newWebhookReceiver(t)
+	adminID, _ := setupWebhookTest(t, rec)
+	userID := createTestUser(t, "Vol", "vol@example.org", "Volunteer")
+	db.Exec(`INSERT INTO group_members (group_id, user_id, role) VALUES (1, ?, 'member')`, userID)
+	deliverWebhooks()
+
+	r := gin.New()
+	r.POST("/groups/:id/webhooks/:webhookId/deliveries/:deliveryId/redeliver", func(c *gin.Context) {
+		c.Set("userID", adminID)
+		c.Set("role", "Organizer")
+	}, RedeliverWebhookHandler)
+	redeliver := func(deliveryID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/groups/1/webhooks/1/deliveries/"+deliveryID+"/redeliver", nil))
+		return w
+	}
+	if w := redeliver("99"); w.Code != http.StatusNotFound {
+		t.Fatalf("redelivering an unknown delivery: status %d", w.Code)
+	}
+	w := redeliver("1")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("redeliver: status %d: %s", w.Code, w.Body)
+	}
+	deliverWebhooks()
+
+	reqs := rec.received(t, 2)
+	if reqs[0].header.Get("X-Webhook-Id") != reqs[1].header.Get("X-Webhook-Id") {
+		t.Fatal("a redelivery should carry the same event id")
+	}
+	if reqs[0].header.Get("X-Webhook-Delivery") == reqs[1].header.Get("X-Webhook-Delivery") {
+		t.Fatal("a redelivery should be a new delivery")
+	}
+	if string(reqs[0].body) != string(reqs[1].body) {
+		t.Fatal("a redelivery should send the same payload")
+	}
+	if status, _, _ := deliveryState(t, 2); status != deliverySucceeded {
+		t.Fatalf("redelivery ended %s", status)
+	}
+}